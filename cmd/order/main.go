@@ -1,16 +1,26 @@
 package main
 
 import (
+	"app/internal/api"
+	"app/internal/auth"
 	"app/internal/biance"
+	"app/internal/dedupe"
 	"app/internal/kafka"
 	"app/internal/logger"
 	"app/internal/model"
+	"app/internal/store"
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/shopspring/decimal"
 )
 
 type Config struct {
@@ -19,15 +29,125 @@ type Config struct {
 	BianceApiSecretKey string `envconfig:"BIANCE_API_SECTER_KEY"`
 	NewOrdersTopic     string `envconfig:"NEW_ORDERS_TOPIC"`
 	ReadyOrdersTopic   string `envconfig:"READY_ORDERS_TOPIC"`
-	KafkaUrl           string `envconfig:"KAFKA_URL"`
-	BianceUrl          string `envconfig:"BIANCE_URL"`
+	DeadLetterTopic    string `envconfig:"DEAD_LETTER_TOPIC" default:"dead-letter-orders"`
+	OrderEventsTopic   string `envconfig:"ORDER_EVENTS_TOPIC" default:"order-events"`
+	// Топик отчетов об исполнении из потока пользовательских данных
+	ExecutionReportsTopic string `envconfig:"EXECUTION_REPORTS_TOPIC" default:"execution-reports"`
+	// Задержки топиков повтора и максимальное число попыток выполнения команды
+	RetryDelays      []time.Duration `envconfig:"RETRY_DELAYS" default:"5s,30s,5m"`
+	RetryMaxAttempts int             `envconfig:"RETRY_MAX_ATTEMPTS" default:"5"`
+	KafkaUrl         string          `envconfig:"KAFKA_URL"`
+	KafkaGroupID     string          `envconfig:"KAFKA_GROUP_ID" default:"order-service"`
+	BianceUrl        string          `envconfig:"BIANCE_URL"`
+	// Режим торговли: live — ордера исполняются на Binance, paper — на симуляторе по ценам Binance или из записи
+	TradingMode string `envconfig:"TRADING_MODE" default:"live"`
+	// Бумажная торговля: начальные балансы (USDT:10000,BTC:0.5), комиссии maker и taker, символы (пусто — все),
+	// файл записанных цен CSV time,symbol,price (пусто — цены биржи) и период обновления цен
+	PaperBalances      map[string]decimal.Decimal `envconfig:"PAPER_BALANCES" default:"USDT:10000"`
+	PaperMakerFee      decimal.Decimal            `envconfig:"PAPER_MAKER_FEE" default:"0.001"`
+	PaperTakerFee      decimal.Decimal            `envconfig:"PAPER_TAKER_FEE" default:"0.001"`
+	PaperSymbols       []string                   `envconfig:"PAPER_SYMBOLS"`
+	PaperPricesPath    string                     `envconfig:"PAPER_PRICES_PATH"`
+	PaperPriceInterval time.Duration              `envconfig:"PAPER_PRICE_INTERVAL" default:"5s"`
+	// Стратегии, команды place_order которых только проверяются на бирже через /api/v3/order/test
+	DryRunStrategies []int64 `envconfig:"DRY_RUN_STRATEGIES"`
+	// Адрес websocket потока пользовательских данных без listenKey. Пустой адрес отключает поток
+	BianceStreamUrl string `envconfig:"BIANCE_STREAM_URL" default:"wss://stream.binance.com:9443/ws"`
+	// Период продления listenKey
+	UserStreamKeepalive time.Duration `envconfig:"USER_STREAM_KEEPALIVE" default:"30m"`
 
+	// Минимальная пауза между запросами к Binance. Запросы распределяются по лимитам веса и числа ордеров биржи,
+	// поэтому пауза может быть нулевой
 	BianceRequestPauseMilli int `envconfig:"Biance_Request_Pause_Mili"`
+	// Срок действия подписанного запроса к Binance и период синхронизации времени с биржей
+	BianceRecvWindow time.Duration `envconfig:"BIANCE_RECV_WINDOW" default:"5s"`
+	TimeSyncInterval time.Duration `envconfig:"TIME_SYNC_INTERVAL" default:"1m"`
+	// Файл с результатами выполненных команд для распознавания повторной доставки и срок их хранения
+	DedupeStorePath string        `envconfig:"DEDUPE_STORE_PATH" default:"dedupe.jsonl"`
+	DedupeTTL       time.Duration `envconfig:"DEDUPE_TTL" default:"24h"`
+	// Строка подключения к PostgreSQL для хранения ордеров. Пустая строка отключает хранилище
+	DatabaseDsn string `envconfig:"DATABASE_DSN"`
+	// Период опроса очереди публикации результатов и срок хранения опубликованных результатов
+	OutboxInterval  time.Duration `envconfig:"OUTBOX_INTERVAL" default:"1s"`
+	OutboxRetention time.Duration `envconfig:"OUTBOX_RETENTION" default:"24h"`
+	// Период сверки ордеров из хранилища с биржей. Сверка выполняется и при запуске, если задано хранилище
+	ReconcileInterval time.Duration `envconfig:"RECONCILE_INTERVAL" default:"5m"`
+	// Адрес HTTP API. Пустой адрес отключает API. По умолчанию API доступно только с локальной машины
+	HttpAddr string `envconfig:"HTTP_ADDR" default:"127.0.0.1:8080"`
+	// Разрешить HTTP API без JWT_SECRET. Без этого флага API без ключа подписи токенов не запускается
+	HttpAllowUnauthenticated bool `envconfig:"HTTP_ALLOW_UNAUTHENTICATED" default:"false"`
+	// Ключ подписи токенов JWT. Пустой ключ отключает проверку токенов в HTTP API
+	JwtSecret string `envconfig:"JWT_SECRET"`
+	// Файл учетных записей, которым выдаются токены, и срок действия токена
+	AuthCredentialsPath string        `envconfig:"AUTH_CREDENTIALS_PATH" default:"credentials.json"`
+	AuthTokenTTL        time.Duration `envconfig:"AUTH_TOKEN_TTL" default:"1h"`
+	// Проверять токен в заголовке x-auth-token команд из NEW_ORDERS_TOPIC
+	KafkaRequireToken bool `envconfig:"KAFKA_REQUIRE_TOKEN" default:"false"`
+	// Период обновления правил торговли из exchangeInfo и округление цены и количества до tickSize/stepSize
+	SymbolRulesRefresh time.Duration `envconfig:"SYMBOL_RULES_REFRESH" default:"1h"`
+	SymbolRulesRound   bool          `envconfig:"SYMBOL_RULES_ROUND" default:"false"`
+	// Файл лимитов стратегий для проверки рисков и период загрузки балансов аккаунта. Пустой путь отключает проверку
+	RiskLimitsPath     string        `envconfig:"RISK_LIMITS_PATH"`
+	RiskBalanceRefresh time.Duration `envconfig:"RISK_BALANCE_REFRESH" default:"1m"`
+	// Время на плавную остановку сервиса
+	ShutdownTimeoutSec int `envconfig:"SHUTDOWN_TIMEOUT_SEC" default:"30"`
 }
 
+// String выводит конфигурацию без секретов, чтобы ее можно было писать в лог
+func (c Config) String() string {
+	c.BianceApiSecretKey = redacted(c.BianceApiSecretKey)
+	c.DatabaseDsn = redacted(c.DatabaseDsn)
+	c.JwtSecret = redacted(c.JwtSecret)
+	// plain без метода String, иначе %+v вызовет его снова
+	type plain Config
+	return fmt.Sprintf("%+v", plain(c))
+}
+
+// redacted скрывает непустое значение секрета
+func redacted(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "***"
+}
+
+// Подкоманды cmd/order. Без аргументов запускается сервис обработки ордеров.
+const (
+	commandServe        = "serve"
+	commandLimits       = "limits"
+	commandHashPassword = "hash-password"
+)
+
+// Режимы торговли TRADING_MODE
+const (
+	tradingModeLive  = "live"
+	tradingModePaper = "paper"
+)
+
 func main() {
 	var err error
 
+	command := commandServe
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+	if command != commandServe && command != commandLimits && command != commandHashPassword {
+		log.Fatalf("Неизвестная команда %q. Доступные команды: %s, %s, %s", command, commandServe, commandLimits, commandHashPassword)
+	}
+
+	if command == commandHashPassword {
+		// Хэш пароля для файла учетных записей, конфигурация не нужна
+		if len(os.Args) < 3 {
+			log.Fatalf("Использование: %s %s <пароль>", os.Args[0], commandHashPassword)
+		}
+		hash, err := auth.HashPassword(os.Args[2])
+		if err != nil {
+			log.Fatal("Ошибка хэширования пароля: ", err)
+		}
+		fmt.Println(hash)
+		return
+	}
+
 	// Загружаем .env файл
 	err = godotenv.Load()
 	if err != nil {
@@ -41,7 +161,10 @@ func main() {
 		log.Fatal("Ошибка чтения конфигурации из переменных окружения: ", err)
 	}
 
-	fmt.Printf("Загружена конфигурация: %+v\n", config)
+	fmt.Printf("Загружена конфигурация: %v\n", config)
+	if config.TradingMode != tradingModeLive && config.TradingMode != tradingModePaper {
+		log.Fatalf("Неизвестный режим торговли %q. Доступные режимы: %s, %s", config.TradingMode, tradingModeLive, tradingModePaper)
+	}
 
 	// Создание логгера
 	logger.Log, err = logger.NewLogger(config.LoggerLevel)
@@ -49,19 +172,252 @@ func main() {
 		panic(err)
 	}
 
+	if command == commandLimits {
+		bianceManager, err := biance.NewBianceManager(config.BianceUrl, config.BianceApiPublicKey, config.BianceApiSecretKey, time.Duration(config.BianceRequestPauseMilli)*time.Millisecond, nil)
+		handlerError(err)
+		bianceManager.CheckAndTestLimits()
+		return
+	}
+
+	dedupeStore, err := dedupe.NewStore(config.DedupeStorePath, config.DedupeTTL)
+	handlerError(err)
+	defer dedupeStore.Close()
+
+	var bianceManager *biance.BianceManager
+	var paper *biance.PaperTrading
+	if config.TradingMode == tradingModePaper {
+		paper, err = biance.NewPaperTrading(context.Background(), biance.PaperConfig{
+			URL:           config.BianceUrl,
+			Symbols:       config.PaperSymbols,
+			Balances:      config.PaperBalances,
+			MakerFee:      config.PaperMakerFee,
+			TakerFee:      config.PaperTakerFee,
+			PricesPath:    config.PaperPricesPath,
+			PriceInterval: config.PaperPriceInterval,
+		})
+		handlerError(err)
+		bianceManager, err = biance.NewBianceManagerWithExchange(paper.Simulator, time.Duration(config.BianceRequestPauseMilli)*time.Millisecond, dedupeStore)
+	} else {
+		bianceManager, err = biance.NewBianceManager(config.BianceUrl, config.BianceApiPublicKey, config.BianceApiSecretKey, time.Duration(config.BianceRequestPauseMilli)*time.Millisecond, dedupeStore)
+	}
+	handlerError(err)
+
+	if len(config.DryRunStrategies) > 0 {
+		bianceManager.EnableDryRun(config.DryRunStrategies)
+	}
+
+	var orderStore *store.Store
+	if config.DatabaseDsn != "" {
+		orderStore, err = store.Open(config.DatabaseDsn)
+		handlerError(err)
+		defer orderStore.Close()
+		bianceManager.EnableStore(orderStore)
+	}
+
+	serve(config, bianceManager, orderStore, paper)
+}
+
+// serve запускает сервис: читает команды из NEW_ORDERS_TOPIC, выполняет их на бирже
+// и публикует результаты в READY_ORDERS_TOPIC до получения SIGINT/SIGTERM. Если задано хранилище orderStore,
+// результаты публикуются через очередь в базе данных. Если paper не nil, биржей служит его симулятор.
+func serve(config Config, bianceManager *biance.BianceManager, orderStore *store.Store, paper *biance.PaperTrading) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Фоновые задачи, которые останавливаются вместе с сервисом
+	backgroundCtx, cancelBackground := context.WithCancel(context.Background())
+	defer cancelBackground()
+	var background sync.WaitGroup
+
+	if paper != nil {
+		// Цены для симулятора
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			paper.Run(backgroundCtx)
+		}()
+	}
+
+	err := bianceManager.EnableTimeSync(backgroundCtx, config.TimeSyncInterval, config.BianceRecvWindow)
+	handlerError(err)
+
+	err = bianceManager.EnableSymbolRules(backgroundCtx, config.SymbolRulesRefresh, config.SymbolRulesRound)
+	handlerError(err)
+
+	if config.RiskLimitsPath != "" {
+		limits, err := biance.LoadRiskLimits(config.RiskLimitsPath)
+		handlerError(err)
+		err = bianceManager.EnableRiskChecks(backgroundCtx, limits, config.RiskBalanceRefresh)
+		handlerError(err)
+	}
+
+	var authenticator *auth.Authenticator
+	if config.JwtSecret != "" {
+		credentials, err := auth.LoadCredentials(config.AuthCredentialsPath)
+		handlerError(err)
+		authenticator, err = auth.NewAuthenticator(config.JwtSecret, config.AuthTokenTTL, credentials, orderStore)
+		handlerError(err)
+	} else if config.KafkaRequireToken {
+		handlerError(fmt.Errorf("для KAFKA_REQUIRE_TOKEN нужен JWT_SECRET"))
+	} else if config.HttpAddr != "" && !config.HttpAllowUnauthenticated {
+		handlerError(fmt.Errorf("для HTTP API нужен JWT_SECRET; для API без токенов задайте HTTP_ALLOW_UNAUTHENTICATED=true"))
+	}
+	var kafkaAuthenticator *auth.Authenticator
+	if config.KafkaRequireToken {
+		kafkaAuthenticator = authenticator
+	}
+
 	newOrders := make(chan model.Order)
-	kafka, err := kafka.NewKafkaManager(config.NewOrdersTopic, config.ReadyOrdersTopic, config.KafkaUrl, newOrders)
+	readyOrders := make(chan model.Order)
+	orderEvents := make(chan model.OrderEvent, 100)
+	bianceManager.EnableEvents(orderEvents)
+	executionReports := make(chan model.ExecutionReport, 100)
+
+	kafka, err := kafka.NewKafkaManager(kafka.Config{
+		BrokerAddress:        config.KafkaUrl,
+		GroupID:              config.KafkaGroupID,
+		NewOrderTopic:        config.NewOrdersTopic,
+		ReadyOrderTopic:      config.ReadyOrdersTopic,
+		DeadLetterTopic:      config.DeadLetterTopic,
+		OrderEventsTopic:     config.OrderEventsTopic,
+		ExecutionReportTopic: config.ExecutionReportsTopic,
+		Retry: kafka.RetryPolicy{
+			Delays:      config.RetryDelays,
+			MaxAttempts: config.RetryMaxAttempts,
+		},
+		Store:           orderStore,
+		OutboxInterval:  config.OutboxInterval,
+		OutboxRetention: config.OutboxRetention,
+		Authenticator:   kafkaAuthenticator,
+	}, newOrders)
 	handlerError(err)
 
+	// Выполнение ордеров через ограничитель запросов
+	processingDone := make(chan struct{})
+	go func() {
+		defer close(processingDone)
+		bianceManager.ProcessOrders(newOrders, readyOrders)
+	}()
+	// Публикация результатов выполнения
+	go kafka.StartWritingKafka(readyOrders)
+	if orderStore != nil {
+		// Публикация результатов из очереди в базе данных
+		go kafka.StartRelay()
+	}
+	// Публикация событий смены статуса ордеров
+	go kafka.StartWritingEvents(orderEvents)
+	// Исполнения и отмены ордеров на бирже
+	go kafka.StartWritingReports(executionReports)
+	userStreamCtx, stopUserStream := context.WithCancel(context.Background())
+	defer stopUserStream()
+	userStreamDone := make(chan struct{})
+	if config.BianceStreamUrl != "" || paper != nil {
+		userStreamDone = bianceManager.EnableUserStream(userStreamCtx, config.BianceStreamUrl, config.UserStreamKeepalive, executionReports)
+	} else {
+		close(userStreamDone)
+	}
+	if orderStore != nil {
+		// Сверка с биржей до чтения новых команд: за время простоя ордера могли исполниться или отмениться
+		err = bianceManager.EnableReconciliation(backgroundCtx, config.ReconcileInterval)
+		handlerError(err)
+	}
 	// Чтение из канала новых сообщений кафки
 	go kafka.StartReadingKafka()
+	// Команды через HTTP API
+	var apiServer *api.Server
+	if config.HttpAddr != "" {
+		apiServer = api.NewServer(config.HttpAddr, bianceManager, kafka, orderStore)
+		if authenticator != nil {
+			apiServer.EnableAuth(authenticator)
+		} else {
+			logger.Log.Warn("JWT_SECRET не задан, HTTP_ALLOW_UNAUTHENTICATED: HTTP API доступно без токена")
+		}
+		go apiServer.Start()
+	}
 
-	bianceManager, err := biance.NewBianceManager(config.BianceUrl, config.BianceApiPublicKey, config.BianceApiSecretKey, time.Duration(config.BianceRequestPauseMilli)*time.Millisecond)
-	if err != nil {
-		handlerError(err)
+	logger.Log.Info("Сервис обработки ордеров запущен")
+	<-ctx.Done()
+	stop()
+	logger.Log.Info("Получен сигнал завершения, остановка сервиса")
+
+	shutdown(config, kafka, bianceManager, pipeline{
+		readyOrders:      readyOrders,
+		orderEvents:      orderEvents,
+		executionReports: executionReports,
+		processingDone:   processingDone,
+		stopBackground:   cancelBackground,
+		background:       &background,
+		stopUserStream:   stopUserStream,
+		userStreamDone:   userStreamDone,
+		apiServer:        apiServer,
+	})
+	if paper != nil {
+		paper.LogReports()
+	}
+}
+
+// pipeline каналы между частями сервиса и сигналы их завершения
+type pipeline struct {
+	readyOrders      chan model.Order
+	orderEvents      chan model.OrderEvent
+	executionReports chan model.ExecutionReport
+	processingDone   chan struct{}
+	// stopBackground и background останавливают и ждут фоновые задачи на backgroundCtx
+	stopBackground context.CancelFunc
+	background     *sync.WaitGroup
+	stopUserStream context.CancelFunc
+	userStreamDone chan struct{}
+	// apiServer HTTP API. nil, если API отключено
+	apiServer *api.Server
+}
+
+// shutdown останавливает сервис по шагам: прекращает чтение кафки и прием HTTP запросов, выполняет или отклоняет ордера,
+// уже поставленные в очередь, останавливает фоновые задачи, публикует результаты, фиксирует смещения и закрывает соединения.
+// Повторный сигнал во время остановки завершает процесс немедленно.
+func shutdown(config Config, kafka *kafka.OrderKafka, bianceManager *biance.BianceManager, p pipeline) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeoutSec)*time.Second)
+	defer cancel()
+
+	go func() {
+		sigchan := make(chan os.Signal, 1)
+		signal.Notify(sigchan, os.Interrupt, syscall.SIGTERM)
+		<-sigchan
+		logger.Log.Error("Повторный сигнал завершения, остановка без ожидания")
+		os.Exit(1)
+	}()
+
+	// 1. Больше не читаем новые сообщения, запросы API и события биржи
+	kafka.StopReading()
+	if p.apiServer != nil {
+		if err := p.apiServer.Shutdown(ctx); err != nil {
+			logger.Log.Error("Ошибка при остановке HTTP API: ", err)
+		}
+	}
+	p.stopUserStream()
+	<-p.userStreamDone
+
+	// 2. Дожидаемся выполнения принятых ордеров. По истечении времени оставшиеся запросы отклоняются
+	select {
+	case <-p.processingDone:
+	case <-ctx.Done():
+	}
+	bianceManager.Shutdown(ctx)
+	<-p.processingDone
+
+	// 3. Останавливаем фоновые задачи: сверка публикует события, поэтому каналы закрываются после нее
+	p.stopBackground()
+	p.background.Wait()
+	bianceManager.WaitBackground()
+
+	// 4. Публикуем результаты, фиксируем смещения и закрываем соединения
+	close(p.readyOrders)
+	close(p.orderEvents)
+	close(p.executionReports)
+	if err := kafka.Shutdown(ctx); err != nil {
+		logger.Log.Error("Ошибка при остановке кафки: ", err)
 	}
 
-	bianceManager.CheckAndTestLimits()
+	logger.Log.Info("Сервис остановлен")
 }
 
 func handlerError(err error) {
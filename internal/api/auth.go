@@ -0,0 +1,145 @@
+package api
+
+import (
+	"app/internal/auth"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// claimsKey ключ утверждений токена в контексте запроса
+type claimsKey struct{}
+
+// tokenRequest тело POST /auth/token
+type tokenRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// tokenResponse выданный токен
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// revokeRequest тело POST /auth/revoke
+type revokeRequest struct {
+	ID string `json:"id"`
+}
+
+// EnableAuth включает проверку токенов: запросы к ордерам требуют заголовка Authorization: Bearer <токен>,
+// а ордера доступны, только если их стратегия и символ разрешены владельцу токена
+func (s *Server) EnableAuth(authenticator *auth.Authenticator) {
+	s.auth = authenticator
+}
+
+// authenticate пропускает запрос с действительным токеном и кладет его утверждения в контекст.
+// Если проверка токенов не включена, пропускает все запросы
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.auth == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			writeError(w, http.StatusUnauthorized, errors.New("нужен токен в заголовке Authorization: Bearer"))
+			return
+		}
+		claims, err := s.auth.Verify(token)
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+	})
+}
+
+// claimsFrom возвращает утверждения токена запроса. ok равен false, если проверка токенов не включена
+func claimsFrom(r *http.Request) (auth.Claims, bool) {
+	claims, ok := r.Context().Value(claimsKey{}).(auth.Claims)
+	return claims, ok
+}
+
+// authorize проверяет, что владельцу токена разрешены стратегия и символ ордера. Если нет, отвечает 403
+// и возвращает false
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, strategyID int64, symbol string) bool {
+	claims, ok := claimsFrom(r)
+	if !ok || claims.Allows(strategyID, symbol) {
+		return true
+	}
+	writeError(w, http.StatusForbidden, fmt.Errorf("%s не разрешено работать со стратегией %d по символу %s", claims.Subject, strategyID, symbol))
+	return false
+}
+
+// issueToken POST /auth/token выдает токен по имени и паролю учетной записи
+func (s *Server) issueToken(w http.ResponseWriter, r *http.Request) {
+	if s.auth == nil {
+		writeError(w, http.StatusNotFound, errors.New("проверка токенов не включена"))
+		return
+	}
+	var req tokenRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	token, claims, err := s.auth.Issue(req.Name, req.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokenResponse{
+		Token:     token,
+		ID:        claims.Id,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC(),
+	})
+}
+
+// revokeOwnToken DELETE /auth/token отзывает токен, с которым пришел запрос
+func (s *Server) revokeOwnToken(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFrom(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("проверка токенов не включена"))
+		return
+	}
+	s.revoke(w, claims.Id)
+}
+
+// revokeToken POST /auth/revoke отзывает любой токен по его id. Доступно только оператору
+func (s *Server) revokeToken(w http.ResponseWriter, r *http.Request) {
+	claims, ok := claimsFrom(r)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("проверка токенов не включена"))
+		return
+	}
+	if !claims.IsOperator() {
+		writeError(w, http.StatusForbidden, errors.New("отзывать чужие токены может только оператор"))
+		return
+	}
+	var req revokeRequest
+	if err := decodeBody(w, r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.ID == "" {
+		writeError(w, http.StatusBadRequest, errors.New("не указан id токена"))
+		return
+	}
+	s.revoke(w, req.ID)
+}
+
+func (s *Server) revoke(w http.ResponseWriter, id string) {
+	if err := s.auth.Revoke(id); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
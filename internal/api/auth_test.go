@@ -0,0 +1,159 @@
+package api
+
+import (
+	"app/internal/auth"
+	"app/internal/model"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testAuth сервис токенов с оператором и стратегией 1, которой разрешен только BTCUSDT
+func testAuth(t *testing.T) *auth.Authenticator {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := auth.NewAuthenticator("secret", time.Hour, map[string]auth.Credential{
+		"operator": {Name: "operator", PasswordHash: string(hash), Role: auth.RoleOperator},
+		"strategy": {Name: "strategy", PasswordHash: string(hash), Role: auth.RoleStrategy, Strategies: []int64{1}, Symbols: []string{"BTCUSDT"}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func issueToken(t *testing.T, handler http.Handler, name string) tokenResponse {
+	t.Helper()
+	resp := do(t, handler, http.MethodPost, "/auth/token", "", tokenRequest{Name: name, Password: "password"})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("токен не выдан: %d %s", resp.Code, resp.Body)
+	}
+	var token tokenResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &token); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthRequired(t *testing.T) {
+	orderStore := newTestStore(t)
+	saveOrder(t, orderStore, "own", 1, "BTCUSDT")
+	saveOrder(t, orderStore, "foreign", 2, "BTCUSDT")
+	executor := &fakeExecutor{}
+	s := NewServer("", executor, &fakePublisher{}, orderStore)
+	s.EnableAuth(testAuth(t))
+	handler := s.routes()
+
+	if resp := do(t, handler, http.MethodPost, "/auth/token", "", tokenRequest{Name: "strategy", Password: "wrong"}); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("код %d, ожидался 401", resp.Code)
+	}
+	strategy := issueToken(t, handler, "strategy").Token
+	operator := issueToken(t, handler, "operator").Token
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   interface{}
+		status int
+	}{
+		{"без токена", http.MethodGet, "/orders/own", "", nil, http.StatusUnauthorized},
+		{"недействительный токен", http.MethodGet, "/orders/own", "token", nil, http.StatusUnauthorized},
+		{"свой ордер", http.MethodGet, "/orders/own", strategy, nil, http.StatusOK},
+		{"чужой ордер", http.MethodGet, "/orders/foreign", strategy, nil, http.StatusForbidden},
+		{"оператор", http.MethodGet, "/orders/foreign", operator, nil, http.StatusOK},
+		{"отмена чужого ордера", http.MethodDelete, "/orders/foreign", strategy, nil, http.StatusForbidden},
+		{"размещение по чужой стратегии", http.MethodPost, "/orders", strategy, `{"strategy_id":2,"symbol":"BTCUSDT"}`, http.StatusForbidden},
+		{"размещение по чужому символу", http.MethodPost, "/orders", strategy, `{"strategy_id":1,"symbol":"ETHUSDT"}`, http.StatusForbidden},
+		{"список чужой стратегии", http.MethodGet, "/orders?strategy_id=2", strategy, nil, http.StatusForbidden},
+		{"метрики стратегии", http.MethodGet, "/debug/vars", strategy, nil, http.StatusForbidden},
+		{"метрики оператора", http.MethodGet, "/debug/vars", operator, nil, http.StatusOK},
+		{"отзыв чужого токена", http.MethodPost, "/auth/revoke", strategy, revokeRequest{ID: "id"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := do(t, handler, tt.method, tt.path, tt.token, tt.body); resp.Code != tt.status {
+				t.Fatalf("код %d, ожидался %d: %s", resp.Code, tt.status, resp.Body)
+			}
+		})
+	}
+	if len(executor.commands) != 0 {
+		t.Fatalf("выполнено %d неразрешенных команд", len(executor.commands))
+	}
+}
+
+// Список ордеров стратегии ограничен ее стратегиями, даже если фильтр не задан
+func TestListOrdersRestrictedToToken(t *testing.T) {
+	orderStore := newTestStore(t)
+	saveOrder(t, orderStore, "own", 1, "BTCUSDT")
+	saveOrder(t, orderStore, "own-eth", 1, "ETHUSDT")
+	saveOrder(t, orderStore, "foreign", 2, "BTCUSDT")
+	s := NewServer("", &fakeExecutor{}, &fakePublisher{}, orderStore)
+	s.EnableAuth(testAuth(t))
+	handler := s.routes()
+
+	tests := []struct {
+		name   string
+		user   string
+		query  string
+		orders int
+	}{
+		{"стратегия", "strategy", "", 1},
+		{"оператор", "operator", "", 3},
+		{"оператор по стратегии", "operator", "?strategy_id=1", 2},
+		{"оператор с limit", "operator", "?limit=1", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := do(t, handler, http.MethodGet, "/orders"+tt.query, issueToken(t, handler, tt.user).Token, nil)
+			if resp.Code != http.StatusOK {
+				t.Fatalf("код %d: %s", resp.Code, resp.Body)
+			}
+			var orders []model.Order
+			if err := json.Unmarshal(resp.Body.Bytes(), &orders); err != nil {
+				t.Fatal(err)
+			}
+			if len(orders) != tt.orders {
+				t.Fatalf("ордеров %d, ожидалось %d", len(orders), tt.orders)
+			}
+		})
+	}
+
+	operator := issueToken(t, handler, "operator").Token
+	for _, query := range []string{"?limit=0", "?limit=5000", "?strategy_id=x"} {
+		if resp := do(t, handler, http.MethodGet, "/orders"+query, operator, nil); resp.Code != http.StatusBadRequest {
+			t.Fatalf("%s: код %d, ожидался 400", query, resp.Code)
+		}
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	s := NewServer("", &fakeExecutor{}, &fakePublisher{}, nil)
+	s.EnableAuth(testAuth(t))
+	handler := s.routes()
+
+	own := issueToken(t, handler, "strategy")
+	if resp := do(t, handler, http.MethodDelete, "/auth/token", own.Token, nil); resp.Code != http.StatusNoContent {
+		t.Fatalf("код %d: %s", resp.Code, resp.Body)
+	}
+	if resp := do(t, handler, http.MethodPost, "/orders", own.Token, `{"strategy_id":1,"symbol":"BTCUSDT"}`); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("отозванный токен принят: код %d", resp.Code)
+	}
+
+	// Оператор отзывает чужой токен по id
+	other := issueToken(t, handler, "strategy")
+	operator := issueToken(t, handler, "operator").Token
+	if resp := do(t, handler, http.MethodPost, "/auth/revoke", operator, revokeRequest{ID: other.ID}); resp.Code != http.StatusNoContent {
+		t.Fatalf("код %d: %s", resp.Code, resp.Body)
+	}
+	if resp := do(t, handler, http.MethodPost, "/orders", other.Token, `{"strategy_id":1,"symbol":"BTCUSDT"}`); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("отозванный оператором токен принят: код %d", resp.Code)
+	}
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"app/internal/biance"
+	"app/internal/logger"
+	"app/internal/model"
+	"app/internal/store"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// Максимальный размер тела запроса
+	maxBodySize = 1 << 20
+	// Число ордеров в ответе GET /orders по умолчанию и максимальное
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// Действия команд, которые создают ордер на бирже
+var orderActions = []string{biance.PlaceOrder, biance.EditOrder}
+
+// errorResponse тело ответа с ошибкой
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// placeOrder POST /orders размещает ордер. Тело и результат такие же, как у команды place_order из кафки
+func (s *Server) placeOrder(w http.ResponseWriter, r *http.Request) {
+	var order model.Order
+	if err := decodeBody(w, r, &order); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	order.Action = biance.PlaceOrder
+	if !s.authorize(w, r, order.StrategyID, order.Symbol) {
+		return
+	}
+	s.execute(w, order, http.StatusCreated)
+}
+
+// getOrder GET /orders/{id} возвращает ордер по client_order_id
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	order, ok := s.findOrder(w, mux.Vars(r)["id"])
+	if !ok || !s.authorize(w, r, order.StrategyID, order.Symbol) {
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// listOrders GET /orders?strategy_id=&symbol=&status=&limit= возвращает ордера, начиная с последних.
+// В status можно перечислить несколько статусов через запятую
+func (s *Server) listOrders(w http.ResponseWriter, r *http.Request) {
+	if !s.requireStore(w) {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := store.OrderFilter{
+		Symbol:  query.Get("symbol"),
+		Actions: orderActions,
+		Limit:   defaultListLimit,
+	}
+	if value := query.Get("strategy_id"); value != "" {
+		strategyID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("некорректный strategy_id: %q", value))
+			return
+		}
+		filter.StrategyID = strategyID
+	}
+	if value := query.Get("status"); value != "" {
+		filter.Statuses = strings.Split(value, ",")
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("некорректный limit: %q, допустимо от 1 до %d", value, maxListLimit))
+			return
+		}
+		filter.Limit = limit
+	}
+	if !s.restrictFilter(w, r, &filter) {
+		return
+	}
+
+	orders, err := s.store.Orders(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+// restrictFilter ограничивает поиск стратегиями и символами, разрешенными владельцу токена. Возвращает false,
+// если ответ уже отправлен: 403 для неразрешенных стратегии или символа, пустой список, если стратегий нет
+func (s *Server) restrictFilter(w http.ResponseWriter, r *http.Request, filter *store.OrderFilter) bool {
+	claims, ok := claimsFrom(r)
+	if !ok || claims.IsOperator() {
+		return true
+	}
+	if filter.StrategyID != 0 && !claims.AllowsStrategy(filter.StrategyID) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s не разрешено работать со стратегией %d", claims.Subject, filter.StrategyID))
+		return false
+	}
+	if filter.Symbol != "" && !claims.AllowsSymbol(filter.Symbol) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("%s не разрешено работать с символом %s", claims.Subject, filter.Symbol))
+		return false
+	}
+	if len(claims.Strategies) == 0 {
+		// Стратегий нет, значит и ордеров для просмотра нет
+		writeJSON(w, http.StatusOK, []model.Order{})
+		return false
+	}
+	filter.StrategyIDs = claims.Strategies
+	filter.Symbols = claims.Symbols
+	return true
+}
+
+// editOrder PATCH /orders/{id} заменяет ордер новым через cancel-replace. Поля тела заменяют поля текущего ордера,
+// символ и стратегия не меняются. Результат такой же, как у команды edit_order из кафки
+func (s *Server) editOrder(w http.ResponseWriter, r *http.Request) {
+	current, ok := s.findOrder(w, mux.Vars(r)["id"])
+	if !ok || !s.authorize(w, r, current.StrategyID, current.Symbol) {
+		return
+	}
+
+	order := model.Order{
+		Side:        current.Side,
+		Type:        current.Type,
+		TimeInForce: current.TimeInForce,
+		Quantity:    current.Quantity,
+		Price:       current.Price,
+		StopPrice:   current.StopPrice,
+		IcebergQty:  current.IcebergQty,
+	}
+	if err := decodeBody(w, r, &order); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	order.Action = biance.EditOrder
+	order.Symbol = current.Symbol
+	order.StrategyID = current.StrategyID
+	order.BinanceID = current.BinanceID
+	s.execute(w, order, http.StatusOK)
+}
+
+// cancelOrder DELETE /orders/{id} отменяет ордер. Результат такой же, как у команды cancel_orders из кафки
+func (s *Server) cancelOrder(w http.ResponseWriter, r *http.Request) {
+	current, ok := s.findOrder(w, mux.Vars(r)["id"])
+	if !ok || !s.authorize(w, r, current.StrategyID, current.Symbol) {
+		return
+	}
+	s.execute(w, model.Order{
+		Action:     biance.CancelOrder,
+		Symbol:     current.Symbol,
+		StrategyID: current.StrategyID,
+		BinanceID:  current.BinanceID,
+	}, http.StatusOK)
+}
+
+// execute выполняет команду, публикует результат и отвечает им. Код ответа successStatus, если команда выполнена,
+// 422, если она отклонена при проверке или биржей, и 503 при временной ошибке, после которой ее можно повторить
+// с тем же client_order_id
+func (s *Server) execute(w http.ResponseWriter, order model.Order, successStatus int) {
+	if order.ClientOrderID == "" {
+		order.ClientOrderID = newClientOrderID()
+	}
+
+	result := s.executor.Execute(order)
+	// Результат публикуется, даже если клиент уже не ждет ответа
+	if err := s.publisher.PublishResult(context.Background(), result); err != nil {
+		logger.Log.Error(fmt.Sprintf("Ошибка при публикации результата ордера %s (%s): %v", result.ClientOrderID, result.Action, err))
+	}
+
+	status := successStatus
+	switch {
+	case result.Retryable:
+		status = http.StatusServiceUnavailable
+	case result.OrderApiStatus == model.OrderApiStatusError:
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, result)
+}
+
+// findOrder ищет ордер по client_order_id. Если ордер не найден, отвечает ошибкой и возвращает false
+func (s *Server) findOrder(w http.ResponseWriter, clientOrderID string) (model.Order, bool) {
+	if !s.requireStore(w) {
+		return model.Order{}, false
+	}
+	orders, err := s.store.Orders(store.OrderFilter{ClientOrderID: clientOrderID, Actions: orderActions, Limit: 1})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return model.Order{}, false
+	}
+	if len(orders) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Errorf("ордер %s не найден", clientOrderID))
+		return model.Order{}, false
+	}
+	return orders[0], true
+}
+
+// requireStore отвечает ошибкой и возвращает false, если хранилище не задано
+func (s *Server) requireStore(w http.ResponseWriter) bool {
+	if s.store == nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Errorf("хранилище ордеров не настроено"))
+		return false
+	}
+	return true
+}
+
+// newClientOrderID формирует идентификатор ордера для команды без client_order_id. Длина 36 символов
+func newClientOrderID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return "api-" + hex.EncodeToString(id)
+}
+
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v); err != nil {
+		return fmt.Errorf("ошибка разбора тела запроса: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Log.Error("Ошибка при отправке ответа: ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
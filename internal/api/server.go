@@ -0,0 +1,93 @@
+package api
+
+import (
+	"app/internal/auth"
+	"app/internal/logger"
+	"app/internal/model"
+	"app/internal/store"
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Executor выполняет команду над ордером: проверка, ограничитель запросов, биржа
+type Executor interface {
+	Execute(order model.Order) model.Order
+}
+
+// Publisher публикует результат выполнения команды в топик готовых ордеров
+type Publisher interface {
+	PublishResult(ctx context.Context, order model.Order) error
+}
+
+// Server HTTP API для размещения, просмотра, редактирования и отмены ордеров. Команды выполняются так же,
+// как команды из кафки, и их результаты публикуются в топик готовых ордеров.
+// Ордер в путях /orders/{id} задается своим client_order_id.
+type Server struct {
+	executor  Executor
+	publisher Publisher
+	// store хранилище ордеров для поиска. Если nil, доступно только размещение
+	store *store.Store
+	// auth проверка токенов. nil, пока не вызван EnableAuth
+	auth   *auth.Authenticator
+	server *http.Server
+}
+
+// NewServer создает сервер, который будет слушать адрес addr
+func NewServer(addr string, executor Executor, publisher Publisher, orderStore *store.Store) *Server {
+	s := Server{
+		executor:  executor,
+		publisher: publisher,
+		store:     orderStore,
+	}
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return &s
+}
+
+func (s *Server) routes() http.Handler {
+	router := mux.NewRouter()
+	router.HandleFunc("/auth/token", s.issueToken).Methods(http.MethodPost)
+
+	authenticated := router.NewRoute().Subrouter()
+	authenticated.Use(s.authenticate)
+	authenticated.HandleFunc("/debug/vars", s.debugVars).Methods(http.MethodGet)
+	authenticated.HandleFunc("/auth/token", s.revokeOwnToken).Methods(http.MethodDelete)
+	authenticated.HandleFunc("/auth/revoke", s.revokeToken).Methods(http.MethodPost)
+	authenticated.HandleFunc("/orders", s.placeOrder).Methods(http.MethodPost)
+	authenticated.HandleFunc("/orders", s.listOrders).Methods(http.MethodGet)
+	authenticated.HandleFunc("/orders/{id}", s.getOrder).Methods(http.MethodGet)
+	authenticated.HandleFunc("/orders/{id}", s.editOrder).Methods(http.MethodPatch)
+	authenticated.HandleFunc("/orders/{id}", s.cancelOrder).Methods(http.MethodDelete)
+	return router
+}
+
+// debugVars GET /debug/vars метрики expvar. Метрики раскрывают результаты всех стратегий, поэтому при включенной
+// проверке токенов доступны только оператору
+func (s *Server) debugVars(w http.ResponseWriter, r *http.Request) {
+	if claims, ok := claimsFrom(r); ok && !claims.IsOperator() {
+		writeError(w, http.StatusForbidden, errors.New("метрики доступны только оператору"))
+		return
+	}
+	expvar.Handler().ServeHTTP(w, r)
+}
+
+// Start принимает запросы до вызова Shutdown
+func (s *Server) Start() {
+	logger.Log.Info("HTTP API слушает ", s.server.Addr)
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Log.Error("Ошибка HTTP API: ", err)
+	}
+}
+
+// Shutdown прекращает прием запросов и ждет завершения начатых до истечения ctx
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"app/internal/biance"
+	"app/internal/logger"
+	"app/internal/model"
+	"app/internal/store"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewConsoleLogger()
+	os.Exit(m.Run())
+}
+
+// fakeExecutor запоминает команды и возвращает результат result, по умолчанию успешный
+type fakeExecutor struct {
+	mu       sync.Mutex
+	commands []model.Order
+	result   func(order model.Order) model.Order
+}
+
+func (e *fakeExecutor) Execute(order model.Order) model.Order {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.commands = append(e.commands, order)
+	if e.result != nil {
+		return e.result(order)
+	}
+	order.OrderApiStatus = model.OrderApiStatusSuccess
+	order.Status = model.StatusNew
+	order.BinanceID = 100
+	return order
+}
+
+type fakePublisher struct {
+	mu        sync.Mutex
+	published []model.Order
+}
+
+func (p *fakePublisher) PublishResult(ctx context.Context, order model.Order) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.published = append(p.published, order)
+	return nil
+}
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "orders.db")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// saveOrder сохраняет размещенный ордер стратегии strategyID
+func saveOrder(t *testing.T, s *store.Store, clientOrderID string, strategyID int64, symbol string) {
+	t.Helper()
+	order := model.Order{
+		ClientOrderID:   clientOrderID,
+		Action:          biance.PlaceOrder,
+		StrategyID:      strategyID,
+		Symbol:          symbol,
+		Side:            "BUY",
+		Type:            "LIMIT",
+		TimeInForce:     "GTC",
+		Quantity:        decimal.NewFromInt(1),
+		Price:           decimal.NewFromInt(100),
+		Status:          model.StatusReceived,
+		StatusUpdatedAt: time.Now().UTC(),
+	}
+	if _, err := s.SaveCommand(order); err != nil {
+		t.Fatal(err)
+	}
+	order.Status = model.StatusNew
+	order.OrderApiStatus = model.OrderApiStatusSuccess
+	order.BinanceID = strategyID * 1000
+	if err := s.SaveResult(order, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func do(t *testing.T, handler http.Handler, method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestPlaceOrderStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		result func(order model.Order) model.Order
+		body   string
+		status int
+	}{
+		{"ордер размещен", nil, `{"symbol":"BTCUSDT","side":"BUY","type":"MARKET","quantity":"1"}`, http.StatusCreated},
+		{"ордер отклонен", func(order model.Order) model.Order {
+			order.OrderApiStatus = model.OrderApiStatusError
+			return order
+		}, `{"symbol":"BTCUSDT"}`, http.StatusUnprocessableEntity},
+		{"временная ошибка", func(order model.Order) model.Order {
+			order.OrderApiStatus = model.OrderApiStatusError
+			order.Retryable = true
+			return order
+		}, `{"symbol":"BTCUSDT"}`, http.StatusServiceUnavailable},
+		{"некорректное тело", nil, `{"symbol":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := &fakeExecutor{result: tt.result}
+			publisher := &fakePublisher{}
+			s := NewServer("", executor, publisher, nil)
+
+			resp := do(t, s.routes(), http.MethodPost, "/orders", "", tt.body)
+			if resp.Code != tt.status {
+				t.Fatalf("код %d, ожидался %d: %s", resp.Code, tt.status, resp.Body)
+			}
+			if tt.status == http.StatusBadRequest {
+				if len(executor.commands) != 0 {
+					t.Fatal("некорректная команда выполнена")
+				}
+				return
+			}
+			command := executor.commands[0]
+			if command.Action != biance.PlaceOrder || !strings.HasPrefix(command.ClientOrderID, "api-") || len(command.ClientOrderID) != 36 {
+				t.Fatalf("команда %s, client_order_id %q", command.Action, command.ClientOrderID)
+			}
+			// Результат публикуется так же, как результат команды из кафки
+			if len(publisher.published) != 1 || publisher.published[0].ClientOrderID != command.ClientOrderID {
+				t.Fatalf("опубликовано %d результатов", len(publisher.published))
+			}
+		})
+	}
+}
+
+func TestOrdersRequireStore(t *testing.T) {
+	s := NewServer("", &fakeExecutor{}, &fakePublisher{}, nil)
+	if resp := do(t, s.routes(), http.MethodGet, "/orders/order-1", "", nil); resp.Code != http.StatusServiceUnavailable {
+		t.Fatalf("код %d, ожидался 503", resp.Code)
+	}
+}
+
+func TestEditAndCancelKeepOrderIdentity(t *testing.T) {
+	orderStore := newTestStore(t)
+	saveOrder(t, orderStore, "order-1", 1, "BTCUSDT")
+	executor := &fakeExecutor{}
+	s := NewServer("", executor, &fakePublisher{}, orderStore)
+	handler := s.routes()
+
+	resp := do(t, handler, http.MethodPatch, "/orders/order-1", "", `{"price":"101","symbol":"ETHUSDT","strategy_id":2}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("код %d: %s", resp.Code, resp.Body)
+	}
+	edit := executor.commands[0]
+	if edit.Action != biance.EditOrder || edit.Symbol != "BTCUSDT" || edit.StrategyID != 1 || edit.BinanceID != 1000 {
+		t.Fatalf("редактирование изменило ордер: %+v", edit)
+	}
+	if !edit.Price.Equal(decimal.NewFromInt(101)) || !edit.Quantity.Equal(decimal.NewFromInt(1)) || edit.Side != "BUY" {
+		t.Fatalf("поля ордера не перенесены: цена %s, количество %s, сторона %s", edit.Price, edit.Quantity, edit.Side)
+	}
+
+	if resp := do(t, handler, http.MethodDelete, "/orders/order-1", "", nil); resp.Code != http.StatusOK {
+		t.Fatalf("код %d: %s", resp.Code, resp.Body)
+	}
+	if cancel := executor.commands[1]; cancel.Action != biance.CancelOrder || cancel.BinanceID != 1000 || cancel.StrategyID != 1 {
+		t.Fatalf("отмена %+v", cancel)
+	}
+
+	if resp := do(t, handler, http.MethodDelete, "/orders/unknown", "", nil); resp.Code != http.StatusNotFound {
+		t.Fatalf("код %d, ожидался 404", resp.Code)
+	}
+}
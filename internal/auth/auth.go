@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"app/internal/model"
+	"app/internal/store"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("неверное имя или пароль")
+	ErrTokenRevoked       = errors.New("токен отозван")
+)
+
+// Claims утверждения токена: владелец, роль и стратегии и символы, с которыми ему разрешено работать
+type Claims struct {
+	jwt.StandardClaims
+	Role       string   `json:"role"`
+	Strategies []int64  `json:"strategies,omitempty"`
+	Symbols    []string `json:"symbols,omitempty"`
+}
+
+// IsOperator сообщает, что токен выдан оператору
+func (c Claims) IsOperator() bool {
+	return c.Role == RoleOperator
+}
+
+// Allows сообщает, можно ли владельцу токена управлять ордерами стратегии strategyID по символу symbol
+// и просматривать их. Пустой symbol проверяет только стратегию
+func (c Claims) Allows(strategyID int64, symbol string) bool {
+	if c.IsOperator() {
+		return true
+	}
+	return c.AllowsStrategy(strategyID) && (symbol == "" || c.AllowsSymbol(symbol))
+}
+
+// AllowsStrategy сообщает, разрешена ли владельцу токена стратегия strategyID
+func (c Claims) AllowsStrategy(strategyID int64) bool {
+	if c.IsOperator() {
+		return true
+	}
+	for _, id := range c.Strategies {
+		if id == strategyID {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsSymbol сообщает, разрешен ли владельцу токена символ symbol
+func (c Claims) AllowsSymbol(symbol string) bool {
+	if c.IsOperator() || len(c.Symbols) == 0 {
+		return true
+	}
+	for _, s := range c.Symbols {
+		if s == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator выдает и проверяет токены JWT, подписанные HMAC SHA-256. Токен выдается по имени и паролю
+// учетной записи и действует ttl. Отозванные токены сохраняются в хранилище, если оно задано,
+// и остаются отозванными после перезапуска.
+type Authenticator struct {
+	secret      []byte
+	ttl         time.Duration
+	credentials map[string]Credential
+	// store хранилище отозванных токенов. Может быть nil
+	store *store.Store
+
+	mu sync.Mutex
+	// revoked отозванные токены и время окончания их действия
+	revoked map[string]time.Time
+}
+
+// NewAuthenticator создает сервис токенов с ключом подписи secret
+func NewAuthenticator(secret string, ttl time.Duration, credentials map[string]Credential, orderStore *store.Store) (*Authenticator, error) {
+	if secret == "" {
+		return nil, errors.New("не задан ключ подписи токенов")
+	}
+	a := Authenticator{
+		secret:      []byte(secret),
+		ttl:         ttl,
+		credentials: credentials,
+		store:       orderStore,
+		revoked:     make(map[string]time.Time),
+	}
+	if orderStore != nil {
+		revoked, err := orderStore.RevokedTokens(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		a.revoked = revoked
+	}
+	return &a, nil
+}
+
+// Issue выдает токен учетной записи name, если пароль верный
+func (a *Authenticator) Issue(name, password string) (string, Claims, error) {
+	credential, ok := a.credentials[name]
+	if !ok {
+		return "", Claims{}, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(credential.PasswordHash), []byte(password)); err != nil {
+		return "", Claims{}, ErrInvalidCredentials
+	}
+
+	now := time.Now()
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{
+			Id:        newTokenID(),
+			Subject:   credential.Name,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(a.ttl).Unix(),
+		},
+		Role:       credential.Role,
+		Strategies: credential.Strategies,
+		Symbols:    credential.Symbols,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret)
+	if err != nil {
+		return "", Claims{}, fmt.Errorf("ошибка подписи токена: %v", err)
+	}
+	return token, claims, nil
+}
+
+// Verify проверяет подпись, срок действия и отзыв токена и возвращает его утверждения
+func (a *Authenticator) Verify(token string) (Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("неподдерживаемый алгоритм подписи %v", t.Header["alg"])
+		}
+		return a.secret, nil
+	})
+	if err != nil {
+		return Claims{}, fmt.Errorf("недействительный токен: %v", err)
+	}
+	if claims.Id == "" || claims.ExpiresAt == 0 {
+		return Claims{}, errors.New("недействительный токен: нет идентификатора или срока действия")
+	}
+
+	a.mu.Lock()
+	_, revoked := a.revoked[claims.Id]
+	a.mu.Unlock()
+	if revoked {
+		return Claims{}, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// Revoke отзывает токен id. Токен хранится отозванным, пока может действовать токен, выданный сейчас
+func (a *Authenticator) Revoke(id string) error {
+	expiresAt := time.Now().Add(a.ttl)
+	if a.store != nil {
+		if err := a.store.RevokeToken(id, expiresAt); err != nil {
+			return err
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.revoked[id] = expiresAt
+	// Истекшие токены не пройдут проверку и без отзыва
+	for revokedID, expires := range a.revoked {
+		if time.Now().After(expires) {
+			delete(a.revoked, revokedID)
+		}
+	}
+	return nil
+}
+
+// AuthorizeOrder проверяет токен и то, что его владельцу разрешена стратегия и символ ордера
+func (a *Authenticator) AuthorizeOrder(token string, order model.Order) error {
+	if token == "" {
+		return errors.New("нет токена")
+	}
+	claims, err := a.Verify(token)
+	if err != nil {
+		return err
+	}
+	if !claims.Allows(order.StrategyID, order.Symbol) {
+		return fmt.Errorf("%s не разрешено работать со стратегией %d по символу %s", claims.Subject, order.StrategyID, order.Symbol)
+	}
+	return nil
+}
+
+// newTokenID случайный идентификатор токена, по которому он отзывается
+func newTokenID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
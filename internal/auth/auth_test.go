@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"app/internal/model"
+	"app/internal/store"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/glebarez/sqlite"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+const testSecret = "test-secret"
+
+func testCredentials(t *testing.T) map[string]Credential {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Credential{
+		"operator": {Name: "operator", PasswordHash: string(hash), Role: RoleOperator},
+		"strategy": {Name: "strategy", PasswordHash: string(hash), Role: RoleStrategy, Strategies: []int64{1}, Symbols: []string{"BTCUSDT"}},
+	}
+}
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "orders.db")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestIssueAndVerify(t *testing.T) {
+	a, err := NewAuthenticator(testSecret, time.Hour, testCredentials(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Issue("strategy", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("ошибка %v, ожидалась ErrInvalidCredentials", err)
+	}
+	if _, _, err := a.Issue("unknown", "password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("ошибка %v, ожидалась ErrInvalidCredentials", err)
+	}
+
+	token, issued, err := a.Issue("strategy", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := a.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Id != issued.Id || claims.Subject != "strategy" || claims.Role != RoleStrategy || len(claims.Strategies) != 1 {
+		t.Fatalf("утверждения токена %+v", claims)
+	}
+
+	if _, err := NewAuthenticator("", time.Hour, nil, nil); err == nil {
+		t.Fatal("создан сервис токенов без ключа подписи")
+	}
+}
+
+func TestVerifyRejectsForeignTokens(t *testing.T) {
+	a, err := NewAuthenticator(testSecret, time.Hour, testCredentials(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	valid := jwt.StandardClaims{Id: "id", Subject: "strategy", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+	sign := func(method jwt.SigningMethod, secret string, claims jwt.StandardClaims) string {
+		token, err := jwt.NewWithClaims(method, Claims{StandardClaims: claims, Role: RoleOperator}).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	expired := valid
+	expired.ExpiresAt = now.Add(-time.Minute).Unix()
+	noExpiry := valid
+	noExpiry.ExpiresAt = 0
+	noID := valid
+	noID.Id = ""
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"чужой ключ", sign(jwt.SigningMethodHS256, "other", valid)},
+		{"другой алгоритм", sign(jwt.SigningMethodHS512, testSecret, valid)},
+		{"истекший токен", sign(jwt.SigningMethodHS256, testSecret, expired)},
+		{"без срока действия", sign(jwt.SigningMethodHS256, testSecret, noExpiry)},
+		{"без идентификатора", sign(jwt.SigningMethodHS256, testSecret, noID)},
+		{"не токен", "token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := a.Verify(tt.token); err == nil {
+				t.Fatal("токен принят")
+			}
+		})
+	}
+	if _, err := a.Verify(sign(jwt.SigningMethodHS256, testSecret, valid)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Отозванный токен остается отозванным после перезапуска, если задано хранилище
+func TestRevokeSurvivesRestart(t *testing.T) {
+	orderStore := newTestStore(t)
+	credentials := testCredentials(t)
+	a, err := NewAuthenticator(testSecret, time.Hour, credentials, orderStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, claims, err := a.Issue("strategy", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := a.Issue("strategy", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Revoke(claims.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Verify(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("ошибка %v, ожидалась ErrTokenRevoked", err)
+	}
+	if _, err := a.Verify(other); err != nil {
+		t.Fatalf("отозван другой токен: %v", err)
+	}
+
+	restarted, err := NewAuthenticator(testSecret, time.Hour, credentials, orderStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restarted.Verify(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("после перезапуска ошибка %v, ожидалась ErrTokenRevoked", err)
+	}
+}
+
+func TestClaimsAllows(t *testing.T) {
+	operator := Claims{Role: RoleOperator}
+	strategy := Claims{Role: RoleStrategy, Strategies: []int64{1, 2}, Symbols: []string{"BTCUSDT"}}
+	anySymbol := Claims{Role: RoleStrategy, Strategies: []int64{1}}
+	tests := []struct {
+		name       string
+		claims     Claims
+		strategyID int64
+		symbol     string
+		allowed    bool
+	}{
+		{"оператор", operator, 5, "ETHUSDT", true},
+		{"своя стратегия и символ", strategy, 2, "BTCUSDT", true},
+		{"чужая стратегия", strategy, 3, "BTCUSDT", false},
+		{"чужой символ", strategy, 1, "ETHUSDT", false},
+		{"только стратегия", strategy, 1, "", true},
+		{"любой символ", anySymbol, 1, "ETHUSDT", true},
+		{"стратегия без номера", anySymbol, 0, "BTCUSDT", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := tt.claims.Allows(tt.strategyID, tt.symbol); allowed != tt.allowed {
+				t.Fatalf("разрешено %v, ожидалось %v", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestAuthorizeOrder(t *testing.T) {
+	a, err := NewAuthenticator(testSecret, time.Hour, testCredentials(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := a.Issue("strategy", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := model.Order{StrategyID: 1, Symbol: "BTCUSDT"}
+	if err := a.AuthorizeOrder(token, order); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.AuthorizeOrder("", order); err == nil {
+		t.Fatal("команда без токена разрешена")
+	}
+	order.StrategyID = 2
+	if err := a.AuthorizeOrder(token, order); err == nil {
+		t.Fatal("команда чужой стратегии разрешена")
+	}
+}
+
+func TestLoadCredentials(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{"учетные записи", `[{"name":"bot","password_hash":"` + string(hash) + `","role":"strategy","strategies":[1]}]`, true},
+		{"неизвестная роль", `[{"name":"bot","password_hash":"` + string(hash) + `","role":"admin"}]`, false},
+		{"пароль без хэша", `[{"name":"bot","password_hash":"password","role":"strategy"}]`, false},
+		{"не JSON", `name=bot`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "credentials.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			credentials, err := LoadCredentials(path)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ошибка %v", err)
+			}
+			if tt.ok && credentials["bot"].Strategies[0] != 1 {
+				t.Fatalf("учетные записи %+v", credentials)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Роли владельцев токенов
+const (
+	// Оператор может работать с ордерами любых стратегий и отзывать чужие токены
+	RoleOperator = "operator"
+	// Сервис стратегии работает только со своими стратегиями и символами
+	RoleStrategy = "strategy"
+)
+
+// Credential учетная запись, которой выдается токен. Пароль хранится только в виде хэша bcrypt
+type Credential struct {
+	Name         string `json:"name"`
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+	// Стратегии, ордерами которых можно управлять и которые можно просматривать. Для оператора не учитываются
+	Strategies []int64 `json:"strategies"`
+	// Символы, которыми можно торговать. Пустой список разрешает все символы
+	Symbols []string `json:"symbols"`
+}
+
+// LoadCredentials читает учетные записи из JSON файла path со списком Credential
+func LoadCredentials(path string) (map[string]Credential, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения учетных записей: %v", err)
+	}
+	var list []Credential
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("ошибка разбора учетных записей: %v", err)
+	}
+
+	credentials := make(map[string]Credential, len(list))
+	for _, credential := range list {
+		if credential.Role != RoleOperator && credential.Role != RoleStrategy {
+			return nil, fmt.Errorf("учетная запись %s: неизвестная роль %q", credential.Name, credential.Role)
+		}
+		if _, err := bcrypt.Cost([]byte(credential.PasswordHash)); err != nil {
+			return nil, fmt.Errorf("учетная запись %s: пароль должен быть хэшем bcrypt: %v", credential.Name, err)
+		}
+		credentials[credential.Name] = credential
+	}
+	return credentials, nil
+}
+
+// HashPassword возвращает хэш bcrypt пароля для файла учетных записей
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
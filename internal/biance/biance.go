@@ -1,18 +1,22 @@
 package biance
 
 import (
+	"app/internal/dedupe"
 	"app/internal/logger"
 	"app/internal/model"
 	"app/internal/request"
+	"app/internal/store"
 	"context"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
+	"sync"
 	"time"
 
 	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
 )
 
 const (
@@ -25,43 +29,158 @@ type BianceManager struct {
 	url       string
 	apiKey    string
 	secretKey string
-	client    *binance.Client
-	requester *request.RequestHandler
+	// client клиент Binance для потока пользовательских данных и проверок API. nil, если команды выполняет
+	// другая биржа
+	client *binance.Client
+	// exchange биржа, на которой выполняются команды
+	exchange Exchange
+	// binanceAPI реализация exchange через Binance. nil, если команды выполняет другая биржа
+	binanceAPI *binanceExchange
+	requester  *request.RequestHandler
+	// limiter ограничения веса запросов и числа ордеров Binance, по которым requester распределяет запросы
+	limiter *request.RateLimiter
+	// dedupe результаты выполненных команд для распознавания повторной доставки. Может быть nil
+	dedupe *dedupe.Store
+	// symbolRules проверка ордеров по фильтрам exchangeInfo. nil, пока не вызван EnableSymbolRules
+	symbolRules *SymbolRulesService
+	// orders последние известные статусы ордеров на бирже
+	orders *orderRegistry
+	// events канал событий смены статуса. nil, пока не вызван EnableEvents
+	events chan model.OrderEvent
+	// reports канал отчетов об исполнении из потока пользовательских данных. nil, пока не вызван EnableUserStream
+	reports chan model.ExecutionReport
+	// store хранилище ордеров. nil, пока не вызван EnableStore
+	store *store.Store
+	// dryRunStrategies стратегии, команды place_order которых только проверяются на бирже. nil, пока не вызван EnableDryRun
+	dryRunStrategies map[int64]bool
+	// risk проверка баланса и лимитов стратегий перед отправкой. nil, пока не вызван EnableRiskChecks
+	risk *riskEngine
+	// background фоновые задачи Enable*: синхронизация времени, обновление правил и балансов, сверка
+	background sync.WaitGroup
 }
 
 type loggingRoundTripper struct {
 	next http.RoundTripper
+	// limiter получает расход веса и ордеров из заголовков ответов
+	limiter *request.RateLimiter
+	// breaker размыкается при 429 и 418 и не пропускает запросы к бирже, кроме проверки ping
+	breaker *circuitBreaker
 }
 
-func NewBianceManager(url, apiKey, secretKey string, pause time.Duration) (*BianceManager, error) {
+// NewBianceManager создает менеджер биржи. Если dedupeStore не nil, повторно доставленные команды размещения
+// и редактирования распознаются по ClientOrderID и не выполняются второй раз.
+func NewBianceManager(url, apiKey, secretKey string, pause time.Duration, dedupeStore *dedupe.Store) (*BianceManager, error) {
 
+	limiter := request.NewRateLimiter()
+	applyRateLimits(limiter, defaultRateLimits)
+
+	transport := &loggingRoundTripper{
+		next:    http.DefaultTransport,
+		limiter: limiter,
+	}
 	httpClient := &http.Client{
-		Timeout: time.Second * 10,
-		Transport: &loggingRoundTripper{
-			next: http.DefaultTransport,
-		},
+		Timeout:   time.Second * 10,
+		Transport: transport,
 	}
 	client := binance.NewClient(apiKey, secretKey)
 	client.BaseURL = url
 	client.HTTPClient = httpClient
+	transport.breaker = newCircuitBreaker(func(ctx context.Context) error {
+		return client.NewPingService().Do(ctx)
+	})
+
+	api := &binanceExchange{client: client}
+	bianceManager, err := newBianceManager(api, limiter, transport.breaker, pause, dedupeStore)
+	if err != nil {
+		return nil, err
+	}
+	bianceManager.url = url
+	bianceManager.apiKey = apiKey
+	bianceManager.secretKey = secretKey
+	bianceManager.client = client
+	bianceManager.binanceAPI = api
+	api.precision = bianceManager.symbolPrecision
+	return bianceManager, nil
+}
+
+// NewBianceManagerWithExchange создает менеджер, который выполняет команды на exchange, например на симуляторе.
+// Проверки, ограничитель запросов с лимитами Binance и публикация результатов работают так же, как с Binance
+func NewBianceManagerWithExchange(exchange Exchange, pause time.Duration, dedupeStore *dedupe.Store) (*BianceManager, error) {
+	limiter := request.NewRateLimiter()
+	applyRateLimits(limiter, defaultRateLimits)
+	return newBianceManager(exchange, limiter, nil, pause, dedupeStore)
+}
 
+func newBianceManager(exchange Exchange, limiter *request.RateLimiter, gate request.Gate, pause time.Duration, dedupeStore *dedupe.Store) (*BianceManager, error) {
 	re, err := request.NewRequestHandler(10)
 	if err != nil {
 		return nil, err
 	}
-	re.ProcessRequests(pause)
+	// Запросы распределяются по лимитам биржи, pause только добавляет минимальный интервал между ними
+	re.SetRateLimiter(limiter)
+	if gate != nil {
+		re.SetGate(gate)
+	}
+	if err := re.StartProcessing(pause); err != nil {
+		return nil, err
+	}
 
 	bianceManager := BianceManager{
-		url:       url,
-		apiKey:    apiKey,
-		secretKey: secretKey,
-		client:    client,
+		exchange:  exchange,
 		requester: re,
+		limiter:   limiter,
+		dedupe:    dedupeStore,
+		orders:    newOrderRegistry(),
 	}
 	return &bianceManager, nil
 }
 
+// EnableSymbolRules загружает правила торговли из exchangeInfo и включает проверку ордеров по ним перед отправкой.
+// Правила и лимиты запросов обновляются каждые refreshInterval до отмены ctx. Если round, цена и количество округляются
+// до tickSize и stepSize, иначе ордер с некратными значениями отклоняется.
+func (bm *BianceManager) EnableSymbolRules(ctx context.Context, refreshInterval time.Duration, round bool) error {
+	rules := NewSymbolRulesService(bm.exchange, round)
+	rules.limiter = bm.limiter
+	rules.requester = bm.requester
+	if err := rules.Refresh(ctx); err != nil {
+		return err
+	}
+	bm.symbolRules = rules
+	bm.goBackground(func() { rules.StartRefreshing(ctx, refreshInterval) })
+	return nil
+}
+
+// goBackground запускает фоновую задачу, завершение которой ждет WaitBackground
+func (bm *BianceManager) goBackground(fn func()) {
+	bm.background.Add(1)
+	go func() {
+		defer bm.background.Done()
+		fn()
+	}()
+}
+
+// WaitBackground ждет завершения фоновых задач после отмены их ctx. Задачи публикуют события смены статуса,
+// поэтому канал событий можно закрывать только после WaitBackground
+func (bm *BianceManager) WaitBackground() {
+	bm.background.Wait()
+}
+
+// Stop немедленно останавливает обработку запросов к бирже
+func (bm *BianceManager) Stop() {
+	bm.requester.StopProcessing()
+}
+
+// Shutdown плавно останавливает обработку запросов к бирже: запросы из очереди выполняются до истечения ctx,
+// оставшиеся отклоняются, и соответствующие ордера возвращаются с ошибкой.
+func (bm *BianceManager) Shutdown(ctx context.Context) {
+	bm.requester.Shutdown(ctx)
+}
+
 func (l loggingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if l.breaker != nil && !l.breaker.Allow(req) {
+		return nil, ErrCircuitOpen
+	}
+
 	reqBody, _ := httputil.DumpRequestOut(req, true)
 	logger.Log.Info("Отправка запроса:\n", string(reqBody), "\n")
 
@@ -70,6 +189,21 @@ func (l loggingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error
 		logger.Log.Info("Ошибка при отправке запроса: ", err)
 		return resp, err
 	}
+	if l.limiter != nil {
+		observeRateLimits(l.limiter, resp.Header)
+	}
+
+	// Временная недоступность биржи и превышение лимитов возвращаются ошибкой, чтобы их можно было повторить
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusTeapot {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		statusErr := newHTTPStatusError(resp, body)
+		logger.Log.Error("Ошибка биржи: ", statusErr)
+		if l.breaker != nil {
+			l.breaker.Trip(statusErr)
+		}
+		return nil, statusErr
+	}
 
 	// respBody, _ := httputil.DumpResponse(resp, true)
 	// logger.Log.Info("Получен ответ:\n", string(respBody), "\n")
@@ -113,117 +247,266 @@ func (bm *BianceManager) checkSystemStatus() {
 func (bm *BianceManager) checkConnection() {
 	logger.Log.Info("Отправка запроса на проверку подключения")
 
-	account, err := bm.client.NewGetAccountService().Do(context.Background())
+	balances, err := bm.exchange.Balances(context.Background())
 	if err != nil {
 		logger.Log.Error("Ошибка при проверке подключения: ", err, "\n")
 		return
 	}
 	log.Println("Подключение успешно. Баланс аккаунта получен.")
-	for _, balance := range account.Balances {
+	for _, balance := range balances {
 		if balance.Free != "0.00000000" || balance.Locked != "0.00000000" {
 			logger.Log.Info(fmt.Sprintf("Актив: %s, Свободно: %s, Заблокировано: %s\n", balance.Asset, balance.Free, balance.Locked))
 		}
 	}
 }
 
-func (bm *BianceManager) ProcessOrders(newOrders chan model.Order) {
+// ProcessOrders выполняет ордера из канала newOrders и кладет результат выполнения в канал readyOrders
+func (bm *BianceManager) ProcessOrders(newOrders chan model.Order, readyOrders chan model.Order) {
 	for v := range newOrders {
-		bm.switchOrder(v)
+		readyOrders <- bm.switchOrder(v)
 	}
 }
 
-func (bm *BianceManager) switchOrder(order model.Order) {
-	var err error
-	var orderId int64
+// Execute синхронно выполняет команду так же, как ProcessOrders: с проверкой, через ограничитель запросов
+// и с публикацией событий смены статуса. Возвращает ордер, дополненный результатом выполнения
+func (bm *BianceManager) Execute(order model.Order) model.Order {
+	return bm.switchOrder(order)
+}
 
-	switch order.Action {
-	case PlaceOrder:
+// switchOrder выполняет действие над ордером и возвращает ордер, дополненный результатом выполнения.
+// По ходу выполнения ордер проходит статусы RECEIVED, VALIDATED, QUEUED, SENT и получает статус с биржи,
+// либо REJECTED при отказе и FAILED при временной ошибке.
+func (bm *BianceManager) switchOrder(order model.Order) model.Order {
+	// Статус задается только сервисом. Входящий статус учитывается лишь у команды, вернувшейся на повтор
+	if order.Status != model.StatusFailed {
+		order.Status = ""
+	}
+	bm.mustTransition(&order, model.StatusReceived, "")
+	redelivered, err := bm.saveCommand(order)
+	if err != nil {
+		logger.Log.Error(fmt.Sprintf("Ордер не прошел проверку: %v\n", err))
+		return bm.rejectOrder(order, model.StageValidate, err)
+	}
+
+	order = bm.withDryRun(withOrderDefaults(order))
+	if err := validateOrder(order); err != nil {
+		logger.Log.Error(fmt.Sprintf("Ордер не прошел проверку: %v\n", err))
+		return bm.rejectOrder(order, model.StageValidate, err)
+	}
+	if err := bm.checkTargetStatus(order); err != nil {
+		logger.Log.Error(fmt.Sprintf("Ордер не прошел проверку: %v\n", err))
+		return bm.rejectOrder(order, model.StageValidate, err)
+	}
+
+	result, ok, err := bm.replayedResult(order)
+	if err != nil {
+		logger.Log.Error(fmt.Sprintf("Ордер не прошел проверку: %v\n", err))
+		return bm.rejectOrder(order, model.StageValidate, err)
+	}
+	if ok {
+		return result
+	}
+
+	if bm.symbolRules != nil {
+		var err error
+		order, err = bm.symbolRules.Apply(order)
+		if err != nil {
+			logger.Log.Error(fmt.Sprintf("Ордер не прошел проверку по правилам символа: %v\n", err))
+			return bm.rejectOrder(order, model.StageValidate, err)
+		}
+	}
+	reservation, err := bm.checkRisk(order)
+	if err != nil {
+		logger.Log.Error(fmt.Sprintf("Ордер не прошел проверку рисков: %v\n", err))
+		return bm.rejectOrder(order, model.StageRisk, err)
+	}
+	bm.mustTransition(&order, model.StatusValidated, "")
+	bm.mustTransition(&order, model.StatusQueued, "")
+
+	switch {
+	case order.DryRun:
+		err = bm.requester.SyncHandleWeightedRequest(testOrderCost(order), func() error {
+			bm.mustTransition(&order, model.StatusSent, "")
+			rates, err := bm.testOrder(order)
+			if err != nil {
+				return err
+			}
+			order.CommissionRates = rates
+			bm.mustTransition(&order, model.StatusTested, "")
+			return nil
+		})
+
+	case order.Action == PlaceOrder:
+		var resp *binance.CreateOrderResponse
+		resp, err = bm.placedOrder(order, redelivered)
+		if err != nil {
+			break
+		}
+		if resp != nil {
+			// Ордер уже размещен предыдущей попыткой: повторно не отправляем
+			bm.mustTransition(&order, model.StatusSent, "")
+			order = withExecution(order, resp)
+			bm.mustTransition(&order, exchangeStatus(resp.Status), "")
+			break
+		}
 		// Выполняем синхронный запрос
-		bm.requester.SyncHandleRequest(func() error {
-			orderId, err = bm.placeOrder(order)
-			return err
+		err = bm.requester.SyncHandleWeightedRequest(requestCost(http.MethodPost, endpointOrder), func() error {
+			bm.mustTransition(&order, model.StatusSent, "")
+			resp, err := bm.placeOrder(order)
+			if err != nil {
+				return err
+			}
+			order = withExecution(order, resp)
+			bm.mustTransition(&order, exchangeStatus(resp.Status), "")
+			return nil
 		})
 
-	case EditOrder:
+	case order.Action == EditOrder:
 		//
-		bm.requester.SyncHandleRequest(func() error {
-			orderId, err = bm.editOrder(order)
+		err = bm.requester.SyncHandleWeightedRequest(requestCost(http.MethodPost, endpointCancelReplace), func() error {
+			bm.mustTransition(&order, model.StatusSent, "")
+			result, err := bm.editOrder(order)
+			order = result.apply(order)
+			if result.NewOrder != nil {
+				bm.mustTransition(&order, exchangeStatus(result.NewOrder.Status), "")
+			}
 			return err
 		})
 
-	case CancelOrder:
+	case order.Action == CancelOrder:
 		//
 
-		bm.requester.SyncHandleRequest(func() error {
-			err = bm.cancelOrder(order)
-			return err
+		err = bm.requester.SyncHandleWeightedRequest(requestCost(http.MethodDelete, endpointOrder), func() error {
+			bm.mustTransition(&order, model.StatusSent, "")
+			resp, err := bm.cancelOrder(order)
+			if err != nil {
+				return err
+			}
+			bm.mustTransition(&order, exchangeStatus(resp.Status), "")
+			return nil
 		})
 
-	default:
-		logger.Log.Info("Неизвестное действие: ", order.Action, "\n")
-		return
 	}
 
 	if err != nil {
 		logger.Log.Error(fmt.Sprintf("Ошибка при выполнении действия %s: %v\n", order.Action, err))
-	} else {
-		if order.Action != CancelOrder {
-			logger.Log.Info(fmt.Sprintf("Действие %s выполнено успешно. Новый ID ордера: %d\n", order.Action, orderId))
+		if IsRetryable(err) {
+			order = bm.retryOrder(order, err)
 		} else {
-			logger.Log.Info("Ордер успешно отменен\n")
+			order = bm.rejectOrder(order, model.StageExchange, err)
 		}
+		bm.settleRisk(reservation, order)
+		if order.Action == EditOrder {
+			// Часть cancel-replace могла выполниться до ошибки
+			bm.rememberStatuses(order)
+		}
+		return order
 	}
-}
 
-func (bm *BianceManager) placeOrder(order model.Order) (int64, error) {
-	orderSide := binance.SideType(order.Side)
-	// orderType := binance.OrderTypeLimit
+	order.Error = ""
+	order.FailedStage = ""
+	order.Retryable = false
+	if order.DryRun {
+		// Ордер не размещен: его результат не заменяет результат настоящей команды с тем же ClientOrderID
+		order.OrderApiStatus = model.OrderApiStatusDryRun
+		return order
+	}
 
-	newOrder, err := bm.client.NewCreateOrderService().Symbol(order.Symbol).Side(orderSide).Quantity(fmt.Sprintf("%f", order.Quantity)).Price(fmt.Sprintf("%f", order.Price)).Do(context.Background())
+	if order.Action != CancelOrder {
+		logger.Log.Info(fmt.Sprintf("Действие %s выполнено успешно. Новый ID ордера: %d\n", order.Action, order.BinanceID))
+	} else {
+		logger.Log.Info("Ордер успешно отменен\n")
+	}
+	order.OrderApiStatus = model.OrderApiStatusSuccess
+	bm.rememberResult(order)
+	bm.rememberStatuses(order)
+	bm.trackOpenOrders(order)
+	bm.settleRisk(reservation, order)
+	return order
+}
 
-	// Type(orderType).
-	// TimeInForce(binance.TimeInForceTypeGTC).
+// rejectOrder отклоняет ордер на этапе stage: он получает статус REJECTED и уходит в топик недоставленных сообщений
+func (bm *BianceManager) rejectOrder(order model.Order, stage string, err error) model.Order {
+	// Если при cancel-replace новый ордер все же размещен, у команды остается его статус
+	if model.CanTransition(order.Status, model.StatusRejected) {
+		bm.mustTransition(&order, model.StatusRejected, err.Error())
+	}
+	return failOrder(order, stage, err)
+}
 
-	if err != nil {
-		return 0, fmt.Errorf("ошибка при размещении ордера: %v", err)
+// retryOrder помечает ордер, не выполненный из-за временной ошибки биржи: он получает статус FAILED
+// и может быть выполнен повторно
+func (bm *BianceManager) retryOrder(order model.Order, err error) model.Order {
+	if model.CanTransition(order.Status, model.StatusFailed) {
+		bm.mustTransition(&order, model.StatusFailed, err.Error())
+	}
+	order = failOrder(order, model.StageExchange, err)
+	order.Retryable = true
+	return order
+}
+
+// trackOpenOrders обновляет число открытых ордеров по символу после успешно выполненной команды
+func (bm *BianceManager) trackOpenOrders(order model.Order) {
+	if bm.symbolRules == nil {
+		return
 	}
+	isOpen := order.Status == model.StatusNew || order.Status == model.StatusPartiallyFilled
 
-	return newOrder.OrderID, nil
+	switch order.Action {
+	case PlaceOrder:
+		if isOpen {
+			bm.symbolRules.OrderOpened(order.Symbol)
+		}
+	case EditOrder:
+		if order.CancelResult == CancelReplaceSuccess {
+			bm.symbolRules.OrderClosed(order.Symbol)
+		}
+		if isOpen {
+			bm.symbolRules.OrderOpened(order.Symbol)
+		}
+	case CancelOrder:
+		bm.symbolRules.OrderClosed(order.Symbol)
+	}
 }
 
-func (bm *BianceManager) cancelOrder(order model.Order) error {
+// failOrder помечает ордер как не выполненный на этапе stage
+func failOrder(order model.Order, stage string, err error) model.Order {
+	order.OrderApiStatus = model.OrderApiStatusError
+	order.Error = err.Error()
+	order.FailedStage = stage
+	return order
+}
 
-	_, err := bm.client.NewCancelOrderService().
-		Symbol(order.Symbol).
-		OrderID(order.BinanceID).
-		Do(context.Background())
+// withExecution дополняет ордер идентификатором и исполнением из ответа биржи о размещении
+func withExecution(order model.Order, resp *binance.CreateOrderResponse) model.Order {
+	order.BinanceID = resp.OrderID
+	order.ExecutedQty = parseDecimal(resp.ExecutedQuantity)
+	order.CumulativeQuoteQty = parseDecimal(resp.CummulativeQuoteQuantity)
+	return order
+}
 
+func (bm *BianceManager) placeOrder(order model.Order) (*binance.CreateOrderResponse, error) {
+	newOrder, err := bm.exchange.PlaceOrder(context.Background(), order)
+	if err != nil && order.ClientOrderID != "" && isDuplicateOrder(err) {
+		// Ордер уже размещен предыдущей попыткой, которая не успела сохранить результат
+		return bm.existingOrder(order)
+	}
 	if err != nil {
-		return fmt.Errorf("ошибка при отмене ордера: %v", err)
+		return nil, fmt.Errorf("ошибка при размещении ордера: %w", err)
 	}
 
-	return nil
+	return newOrder, nil
 }
 
-func (bm *BianceManager) editOrder(order model.Order) (int64, error) {
-	logger.Log.Info(fmt.Sprintf("Попытка обновления ордера: Symbol=%s, OrderID=%d, NewQuantity=%f, NewPrice=%f\n",
-		order.Symbol, order.BinanceID, order.Quantity, order.Price))
+func (bm *BianceManager) cancelOrder(order model.Order) (*binance.CancelOrderResponse, error) {
 
-	// Сначала отменяем существующий ордер
-	err := bm.cancelOrder(order)
-	if err != nil {
-		return 0, fmt.Errorf("ошибка при отмене старого ордера: %v", err)
-	}
+	resp, err := bm.exchange.CancelOrder(context.Background(), order)
 
-	// Создаем новый ордер с обновленными параметрами
-	newOrderID, err := bm.placeOrder(order)
 	if err != nil {
-		return 0, fmt.Errorf("ошибка при создании нового ордера: %v", err)
+		return nil, fmt.Errorf("ошибка при отмене ордера: %w", err)
 	}
 
-	// log.Printf("Ордер успешно обновлен. Старый OrderID: %d, Новый OrderID: %d\n", order.BinanceID, newOrderID)
-
-	return newOrderID, nil
+	return resp, nil
 }
 
 func (bm *BianceManager) CheckAndTestLimits() {
@@ -231,7 +514,7 @@ func (bm *BianceManager) CheckAndTestLimits() {
 
 	// 1. Проверка общей информации об ограничениях
 	fmt.Println("\n1. Проверка общей информации:")
-	exchangeInfo, err := bm.client.NewExchangeInfoService().Do(context.Background())
+	exchangeInfo, err := bm.exchange.ExchangeInfo(context.Background())
 	if err != nil {
 		fmt.Printf("Ошибка при получении exchangeInfo: %v\n", err)
 	} else {
@@ -250,7 +533,11 @@ func (bm *BianceManager) CheckAndTestLimits() {
 	fmt.Println("\n2. Тест лимита запросов:")
 	startTime := time.Now()
 	requestCount := 0
-	for time.Since(startTime) < time.Minute {
+	if bm.client == nil {
+		// Биржа подключена через NewBianceManagerWithExchange: REST-клиента Binance нет
+		fmt.Println("Пропущен: менеджер работает без клиента Binance")
+	}
+	for bm.client != nil && time.Since(startTime) < time.Minute {
 		err := bm.client.NewPingService().Do(context.Background())
 		if err != nil {
 			fmt.Printf("Ошибка при отправке ping: %v\n", err)
@@ -267,18 +554,19 @@ func (bm *BianceManager) CheckAndTestLimits() {
 	// 4. Тест создания и отмены ордера
 	fmt.Println("\n4. Тест создания и отмены ордера:")
 	testOrder := model.Order{
-		Symbol: "BTCUSDT",
-		Side:   "BUY",
-		// Type:     "LIMIT",
-		Quantity: 0.001,
-		Price:    10000, // Установите цену значительно ниже рыночной
+		Symbol:      "BTCUSDT",
+		Side:        "BUY",
+		Type:        string(binance.OrderTypeLimit),
+		TimeInForce: string(binance.TimeInForceTypeGTC),
+		Quantity:    decimal.RequireFromString("0.001"),
+		Price:       decimal.NewFromInt(10000), // Установите цену значительно ниже рыночной
 	}
-	orderId, err := bm.placeOrder(testOrder)
+	newOrder, err := bm.placeOrder(testOrder)
 	if err != nil {
 		fmt.Printf("Ошибка при создании тестового ордера: %v\n", err)
 	} else {
-		fmt.Printf("Тестовый ордер создан, ID: %d\n", orderId)
-		err = bm.cancelOrder(model.Order{Symbol: "BTCUSDT", BinanceID: orderId})
+		fmt.Printf("Тестовый ордер создан, ID: %d\n", newOrder.OrderID)
+		_, err = bm.cancelOrder(model.Order{Symbol: "BTCUSDT", BinanceID: newOrder.OrderID})
 		if err != nil {
 			fmt.Printf("Ошибка при отмене тестового ордера: %v\n", err)
 		} else {
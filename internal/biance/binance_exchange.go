@@ -0,0 +1,103 @@
+package biance
+
+import (
+	"app/internal/model"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// binanceExchange реализация Exchange через REST API Binance. Подписанные запросы используют смещение часов
+// и recvWindow из синхронизации времени и повторяются один раз при ошибке -1021
+type binanceExchange struct {
+	client *binance.Client
+	// precision точность параметров ордера данного типа по символу
+	precision func(symbol, orderType string) symbolPrecision
+	// recvWindow срок действия подписанного запроса. 0 — значение биржи по умолчанию
+	recvWindow time.Duration
+	// timeMu не дает синхронизировать время нескольким горутинам одновременно
+	timeMu sync.Mutex
+	// timeOffset смещение локальных часов относительно биржи в миллисекундах. Общий client не меняется:
+	// запросы go-binance получают копию клиента со смещением из signedClient
+	timeOffset atomic.Int64
+}
+
+// signedClient возвращает копию клиента с текущим смещением часов для подписанного запроса go-binance
+func (e *binanceExchange) signedClient() *binance.Client {
+	client := *e.client
+	client.TimeOffset = e.timeOffset.Load()
+	return &client
+}
+
+func (e *binanceExchange) PlaceOrder(ctx context.Context, order model.Order) (*binance.CreateOrderResponse, error) {
+	var resp *binance.CreateOrderResponse
+	err := e.retryOnTimestamp(ctx, func() error {
+		var err error
+		resp, err = e.newCreateOrderService(order).Do(ctx, e.signedOptions()...)
+		return err
+	})
+	return resp, err
+}
+
+func (e *binanceExchange) CancelOrder(ctx context.Context, order model.Order) (*binance.CancelOrderResponse, error) {
+	var resp *binance.CancelOrderResponse
+	err := e.retryOnTimestamp(ctx, func() error {
+		var err error
+		resp, err = e.signedClient().NewCancelOrderService().
+			Symbol(order.Symbol).
+			OrderID(order.BinanceID).
+			Do(ctx, e.signedOptions()...)
+		return err
+	})
+	return resp, err
+}
+
+func (e *binanceExchange) QueryOrder(ctx context.Context, symbol string, binanceID int64, clientOrderID string) (*binance.Order, error) {
+	var order *binance.Order
+	err := e.retryOnTimestamp(ctx, func() error {
+		service := e.signedClient().NewGetOrderService().Symbol(symbol)
+		if binanceID != 0 {
+			service.OrderID(binanceID)
+		} else {
+			service.OrigClientOrderID(clientOrderID)
+		}
+		var err error
+		order, err = service.Do(ctx, e.signedOptions()...)
+		return err
+	})
+	return order, err
+}
+
+func (e *binanceExchange) OpenOrders(ctx context.Context, symbol string) ([]*binance.Order, error) {
+	var orders []*binance.Order
+	err := e.retryOnTimestamp(ctx, func() error {
+		service := e.signedClient().NewListOpenOrdersService()
+		if symbol != "" {
+			service.Symbol(symbol)
+		}
+		var err error
+		orders, err = service.Do(ctx, e.signedOptions()...)
+		return err
+	})
+	return orders, err
+}
+
+func (e *binanceExchange) Balances(ctx context.Context) ([]binance.Balance, error) {
+	var account *binance.Account
+	err := e.retryOnTimestamp(ctx, func() error {
+		var err error
+		account, err = e.signedClient().NewGetAccountService().Do(ctx, e.signedOptions()...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account.Balances, nil
+}
+
+func (e *binanceExchange) ExchangeInfo(ctx context.Context) (*binance.ExchangeInfo, error) {
+	return e.client.NewExchangeInfoService().Do(ctx)
+}
@@ -0,0 +1,181 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Состояния автомата защиты
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+const (
+	// breakerPause пауза после первого 429/418 без Retry-After. Удваивается при каждом следующем подряд
+	breakerPause    = 5 * time.Second
+	breakerMaxPause = 5 * time.Minute
+	// breakerProbeWait интервал, с которым ждут результата проверки, начатой другой горутиной
+	breakerProbeWait = 100 * time.Millisecond
+	endpointPing     = "/api/v3/ping"
+)
+
+// ErrCircuitOpen возвращается вместо запроса к бирже, пока автомат защиты разомкнут. Ошибка временная
+var ErrCircuitOpen = errors.New("запросы к бирже приостановлены после превышения лимита или блокировки IP")
+
+// Метрики автомата защиты, доступны через expvar как binance_circuit_breaker:
+// state — текущее состояние, opened — число размыканий, rejected — число запросов, не отправленных на биржу,
+// probes — число проверок доступности, open_until — время, до которого автомат разомкнут
+var (
+	breakerMetrics   = expvar.NewMap("binance_circuit_breaker")
+	breakerStateVar  = new(expvar.String)
+	breakerUntilVar  = new(expvar.String)
+	breakerOpenedVar = new(expvar.Int)
+)
+
+func init() {
+	breakerMetrics.Set("state", breakerStateVar)
+	breakerMetrics.Set("open_until", breakerUntilVar)
+	breakerMetrics.Set("opened", breakerOpenedVar)
+	breakerStateVar.Set(breakerClosed)
+}
+
+// circuitBreaker автомат защиты от бана. Ответ 429 или 418 размыкает его на время из Retry-After: очередь
+// запросов приостанавливается, а остальные запросы к бирже завершаются ErrCircuitOpen. По истечении времени
+// автомат проверяет биржу легким ping и замыкается, если она отвечает, иначе размыкается снова.
+type circuitBreaker struct {
+	// probe проверка доступности биржи
+	probe func(ctx context.Context) error
+
+	mu        sync.Mutex
+	state     string
+	openUntil time.Time
+	// trips число размыканий подряд без успешной проверки, от него зависит пауза без Retry-After
+	trips int
+}
+
+func newCircuitBreaker(probe func(ctx context.Context) error) *circuitBreaker {
+	return &circuitBreaker{probe: probe, state: breakerClosed}
+}
+
+// Trip размыкает автомат после ответа statusErr с кодом 429 или 418. Если автомат уже разомкнут,
+// время размыкания только продлевается
+func (b *circuitBreaker) Trip(statusErr *HTTPStatusError) {
+	if statusErr.StatusCode != http.StatusTooManyRequests && statusErr.StatusCode != http.StatusTeapot {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trips++
+	pause := statusErr.RetryAfter
+	if pause <= 0 {
+		pause = breakerBackoff(b.trips)
+	}
+	openUntil := time.Now().Add(pause)
+	if b.state == breakerOpen && !openUntil.After(b.openUntil) {
+		return
+	}
+	b.openUntil = openUntil
+	breakerUntilVar.Set(openUntil.Format(time.RFC3339))
+	if b.state != breakerOpen {
+		breakerOpenedVar.Add(1)
+	}
+	b.setState(breakerOpen, fmt.Sprintf("биржа вернула HTTP %d, запросы приостановлены до %s",
+		statusErr.StatusCode, openUntil.Format(time.RFC3339)))
+}
+
+// Allow сообщает, можно ли отправить запрос req. Пока автомат не замкнут, разрешена только проверка ping
+func (b *circuitBreaker) Allow(req *http.Request) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerClosed || req.URL.Path == endpointPing {
+		return true
+	}
+	breakerMetrics.Add("rejected", 1)
+	return false
+}
+
+// Wait ждет, пока автомат замкнется. Когда время размыкания истекает, проверяет биржу.
+// Возвращает ошибку ctx, если он истек раньше
+func (b *circuitBreaker) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		state := b.state
+		wait := time.Until(b.openUntil)
+		if state == breakerOpen && wait <= 0 {
+			b.setState(breakerHalfOpen, "время паузы истекло, проверка доступности биржи")
+		}
+		b.mu.Unlock()
+
+		switch {
+		case state == breakerClosed:
+			return nil
+		case state == breakerOpen && wait <= 0:
+			b.probeExchange(ctx)
+			continue
+		case state == breakerHalfOpen:
+			wait = breakerProbeWait
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// probeExchange проверяет биржу и замыкает автомат, если она ответила. Иначе автомат размыкается снова
+func (b *circuitBreaker) probeExchange(ctx context.Context) {
+	breakerMetrics.Add("probes", 1)
+	err := b.probe(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerHalfOpen {
+		// Проверка получила 429 или 418, и автомат уже разомкнут через Trip
+		return
+	}
+	if err == nil {
+		b.trips = 0
+		b.setState(breakerClosed, "биржа доступна, запросы возобновлены")
+		return
+	}
+
+	b.trips++
+	b.openUntil = time.Now().Add(breakerBackoff(b.trips))
+	breakerUntilVar.Set(b.openUntil.Format(time.RFC3339))
+	b.setState(breakerOpen, fmt.Sprintf("биржа недоступна (%v), запросы приостановлены до %s",
+		err, b.openUntil.Format(time.RFC3339)))
+}
+
+// breakerBackoff пауза после trips размыканий подряд без Retry-After
+func breakerBackoff(trips int) time.Duration {
+	pause := breakerPause
+	for i := 1; i < trips && pause < breakerMaxPause; i++ {
+		pause *= 2
+	}
+	return min(pause, breakerMaxPause)
+}
+
+// setState меняет состояние автомата. Вызывается под b.mu
+func (b *circuitBreaker) setState(state, reason string) {
+	if state == breakerClosed {
+		logger.Log.Info(fmt.Sprintf("Автомат защиты биржи: %s -> %s: %s", b.state, state, reason))
+	} else {
+		logger.Log.Warn(fmt.Sprintf("Автомат защиты биржи: %s -> %s: %s", b.state, state, reason))
+	}
+	b.state = state
+	breakerStateVar.Set(state)
+}
@@ -0,0 +1,125 @@
+package biance
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerBackoff(t *testing.T) {
+	tests := []struct {
+		trips int
+		pause time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{7, breakerMaxPause},
+		{50, breakerMaxPause},
+	}
+	for _, tt := range tests {
+		if pause := breakerBackoff(tt.trips); pause != tt.pause {
+			t.Errorf("после %d размыканий пауза %v, ожидалась %v", tt.trips, pause, tt.pause)
+		}
+	}
+}
+
+func TestBreakerTrip(t *testing.T) {
+	order := httptest.NewRequest(http.MethodPost, endpointOrder, nil)
+	ping := httptest.NewRequest(http.MethodGet, endpointPing, nil)
+
+	b := newCircuitBreaker(func(ctx context.Context) error { return nil })
+	// Временная недоступность биржи не размыкает автомат
+	b.Trip(&HTTPStatusError{StatusCode: http.StatusServiceUnavailable})
+	if !b.Allow(order) {
+		t.Fatal("автомат разомкнут после 503")
+	}
+
+	b.Trip(&HTTPStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Minute})
+	if b.Allow(order) {
+		t.Fatal("запрос пропущен разомкнутым автоматом")
+	}
+	if !b.Allow(ping) {
+		t.Fatal("проверка ping не пропущена")
+	}
+	openUntil := b.openUntil
+
+	// Более короткая пауза не сокращает размыкание, более длинная продлевает
+	b.Trip(&HTTPStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second})
+	if !b.openUntil.Equal(openUntil) {
+		t.Fatalf("размыкание сокращено до %v", b.openUntil)
+	}
+	b.Trip(&HTTPStatusError{StatusCode: http.StatusTeapot, RetryAfter: time.Hour})
+	if !b.openUntil.After(openUntil.Add(50 * time.Minute)) {
+		t.Fatalf("размыкание не продлено после 418: %v", b.openUntil)
+	}
+}
+
+func TestBreakerWaitProbesExchange(t *testing.T) {
+	var probes atomic.Int32
+	probeErr := errors.New("timeout")
+	b := newCircuitBreaker(func(ctx context.Context) error {
+		// Первая проверка неудачна, вторая успешна
+		if probes.Add(1) == 1 {
+			return probeErr
+		}
+		return nil
+	})
+	b.Trip(&HTTPStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// После неудачной проверки автомат размыкается на паузу без Retry-After, которая дольше ctx
+	if err := b.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ошибка %v, ожидалось истечение ожидания", err)
+	}
+	if probes.Load() != 1 || b.state != breakerOpen || b.trips != 2 {
+		t.Fatalf("проверок %d, состояние %s, размыканий %d", probes.Load(), b.state, b.trips)
+	}
+
+	b.mu.Lock()
+	b.openUntil = time.Now()
+	b.mu.Unlock()
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if b.state != breakerClosed || b.trips != 0 {
+		t.Fatalf("состояние %s, размыканий %d, ожидалось замыкание", b.state, b.trips)
+	}
+	if !b.Allow(httptest.NewRequest(http.MethodPost, endpointOrder, nil)) {
+		t.Fatal("запрос не пропущен после замыкания")
+	}
+}
+
+// Разомкнутый автомат отвечает ErrCircuitOpen, не отправляя запрос на биржу
+func TestBreakerStopsRoundTrip(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	transport := loggingRoundTripper{
+		next:    http.DefaultTransport,
+		breaker: newCircuitBreaker(func(ctx context.Context) error { return nil }),
+	}
+	client := &http.Client{Transport: transport}
+
+	_, err := client.Get(server.URL + endpointOrder)
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.RetryAfter != time.Minute {
+		t.Fatalf("ошибка %v, ожидался 429 с Retry-After", err)
+	}
+	if _, err := client.Get(server.URL + endpointOrder); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("ошибка %v, ожидалась ErrCircuitOpen", err)
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("на биржу отправлено %d запросов, ожидался 1", requests.Load())
+	}
+}
@@ -0,0 +1,181 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+)
+
+// Режимы cancel-replace
+const (
+	CancelReplaceStopOnFailure = "STOP_ON_FAILURE"
+	CancelReplaceAllowFailure  = "ALLOW_FAILURE"
+)
+
+// Результаты частей cancel-replace
+const (
+	CancelReplaceSuccess      = "SUCCESS"
+	CancelReplaceFailure      = "FAILURE"
+	CancelReplaceNotAttempted = "NOT_ATTEMPTED"
+)
+
+// Коды ошибок Binance, когда cancel-replace выполнен частично или не выполнен.
+// Подробности по каждой части лежат в поле data ответа
+const (
+	codeCancelReplaceFailed         = -2021
+	codeCancelReplacePartialSuccess = -2022
+)
+
+// cancelReplaceResponse ответ POST /api/v3/order/cancelReplace
+type cancelReplaceResponse struct {
+	CancelResult     string          `json:"cancelResult"`
+	NewOrderResult   string          `json:"newOrderResult"`
+	CancelResponse   json.RawMessage `json:"cancelResponse"`
+	NewOrderResponse json.RawMessage `json:"newOrderResponse"`
+}
+
+// cancelReplaceError тело ответа с ошибкой cancel-replace
+type cancelReplaceError struct {
+	Code    int64                 `json:"code"`
+	Message string                `json:"msg"`
+	Data    cancelReplaceResponse `json:"data"`
+}
+
+// EditResult итог редактирования через cancel-replace: результат отмены старого ордера и размещения нового
+type EditResult struct {
+	// CancelResult и NewOrderResult итог частей: CancelReplaceSuccess, CancelReplaceFailure или CancelReplaceNotAttempted
+	CancelResult   string
+	NewOrderResult string
+	CancelErr      error
+	NewOrderErr    error
+	// NewOrder новый ордер, если он размещен
+	NewOrder *binance.CreateOrderResponse
+}
+
+// apply переносит итог редактирования в ордер. Статус нового ордера выставляет вызывающий
+func (r EditResult) apply(order model.Order) model.Order {
+	order.ReplacedBinanceID = order.BinanceID
+	order.CancelResult = r.CancelResult
+	order.NewOrderResult = r.NewOrderResult
+	if r.CancelErr != nil {
+		order.CancelError = r.CancelErr.Error()
+	}
+	if r.NewOrderErr != nil {
+		order.NewOrderError = r.NewOrderErr.Error()
+	}
+	if r.NewOrder != nil {
+		order = withExecution(order, r.NewOrder)
+	}
+	return order
+}
+
+// editOrder атомарно заменяет ордер order.BinanceID новым через cancel-replace. Возвращает итог по обеим частям
+// и ошибку, если хотя бы одна часть не выполнена.
+func (bm *BianceManager) editOrder(order model.Order) (EditResult, error) {
+	logger.Log.Info(fmt.Sprintf("Попытка обновления ордера: Symbol=%s, OrderID=%d, NewQuantity=%s, NewPrice=%s, Mode=%s\n",
+		order.Symbol, order.BinanceID, order.Quantity, order.Price, cancelReplaceMode(order)))
+
+	result, err := bm.exchange.EditOrder(context.Background(), order)
+	if err != nil {
+		return result, fmt.Errorf("ошибка при замене ордера: %w", err)
+	}
+
+	if result.NewOrder == nil && order.ClientOrderID != "" && result.CancelErr != nil {
+		// Повторная доставка: старый ордер уже заменен предыдущей попыткой, которая не успела сохранить результат
+		if existing, existingErr := bm.existingOrder(order); existingErr == nil {
+			return EditResult{
+				CancelResult:   CancelReplaceSuccess,
+				NewOrderResult: CancelReplaceSuccess,
+				NewOrder:       existing,
+			}, nil
+		}
+	}
+
+	switch {
+	case result.CancelErr != nil && result.NewOrderErr != nil:
+		return result, fmt.Errorf("ошибка при отмене старого ордера: %v; новый ордер: %v", result.CancelErr, result.NewOrderErr)
+	case result.CancelErr != nil:
+		return result, fmt.Errorf("ошибка при отмене старого ордера: %v", result.CancelErr)
+	case result.NewOrderErr != nil:
+		return result, fmt.Errorf("ошибка при создании нового ордера: %v", result.NewOrderErr)
+	}
+	return result, nil
+}
+
+func (e *binanceExchange) EditOrder(ctx context.Context, order model.Order) (EditResult, error) {
+	params := e.orderParams(order)
+	params.Set("cancelReplaceMode", cancelReplaceMode(order))
+	params.Set("cancelOrderId", strconv.FormatInt(order.BinanceID, 10))
+
+	var data []byte
+	err := e.retryOnTimestamp(ctx, func() error {
+		var err error
+		data, err = e.signedRequest(ctx, http.MethodPost, endpointCancelReplace, params)
+		return err
+	})
+
+	var apiErr *common.APIError
+	if err != nil && !errors.As(err, &apiErr) {
+		// Транспортная ошибка: неизвестно, выполнена ли замена
+		return EditResult{}, err
+	}
+
+	var resp cancelReplaceResponse
+	if err != nil {
+		if apiErr.Code != codeCancelReplaceFailed && apiErr.Code != codeCancelReplacePartialSuccess {
+			return EditResult{}, err
+		}
+		var replaceErr cancelReplaceError
+		if jsonErr := json.Unmarshal(data, &replaceErr); jsonErr != nil {
+			return EditResult{}, fmt.Errorf("ошибка при разборе ответа замены ордера: %v", jsonErr)
+		}
+		resp = replaceErr.Data
+	} else if jsonErr := json.Unmarshal(data, &resp); jsonErr != nil {
+		return EditResult{}, fmt.Errorf("ошибка при разборе ответа замены ордера: %v", jsonErr)
+	}
+
+	result := EditResult{
+		CancelResult:   resp.CancelResult,
+		NewOrderResult: resp.NewOrderResult,
+		CancelErr:      partError(resp.CancelResult, resp.CancelResponse),
+		NewOrderErr:    partError(resp.NewOrderResult, resp.NewOrderResponse),
+	}
+	if resp.NewOrderResult == CancelReplaceSuccess {
+		result.NewOrder = new(binance.CreateOrderResponse)
+		if jsonErr := json.Unmarshal(resp.NewOrderResponse, result.NewOrder); jsonErr != nil {
+			return result, fmt.Errorf("ошибка при разборе нового ордера: %v", jsonErr)
+		}
+	}
+	return result, nil
+}
+
+func cancelReplaceMode(order model.Order) string {
+	if order.CancelReplaceMode == "" {
+		return CancelReplaceStopOnFailure
+	}
+	return order.CancelReplaceMode
+}
+
+// partError возвращает ошибку части cancel-replace, если она не выполнена
+func partError(result string, response json.RawMessage) error {
+	switch result {
+	case CancelReplaceSuccess:
+		return nil
+	case CancelReplaceNotAttempted:
+		return errors.New("не выполнялось")
+	}
+
+	apiErr := new(common.APIError)
+	if json.Unmarshal(response, apiErr) != nil || !apiErr.IsValid() {
+		return fmt.Errorf("результат %s", result)
+	}
+	return apiErr
+}
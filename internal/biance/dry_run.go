@@ -0,0 +1,101 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"app/internal/request"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// testOrderCommissionWeight вес проверки ордера с расчетом ставок комиссии
+const testOrderCommissionWeight = 20
+
+// testOrderResponse ответ POST /api/v3/order/test с computeCommissionRates=true
+type testOrderResponse struct {
+	StandardCommissionForOrder struct {
+		Maker string `json:"maker"`
+		Taker string `json:"taker"`
+	} `json:"standardCommissionForOrder"`
+	TaxCommissionForOrder struct {
+		Maker string `json:"maker"`
+		Taker string `json:"taker"`
+	} `json:"taxCommissionForOrder"`
+	Discount struct {
+		EnabledForAccount bool   `json:"enabledForAccount"`
+		EnabledForSymbol  bool   `json:"enabledForSymbol"`
+		DiscountAsset     string `json:"discountAsset"`
+		Discount          string `json:"discount"`
+	} `json:"discount"`
+}
+
+// EnableDryRun включает проверку без размещения для команд place_order стратегий strategies, как если бы
+// в каждой из них был задан dry_run
+func (bm *BianceManager) EnableDryRun(strategies []int64) {
+	bm.dryRunStrategies = make(map[int64]bool, len(strategies))
+	for _, strategyID := range strategies {
+		bm.dryRunStrategies[strategyID] = true
+	}
+}
+
+// withDryRun помечает команду размещения стратегии из EnableDryRun как проверку без размещения
+func (bm *BianceManager) withDryRun(order model.Order) model.Order {
+	if order.Action == PlaceOrder && bm.dryRunStrategies[order.StrategyID] {
+		order.DryRun = true
+	}
+	return order
+}
+
+// testOrder проверяет ордер на бирже без размещения
+func (bm *BianceManager) testOrder(order model.Order) (*model.CommissionRates, error) {
+	rates, err := bm.exchange.TestOrder(context.Background(), order)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при проверке ордера: %w", err)
+	}
+	logger.Log.Info(fmt.Sprintf("Ордер %s (%s) прошел проверку биржи без размещения", order.ClientOrderID, order.Symbol))
+	return rates, nil
+}
+
+// testOrderCost расход проверки ордера. Проверка не учитывается в лимите числа ордеров
+func testOrderCost(order model.Order) request.Cost {
+	cost := requestCost(http.MethodPost, endpointOrderTest)
+	if order.ComputeCommissionRates {
+		cost[rateLimitWeight] = testOrderCommissionWeight
+	}
+	return cost
+}
+
+func (e *binanceExchange) TestOrder(ctx context.Context, order model.Order) (*model.CommissionRates, error) {
+	params := e.orderParams(order)
+	if order.ComputeCommissionRates {
+		params.Set("computeCommissionRates", "true")
+	}
+
+	var data []byte
+	err := e.retryOnTimestamp(ctx, func() error {
+		var err error
+		data, err = e.signedRequest(ctx, http.MethodPost, endpointOrderTest, params)
+		return err
+	})
+	if err != nil || !order.ComputeCommissionRates {
+		return nil, err
+	}
+
+	var resp testOrderResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("ошибка при разборе ставок комиссии: %v", err)
+	}
+	rates := model.CommissionRates{
+		Maker:    parseDecimal(resp.StandardCommissionForOrder.Maker),
+		Taker:    parseDecimal(resp.StandardCommissionForOrder.Taker),
+		TaxMaker: parseDecimal(resp.TaxCommissionForOrder.Maker),
+		TaxTaker: parseDecimal(resp.TaxCommissionForOrder.Taker),
+	}
+	if resp.Discount.EnabledForAccount && resp.Discount.EnabledForSymbol {
+		rates.DiscountAsset = resp.Discount.DiscountAsset
+		rates.Discount = parseDecimal(resp.Discount.Discount)
+	}
+	return &rates, nil
+}
@@ -0,0 +1,85 @@
+package biance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+)
+
+// Коды ошибок Binance, после которых запрос можно повторить
+const (
+	codeUnknown         = -1000
+	codeDisconnected    = -1001
+	codeTimeout         = -1007
+	codeServerBusy      = -1008
+	codeTooManyRequests = -1003
+	codeUnexpectedResp  = -1006
+	codeTooManyOrders   = -1015
+	codeServiceShutdown = -1016
+)
+
+// HTTPStatusError ответ биржи с кодом 5xx, 429 или 418. Возвращается loggingRoundTripper вместо ответа,
+// чтобы вызывающий код мог отличить временную недоступность биржи от отказа в выполнении команды.
+type HTTPStatusError struct {
+	StatusCode int
+	// Значение заголовка Retry-After, если он был в ответе
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("биржа вернула HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+func newHTTPStatusError(resp *http.Response, body []byte) *HTTPStatusError {
+	statusErr := &HTTPStatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		statusErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return statusErr
+}
+
+// IsRetryable сообщает, является ли ошибка временной: таймауты, обрывы соединения, HTTP 5xx,
+// превышение лимитов запросов. Ошибки проверки параметров и отказы биржи в исполнении считаются фатальными.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrCircuitOpen) {
+		return true
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusTooManyRequests ||
+			statusErr.StatusCode == http.StatusTeapot
+	}
+
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case codeUnknown, codeDisconnected, codeTimeout, codeServerBusy,
+			codeTooManyRequests, codeUnexpectedResp, codeTooManyOrders, codeServiceShutdown:
+			return true
+		}
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
@@ -0,0 +1,38 @@
+package biance
+
+import (
+	"app/internal/model"
+	"context"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// Exchange операции биржи, через которые BianceManager выполняет команды, сверяет ордера и загружает
+// правила торговли. Ответы и ошибки имеют тот же вид, что у Binance: отказы биржи возвращаются
+// как *common.APIError с кодами Binance, поэтому их обработка не зависит от реализации.
+type Exchange interface {
+	// PlaceOrder размещает ордер. Если ClientOrderID уже занят открытым ордером, возвращает ошибку -2010 "Duplicate order sent."
+	PlaceOrder(ctx context.Context, order model.Order) (*binance.CreateOrderResponse, error)
+	// TestOrder проверяет ордер по правилам биржи, не размещая его. Если задан order.ComputeCommissionRates,
+	// возвращает ставки комиссии для ордера, иначе nil
+	TestOrder(ctx context.Context, order model.Order) (*model.CommissionRates, error)
+	// CancelOrder отменяет ордер order.BinanceID
+	CancelOrder(ctx context.Context, order model.Order) (*binance.CancelOrderResponse, error)
+	// EditOrder заменяет ордер order.BinanceID новым через cancel-replace. Отказ в одной из частей возвращается
+	// в EditResult, ошибка означает, что замена не выполнялась или ее итог неизвестен
+	EditOrder(ctx context.Context, order model.Order) (EditResult, error)
+	// QueryOrder возвращает ордер по binanceID, а если он равен 0, по clientOrderID
+	QueryOrder(ctx context.Context, symbol string, binanceID int64, clientOrderID string) (*binance.Order, error)
+	// OpenOrders возвращает открытые ордера символа, а если symbol пустой, всех символов
+	OpenOrders(ctx context.Context, symbol string) ([]*binance.Order, error)
+	// Balances возвращает свободные и заблокированные балансы аккаунта
+	Balances(ctx context.Context) ([]binance.Balance, error)
+	// ExchangeInfo возвращает правила торговли по символам и лимиты запросов
+	ExchangeInfo(ctx context.Context) (*binance.ExchangeInfo, error)
+}
+
+// reportSource биржа, которая сама сообщает об исполнениях и изменениях балансов. Для нее поток
+// пользовательских данных Binance не нужен: события передаются handler до отмены ctx
+type reportSource interface {
+	subscribe(ctx context.Context, handler userStreamHandler) (done chan struct{})
+}
@@ -0,0 +1,101 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+)
+
+// Код ошибки Binance при отклонении нового ордера. В том числе возвращается при повторном newClientOrderId
+const codeNewOrderRejected = -2010
+
+// replayedResult возвращает сохраненный результат, если команда с тем же ClientOrderID уже выполнялась.
+// ClientOrderID, сформированный из координат сообщения, можно угадать, а через API его задает клиент, поэтому
+// результат возвращается только той же команде: с другой стратегией, символом или действием повтор отклоняется
+func (bm *BianceManager) replayedResult(order model.Order) (model.Order, bool, error) {
+	if bm.dedupe == nil || order.ClientOrderID == "" || order.Action == CancelOrder || order.DryRun {
+		return model.Order{}, false, nil
+	}
+	result, ok := bm.dedupe.Get(order.ClientOrderID)
+	if !ok {
+		return model.Order{}, false, nil
+	}
+	if result.StrategyID != order.StrategyID || result.Symbol != order.Symbol || result.Action != order.Action {
+		return model.Order{}, false, fmt.Errorf("client_order_id %s уже использован командой %s стратегии %d по %s",
+			order.ClientOrderID, result.Action, result.StrategyID, result.Symbol)
+	}
+
+	logger.Log.Warn(fmt.Sprintf("Повторная команда %s с client_order_id %s, возвращаем исходный результат: ордер %d",
+		order.Action, order.ClientOrderID, result.BinanceID))
+	result.MessageKey = order.MessageKey
+	result.Attempt = order.Attempt
+	return result, true, nil
+}
+
+// rememberResult сохраняет результат выполненной команды для распознавания повторов
+func (bm *BianceManager) rememberResult(order model.Order) {
+	if bm.dedupe == nil || order.Action == CancelOrder {
+		return
+	}
+	if err := bm.dedupe.Save(order); err != nil {
+		logger.Log.Error("Ошибка при сохранении результата команды: ", err)
+	}
+}
+
+// isDuplicateOrder сообщает, что биржа отклонила ордер из-за уже использованного newClientOrderId
+func isDuplicateOrder(err error) bool {
+	var apiErr *common.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == codeNewOrderRejected &&
+		strings.Contains(strings.ToLower(apiErr.Message), "duplicate")
+}
+
+// existingOrder запрашивает на бирже ордер, уже размещенный с тем же ClientOrderID
+func (bm *BianceManager) existingOrder(order model.Order) (*binance.CreateOrderResponse, error) {
+	existing, err := bm.exchange.QueryOrder(context.Background(), order.Symbol, 0, order.ClientOrderID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при запросе ранее размещенного ордера %s: %w", order.ClientOrderID, err)
+	}
+
+	logger.Log.Warn(fmt.Sprintf("Ордер с client_order_id %s уже размещен: %d", order.ClientOrderID, existing.OrderID))
+	return &binance.CreateOrderResponse{
+		Symbol:                   existing.Symbol,
+		OrderID:                  existing.OrderID,
+		ClientOrderID:            existing.ClientOrderID,
+		TransactTime:             existing.Time,
+		Price:                    existing.Price,
+		OrigQuantity:             existing.OrigQuantity,
+		ExecutedQuantity:         existing.ExecutedQuantity,
+		CummulativeQuoteQuantity: existing.CummulativeQuoteQuantity,
+		Status:                   existing.Status,
+		TimeInForce:              existing.TimeInForce,
+		Type:                     existing.Type,
+		Side:                     existing.Side,
+	}, nil
+}
+
+// placedOrder ищет на бирже ордер, который могла разместить предыдущая попытка команды: повтор после временной
+// ошибки или повторно доставленное сообщение, результат которого не успели сохранить. Биржа отклоняет повторный
+// newClientOrderId, только пока первый ордер открыт, поэтому без этой проверки исполненный рыночный или IOC ордер
+// был бы размещен второй раз. Возвращает nil, если команда выполняется впервые или ордера на бирже нет
+func (bm *BianceManager) placedOrder(order model.Order, redelivered bool) (*binance.CreateOrderResponse, error) {
+	if order.ClientOrderID == "" || (order.Attempt == 0 && !redelivered) {
+		return nil, nil
+	}
+	var resp *binance.CreateOrderResponse
+	err := bm.requester.SyncHandleWeightedRequest(requestCost(http.MethodGet, endpointOrder), func() error {
+		var err error
+		resp, err = bm.existingOrder(order)
+		return err
+	})
+	if isNoSuchOrder(err) {
+		return nil, nil
+	}
+	return resp, err
+}
@@ -0,0 +1,132 @@
+package biance_test
+
+import (
+	"app/internal/biance"
+	"app/internal/dedupe"
+	"app/internal/model"
+	"app/internal/store"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// newTestManager создает менеджер над симулятором с пустым хранилищем результатов в памяти
+func newTestManager(t *testing.T, sim *biance.Simulator, orderStore *store.Store) *biance.BianceManager {
+	t.Helper()
+	dedupeStore, err := dedupe.NewStore("", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bm, err := biance.NewBianceManagerWithExchange(sim, 0, dedupeStore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(bm.Stop)
+	if orderStore != nil {
+		bm.EnableStore(orderStore)
+	}
+	return bm
+}
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "orders.db")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// Исполненный рыночный ордер не открыт, поэтому биржа не отклонит его повтор как дубликат: повтор без
+// сохраненного результата должен найти ордер по client_order_id, а не разместить второй
+func TestReplayedMarketOrderIsNotPlacedTwice(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   bool
+		attempt int
+	}{
+		{"повтор после временной ошибки", false, 1},
+		{"повторная доставка сообщения", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sim := newTestSimulator(map[string]string{"USDT": "1000"})
+			if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(100)); err != nil {
+				t.Fatal(err)
+			}
+			var orderStore *store.Store
+			if tt.store {
+				orderStore = newTestStore(t)
+			}
+			order := marketOrder("BUY", "1")
+			order.Action = biance.PlaceOrder
+			order.ClientOrderID = "market-1"
+
+			first := newTestManager(t, sim, orderStore).Execute(order)
+			if first.Status != model.StatusFilled {
+				t.Fatalf("статус %s, ожидался FILLED: %s", first.Status, first.Error)
+			}
+
+			// Результат первой попытки потерян: новый менеджер с пустым хранилищем результатов
+			order.Attempt = tt.attempt
+			replayed := newTestManager(t, sim, orderStore).Execute(order)
+			if replayed.Status != model.StatusFilled || replayed.BinanceID != first.BinanceID {
+				t.Fatalf("статус %s, ордер %d, ожидался исходный ордер %d: %s",
+					replayed.Status, replayed.BinanceID, first.BinanceID, replayed.Error)
+			}
+			assertBalance(t, sim, "BTC", "0.998", "0")
+			assertBalance(t, sim, "USDT", "900", "0")
+		})
+	}
+}
+
+func TestReplayRejectsCommandWithAnotherIdentity(t *testing.T) {
+	sim := newTestSimulator(map[string]string{"USDT": "1000"})
+	bm := newTestManager(t, sim, nil)
+	order := limitOrder("BUY", "1", "90")
+	order.Action = biance.PlaceOrder
+	order.ClientOrderID = "limit-1"
+	order.StrategyID = 1
+	first := bm.Execute(order)
+	if first.Status != model.StatusNew {
+		t.Fatalf("статус %s, ожидался NEW: %s", first.Status, first.Error)
+	}
+
+	tests := []struct {
+		name   string
+		change func(order *model.Order)
+	}{
+		{"другая стратегия", func(order *model.Order) { order.StrategyID = 2 }},
+		{"другой символ", func(order *model.Order) { order.Symbol = "ETHUSDT" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := order
+			tt.change(&other)
+			result := bm.Execute(other)
+			if result.Status != model.StatusRejected || result.Retryable || result.FailedStage != model.StageValidate {
+				t.Fatalf("статус %s, этап %s, повтор %v, ожидался отказ при проверке", result.Status, result.FailedStage, result.Retryable)
+			}
+			if result.BinanceID != 0 {
+				t.Fatalf("команде возвращен чужой ордер %d", result.BinanceID)
+			}
+		})
+	}
+
+	// Та же команда по-прежнему получает свой результат
+	if replayed := bm.Execute(order); replayed.BinanceID != first.BinanceID {
+		t.Fatalf("повтор получил ордер %d, ожидался %d", replayed.BinanceID, first.BinanceID)
+	}
+	assertBalance(t, sim, "USDT", "910", "90")
+}
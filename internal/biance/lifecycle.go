@@ -0,0 +1,199 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// orderRegistry последние известные статусы ордеров на бирже по их BinanceID. По нему отклоняются команды
+// над ордерами, которые уже не могут измениться, например редактирование исполненного ордера.
+type orderRegistry struct {
+	mu       sync.Mutex
+	statuses map[int64]string
+}
+
+func newOrderRegistry() *orderRegistry {
+	return &orderRegistry{statuses: make(map[int64]string)}
+}
+
+// status возвращает статус ордера binanceID, если он известен
+func (r *orderRegistry) status(binanceID int64) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.statuses[binanceID]
+	return status, ok
+}
+
+// set переводит ордер binanceID в статус status и возвращает предыдущий статус. Статус не меняется, если
+// переход недопустим: например, ответ REST со статусом NEW пришел позже исполнения из потока пользовательских данных
+func (r *orderRegistry) set(binanceID int64, status string) (string, bool) {
+	if binanceID == 0 {
+		return "", false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	prev, known := r.statuses[binanceID]
+	if known && !model.CanTransition(prev, status) {
+		return prev, false
+	}
+	r.statuses[binanceID] = status
+	return prev, true
+}
+
+// EnableEvents включает публикацию событий смены статуса ордеров в канал events.
+// Канал закрывает вызывающий после остановки обработки ордеров.
+func (bm *BianceManager) EnableEvents(events chan model.OrderEvent) {
+	bm.events = events
+}
+
+// transition переводит ордер в статус to и публикует событие перехода
+func (bm *BianceManager) transition(order *model.Order, to, reason string) error {
+	event, err := order.Transition(to, reason)
+	if err != nil {
+		return err
+	}
+	bm.publishEvent(event)
+	return nil
+}
+
+// mustTransition выполняет переход, который допустим по построению конвейера. Ошибка означает нарушение
+// порядка этапов и только логируется, чтобы не потерять результат команды.
+func (bm *BianceManager) mustTransition(order *model.Order, to, reason string) {
+	if err := bm.transition(order, to, reason); err != nil {
+		logger.Log.Error(fmt.Sprintf("Ордер %s: %v", order.ClientOrderID, err))
+	}
+}
+
+// EnableUserStream включает обработку потока пользовательских данных: исполнения, отмены и истечения ордеров
+// обновляют их статусы и публикуются в канал reports. Поток работает до отмены ctx, по завершении закрывается done.
+// Если биржа сама сообщает об исполнениях, как симулятор, события берутся от нее, а url не используется.
+func (bm *BianceManager) EnableUserStream(ctx context.Context, url string, keepalive time.Duration, reports chan model.ExecutionReport) (done chan struct{}) {
+	bm.reports = reports
+	if source, ok := bm.exchange.(reportSource); ok {
+		return source.subscribe(ctx, bm)
+	}
+	stream := NewUserStream(bm.client, url, keepalive, bm)
+
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		stream.Run(ctx)
+	}()
+	return done
+}
+
+// handleReconnect сверяет ордера с биржей в фоне после переподключения потока пользовательских данных:
+// исполнения и отмены за время разрыва в поток не попадут. Без хранилища сверять не с чем
+func (bm *BianceManager) handleReconnect(ctx context.Context) {
+	if bm.store == nil {
+		return
+	}
+	bm.goBackground(func() {
+		if err := bm.Reconcile(ctx); err != nil {
+			logger.Log.Error("Ошибка при сверке ордеров после переподключения потока: ", err)
+		}
+	})
+}
+
+// handleExecutionReport обновляет статус ордера по событию биржи и публикует событие перехода и отчет об исполнении
+func (bm *BianceManager) handleExecutionReport(report model.ExecutionReport) {
+	logger.Log.Info(fmt.Sprintf("Ордер %d (%s) %s: %s, исполнено %s", report.BinanceID, report.Symbol,
+		report.ExecutionType, report.Status, report.CumulativeFilledQty))
+
+	prev, changed := bm.orders.set(report.BinanceID, report.Status)
+	if !changed && prev != report.Status {
+		logger.Log.Warn(fmt.Sprintf("Ордер %d: пропущен переход %s -> %s из потока пользовательских данных",
+			report.BinanceID, prev, report.Status))
+	}
+	if changed {
+		isOpen := prev == model.StatusNew || prev == model.StatusPartiallyFilled
+		if bm.symbolRules != nil && isOpen && model.IsFinalStatus(report.Status) {
+			bm.symbolRules.OrderClosed(report.Symbol)
+		}
+		bm.publishEvent(model.OrderEvent{
+			ClientOrderID: report.ClientOrderID,
+			BinanceID:     report.BinanceID,
+			Symbol:        report.Symbol,
+			From:          prev,
+			To:            report.Status,
+			Reason:        report.ExecutionType,
+			At:            report.TransactionTime,
+		})
+	}
+	bm.saveExecutionReport(report)
+	if bm.risk != nil {
+		bm.risk.handleExecutionReport(report)
+	}
+
+	if bm.reports != nil {
+		bm.reports <- report
+	}
+}
+
+// handleAccountPosition логирует изменение балансов и передает их проверке рисков
+func (bm *BianceManager) handleAccountPosition(position model.AccountPosition) {
+	for _, balance := range position.Balances {
+		logger.Log.Info(fmt.Sprintf("Баланс %s: свободно %s, заблокировано %s", balance.Asset, balance.Free, balance.Locked))
+	}
+	if bm.risk != nil {
+		bm.risk.handleAccountPosition(position)
+	}
+}
+
+// checkTargetStatus проверяет, что ордер, над которым выполняется редактирование или отмена, еще открыт
+func (bm *BianceManager) checkTargetStatus(order model.Order) error {
+	if order.Action != EditOrder && order.Action != CancelOrder {
+		return nil
+	}
+	status, ok := bm.orders.status(order.BinanceID)
+	if !ok {
+		status, ok = bm.storedStatus(order.BinanceID)
+	}
+	if ok && model.IsFinalStatus(status) {
+		return fmt.Errorf("нельзя выполнить %s для ордера %d в статусе %s", order.Action, order.BinanceID, status)
+	}
+	return nil
+}
+
+// rememberStatuses сохраняет статусы затронутых командой ордеров. Для редактирования учитываются только
+// выполненные части cancel-replace, поэтому его можно вызывать и после ошибки
+func (bm *BianceManager) rememberStatuses(order model.Order) {
+	switch order.Action {
+	case PlaceOrder:
+		bm.orders.set(order.BinanceID, order.Status)
+	case EditOrder:
+		if order.CancelResult == CancelReplaceSuccess {
+			bm.orders.set(order.ReplacedBinanceID, model.StatusCanceled)
+		}
+		if order.NewOrderResult == CancelReplaceSuccess {
+			bm.orders.set(order.BinanceID, order.Status)
+		}
+	case CancelOrder:
+		bm.orders.set(order.BinanceID, model.StatusCanceled)
+	}
+}
+
+// exchangeStatus переводит статус ордера Binance в статус жизненного цикла
+func exchangeStatus(status binance.OrderStatusType) string {
+	switch status {
+	case binance.OrderStatusTypeNew:
+		return model.StatusNew
+	case binance.OrderStatusTypePartiallyFilled:
+		return model.StatusPartiallyFilled
+	case binance.OrderStatusTypeFilled:
+		return model.StatusFilled
+	case binance.OrderStatusTypeCanceled, binance.OrderStatusTypePendingCancel:
+		return model.StatusCanceled
+	case binance.OrderStatusTypeRejected:
+		return model.StatusRejected
+	case binance.OrderStatusTypeExpired, binance.OrderStatusExpiredInMatch:
+		return model.StatusExpired
+	}
+	return model.StatusNew
+}
@@ -0,0 +1,223 @@
+package biance
+
+import (
+	"app/internal/model"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// orderTypeRule поля, которые требует или допускает тип ордера
+type orderTypeRule struct {
+	// Обязателен timeInForce
+	timeInForce bool
+	// Обязательна цена
+	price bool
+	// Обязательна цена срабатывания
+	stopPrice bool
+	// Допускается айсберг
+	iceberg bool
+	// Допускается quoteOrderQty вместо quantity
+	quoteOrderQty bool
+}
+
+var orderTypeRules = map[binance.OrderType]orderTypeRule{
+	binance.OrderTypeLimit:           {timeInForce: true, price: true, iceberg: true},
+	binance.OrderTypeMarket:          {quoteOrderQty: true},
+	binance.OrderTypeStopLoss:        {stopPrice: true},
+	binance.OrderTypeStopLossLimit:   {timeInForce: true, price: true, stopPrice: true, iceberg: true},
+	binance.OrderTypeTakeProfit:      {stopPrice: true},
+	binance.OrderTypeTakeProfitLimit: {timeInForce: true, price: true, stopPrice: true, iceberg: true},
+	binance.OrderTypeLimitMaker:      {price: true, iceberg: true},
+}
+
+var timeInForceTypes = map[binance.TimeInForceType]bool{
+	binance.TimeInForceTypeGTC: true,
+	binance.TimeInForceTypeIOC: true,
+	binance.TimeInForceTypeFOK: true,
+}
+
+// withOrderDefaults заполняет тип и срок действия ордера, если они не заданы:
+// LIMIT для совместимости со старыми командами и GTC для типов, где timeInForce обязателен
+func withOrderDefaults(order model.Order) model.Order {
+	if order.Action != PlaceOrder && order.Action != EditOrder {
+		return order
+	}
+	if order.Type == "" {
+		order.Type = string(binance.OrderTypeLimit)
+	}
+	if rule, ok := orderTypeRules[binance.OrderType(order.Type)]; ok && rule.timeInForce && order.TimeInForce == "" {
+		order.TimeInForce = string(binance.TimeInForceTypeGTC)
+	}
+	return order
+}
+
+// validateOrderType проверяет, что заполнены все поля, обязательные для типа ордера, и нет лишних
+func validateOrderType(order model.Order) error {
+	orderType := binance.OrderType(order.Type)
+	rule, ok := orderTypeRules[orderType]
+	if !ok {
+		return fmt.Errorf("неизвестный тип ордера: %q", order.Type)
+	}
+
+	if order.Quantity.IsNegative() || order.Price.IsNegative() || order.StopPrice.IsNegative() ||
+		order.QuoteOrderQty.IsNegative() || order.IcebergQty.IsNegative() {
+		return errors.New("цены и количества не могут быть отрицательными")
+	}
+
+	if rule.quoteOrderQty && order.QuoteOrderQty.IsPositive() {
+		if order.Quantity.IsPositive() {
+			return fmt.Errorf("для %s нужно указать только одно из quantity и quote_order_qty", orderType)
+		}
+	} else {
+		if order.QuoteOrderQty.IsPositive() {
+			return fmt.Errorf("quote_order_qty не допускается для %s", orderType)
+		}
+		if !order.Quantity.IsPositive() {
+			return fmt.Errorf("некорректное количество: %s", order.Quantity)
+		}
+	}
+
+	if rule.timeInForce {
+		if !timeInForceTypes[binance.TimeInForceType(order.TimeInForce)] {
+			return fmt.Errorf("некорректный time_in_force для %s: %q", orderType, order.TimeInForce)
+		}
+	} else if order.TimeInForce != "" {
+		return fmt.Errorf("time_in_force не допускается для %s", orderType)
+	}
+
+	if rule.price && !order.Price.IsPositive() {
+		return fmt.Errorf("для %s нужна цена", orderType)
+	}
+	if !rule.price && order.Price.IsPositive() {
+		return fmt.Errorf("цена не допускается для %s", orderType)
+	}
+
+	if rule.stopPrice && !order.StopPrice.IsPositive() {
+		return fmt.Errorf("для %s нужна stop_price", orderType)
+	}
+	if !rule.stopPrice && order.StopPrice.IsPositive() {
+		return fmt.Errorf("stop_price не допускается для %s", orderType)
+	}
+
+	if order.IcebergQty.IsPositive() {
+		if !rule.iceberg {
+			return fmt.Errorf("айсберг не допускается для %s", orderType)
+		}
+		if rule.timeInForce && binance.TimeInForceType(order.TimeInForce) != binance.TimeInForceTypeGTC {
+			return errors.New("айсберг допускается только с time_in_force GTC")
+		}
+		if order.IcebergQty.GreaterThanOrEqual(order.Quantity) {
+			return errors.New("iceberg_qty должно быть меньше quantity")
+		}
+	}
+	return nil
+}
+
+// orderFields параметры нового ордера, отформатированные для отправки на биржу. Пустая строка означает,
+// что параметр не передается
+type orderFields struct {
+	quantity      string
+	quoteOrderQty string
+	timeInForce   string
+	price         string
+	stopPrice     string
+	icebergQty    string
+}
+
+// newOrderFields форматирует параметры ордера с точностью precision и оставляет только те,
+// которые допускает тип ордера
+func newOrderFields(order model.Order, precision symbolPrecision) orderFields {
+	rule := orderTypeRules[binance.OrderType(order.Type)]
+
+	var fields orderFields
+	if order.Quantity.IsPositive() {
+		fields.quantity = precision.formatQuantity(order.Quantity)
+	}
+	if rule.quoteOrderQty && order.QuoteOrderQty.IsPositive() {
+		fields.quoteOrderQty = precision.formatQuote(order.QuoteOrderQty)
+	}
+	if rule.timeInForce {
+		fields.timeInForce = order.TimeInForce
+	}
+	if rule.price {
+		fields.price = precision.formatPrice(order.Price)
+	}
+	if rule.stopPrice {
+		fields.stopPrice = precision.formatPrice(order.StopPrice)
+	}
+	if rule.iceberg && order.IcebergQty.IsPositive() {
+		fields.icebergQty = precision.formatQuantity(order.IcebergQty)
+	}
+	return fields
+}
+
+// symbolPrecision точность параметров ордера данного типа по символу. Без загруженных правил значения передаются как есть
+func (bm *BianceManager) symbolPrecision(symbol, orderType string) symbolPrecision {
+	if bm.symbolRules != nil {
+		if precision, ok := bm.symbolRules.Precision(symbol, orderType); ok {
+			return precision
+		}
+	}
+	return symbolPrecision{price: -1, quantity: -1, quote: -1}
+}
+
+// newCreateOrderService формирует запрос на размещение ордера с полями, которые допускает его тип
+func (e *binanceExchange) newCreateOrderService(order model.Order) *binance.CreateOrderService {
+	fields := newOrderFields(order, e.precision(order.Symbol, order.Type))
+
+	service := e.signedClient().NewCreateOrderService().
+		Symbol(order.Symbol).
+		Side(binance.SideType(order.Side)).
+		Type(binance.OrderType(order.Type))
+
+	if fields.quantity != "" {
+		service.Quantity(fields.quantity)
+	}
+	if fields.quoteOrderQty != "" {
+		service.QuoteOrderQty(fields.quoteOrderQty)
+	}
+	if fields.timeInForce != "" {
+		service.TimeInForce(binance.TimeInForceType(fields.timeInForce))
+	}
+	if fields.price != "" {
+		service.Price(fields.price)
+	}
+	if fields.stopPrice != "" {
+		service.StopPrice(fields.stopPrice)
+	}
+	if fields.icebergQty != "" {
+		service.IcebergQuantity(fields.icebergQty)
+	}
+	if order.ClientOrderID != "" {
+		service.NewClientOrderID(order.ClientOrderID)
+	}
+	return service
+}
+
+// orderParams параметры нового ордера для эндпоинтов, которых нет в go-binance. Заполняются по тем же
+// правилам, что и в newCreateOrderService
+func (e *binanceExchange) orderParams(order model.Order) url.Values {
+	fields := newOrderFields(order, e.precision(order.Symbol, order.Type))
+
+	params := url.Values{}
+	params.Set("symbol", order.Symbol)
+	params.Set("side", order.Side)
+	params.Set("type", order.Type)
+	setParam(params, "quantity", fields.quantity)
+	setParam(params, "quoteOrderQty", fields.quoteOrderQty)
+	setParam(params, "timeInForce", fields.timeInForce)
+	setParam(params, "price", fields.price)
+	setParam(params, "stopPrice", fields.stopPrice)
+	setParam(params, "icebergQty", fields.icebergQty)
+	setParam(params, "newClientOrderId", order.ClientOrderID)
+	return params
+}
+
+func setParam(params url.Values, key, value string) {
+	if value != "" {
+		params.Set(key, value)
+	}
+}
@@ -0,0 +1,126 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+)
+
+// PaperConfig настройки бумажной торговли
+type PaperConfig struct {
+	// URL адрес Binance, с которого загружаются символы и текущие цены. Ключи API не нужны
+	URL string
+	// Symbols символы для торговли. Пустой список означает все символы биржи
+	Symbols []string
+	// Balances начальные балансы аккаунта
+	Balances map[string]decimal.Decimal
+	MakerFee decimal.Decimal
+	TakerFee decimal.Decimal
+	// PricesPath файл записанных цен для LoadPriceTicks. Если пустой, цены опрашиваются на бирже
+	PricesPath string
+	// PriceInterval период опроса цен биржи или пауза между записанными ценами
+	PriceInterval time.Duration
+}
+
+// PaperTrading бумажная торговля: симулятор с символами и правилами Binance, цены которого берутся
+// с биржи или из записи. Итоги по стратегиям доступны через expvar как paper_trading
+type PaperTrading struct {
+	Simulator *Simulator
+	feed      PriceFeed
+}
+
+// paperSimulator симулятор бумажной торговли для метрик
+var paperSimulator atomic.Pointer[Simulator]
+
+func init() {
+	expvar.Publish("paper_trading", expvar.Func(func() any {
+		if simulator := paperSimulator.Load(); simulator != nil {
+			return simulator.StrategyReports()
+		}
+		return nil
+	}))
+}
+
+// NewPaperTrading загружает символы из exchangeInfo Binance и создает симулятор с балансами из config
+func NewPaperTrading(ctx context.Context, config PaperConfig) (*PaperTrading, error) {
+	client := binance.NewClient("", "")
+	client.BaseURL = config.URL
+	client.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	info, err := client.NewExchangeInfoService().Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка при загрузке exchangeInfo для бумажной торговли: %w", err)
+	}
+
+	symbols := info.Symbols
+	if len(config.Symbols) > 0 {
+		wanted := make(map[string]bool, len(config.Symbols))
+		for _, symbol := range config.Symbols {
+			wanted[symbol] = true
+		}
+		symbols = symbols[:0]
+		for _, symbol := range info.Symbols {
+			if wanted[symbol.Symbol] {
+				symbols = append(symbols, symbol)
+				delete(wanted, symbol.Symbol)
+			}
+		}
+		for symbol := range wanted {
+			return nil, fmt.Errorf("символ %s не найден в exchangeInfo", symbol)
+		}
+	}
+
+	var feed PriceFeed
+	if config.PricesPath != "" {
+		ticks, err := LoadPriceTicks(config.PricesPath)
+		if err != nil {
+			return nil, err
+		}
+		feed = NewReplayFeed(ticks, config.PriceInterval)
+	} else {
+		feed = NewTickerFeed(config.URL, config.Symbols, config.PriceInterval)
+	}
+
+	simulator := NewSimulator(SimulatorConfig{
+		Symbols:  symbols,
+		Balances: config.Balances,
+		MakerFee: config.MakerFee,
+		TakerFee: config.TakerFee,
+	})
+	paperSimulator.Store(simulator)
+	logger.Log.Info(fmt.Sprintf("Бумажная торговля: %d символов, цены %s", len(symbols), priceSource(config)))
+	return &PaperTrading{Simulator: simulator, feed: feed}, nil
+}
+
+func priceSource(config PaperConfig) string {
+	if config.PricesPath != "" {
+		return "из файла " + config.PricesPath
+	}
+	return "с биржи каждые " + config.PriceInterval.String()
+}
+
+// Run передает симулятору цены до отмены ctx. Когда записанные цены заканчиваются, последние цены сохраняются
+func (p *PaperTrading) Run(ctx context.Context) {
+	err := p.Simulator.RunFeed(ctx, p.feed)
+	switch {
+	case err == nil:
+		logger.Log.Info("Записанные цены для бумажной торговли закончились")
+	case !errors.Is(err, context.Canceled):
+		logger.Log.Error("Ошибка источника цен бумажной торговли: ", err)
+	}
+}
+
+// LogReports пишет в лог итоги сделок по стратегиям
+func (p *PaperTrading) LogReports() {
+	for _, report := range p.Simulator.StrategyReports() {
+		logger.Log.Info(fmt.Sprintf("Стратегия %d: сделок %d, балансы %v, результат %v",
+			report.StrategyID, report.Fills, report.Balances, report.PnL))
+	}
+}
@@ -0,0 +1,86 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"app/internal/store"
+	"errors"
+	"fmt"
+)
+
+// EnableStore включает сохранение команд, переходов статусов и исполнений в хранилище orderStore.
+// Результаты выполнения команд сохраняет кафка вместе с их публикацией
+func (bm *BianceManager) EnableStore(orderStore *store.Store) {
+	bm.store = orderStore
+}
+
+// saveCommand сохраняет полученную команду и сообщает, что она уже сохранялась, то есть доставлена повторно.
+// Ошибка возвращается, только если ClientOrderID занят командой другой стратегии или символа: такую команду
+// нельзя выполнять. Остальные ошибки хранилища не останавливают выполнение команды
+func (bm *BianceManager) saveCommand(order model.Order) (bool, error) {
+	if bm.store == nil {
+		return false, nil
+	}
+	seen, err := bm.store.SaveCommand(order)
+	if errors.Is(err, store.ErrCommandConflict) {
+		return false, err
+	}
+	if err != nil {
+		logger.Log.Error(err)
+	}
+	return seen, nil
+}
+
+// saveExecutionReport сохраняет статус и сделки ордера из отчета об исполнении
+func (bm *BianceManager) saveExecutionReport(report model.ExecutionReport) {
+	if bm.store == nil {
+		return
+	}
+	if err := bm.store.SaveExecutionReport(report); err != nil {
+		logger.Log.Error(err)
+	}
+}
+
+// publishEvent сохраняет событие смены статуса и публикует его в канал событий
+func (bm *BianceManager) publishEvent(event model.OrderEvent) {
+	if bm.store != nil {
+		if err := bm.store.SaveEvent(event); err != nil {
+			logger.Log.Error(err)
+		}
+	}
+	if bm.events != nil {
+		bm.events <- event
+	}
+}
+
+// storedStatus возвращает статус ордера binanceID из хранилища, если он там есть. Нужен после перезапуска,
+// когда статусы ордеров, размещенных раньше, еще не известны. Найденный статус запоминается
+func (bm *BianceManager) storedStatus(binanceID int64) (string, bool) {
+	if bm.store == nil {
+		return "", false
+	}
+	orders, err := bm.store.Orders(store.OrderFilter{BinanceID: binanceID})
+	if err != nil {
+		logger.Log.Error(err)
+		return "", false
+	}
+	// Команды, не дошедшие до биржи, не говорят о статусе ордера. Завершенный статус важнее остальных
+	status := ""
+	for _, order := range orders {
+		if !model.IsExchangeStatus(order.Status) {
+			continue
+		}
+		if status == "" || model.IsFinalStatus(order.Status) {
+			status = order.Status
+		}
+		if model.IsFinalStatus(status) {
+			break
+		}
+	}
+	if status == "" {
+		return "", false
+	}
+	logger.Log.Info(fmt.Sprintf("Статус ордера %d восстановлен из хранилища: %s", binanceID, status))
+	bm.orders.set(binanceID, status)
+	return status, true
+}
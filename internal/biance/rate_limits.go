@@ -0,0 +1,158 @@
+package biance
+
+import (
+	"app/internal/request"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// Виды ограничений Binance из exchangeInfo.rateLimits
+const (
+	rateLimitWeight      = "REQUEST_WEIGHT"
+	rateLimitOrders      = "ORDERS"
+	rateLimitRawRequests = "RAW_REQUESTS"
+)
+
+// Заголовки ответа Binance с расходом за окно, например X-MBX-USED-WEIGHT-1M и X-MBX-ORDER-COUNT-10S
+const (
+	headerUsedWeight = "X-MBX-USED-WEIGHT-"
+	headerOrderCount = "X-MBX-ORDER-COUNT-"
+)
+
+const (
+	endpointOrder         = "/api/v3/order"
+	endpointOrderTest     = "/api/v3/order/test"
+	endpointCancelReplace = "/api/v3/order/cancelReplace"
+	endpointOpenOrders    = "/api/v3/openOrders"
+	endpointExchangeInfo  = "/api/v3/exchangeInfo"
+	endpointAccount       = "/api/v3/account"
+)
+
+// openOrdersAllSymbolsWeight вес запроса открытых ордеров без символа
+const openOrdersAllSymbolsWeight = 80
+
+// endpointCosts вес запросов к эндпоинтам и число ордеров, которые они создают. Вес открытых ордеров указан
+// для запроса по одному символу. Запросы к остальным эндпоинтам считаются с весом 1
+var endpointCosts = map[string]request.Cost{
+	http.MethodPost + " " + endpointOrder:         {rateLimitWeight: 1, rateLimitOrders: 1},
+	http.MethodGet + " " + endpointOrder:          {rateLimitWeight: 4},
+	http.MethodDelete + " " + endpointOrder:       {rateLimitWeight: 1},
+	http.MethodPost + " " + endpointOrderTest:     {rateLimitWeight: 1},
+	http.MethodPost + " " + endpointCancelReplace: {rateLimitWeight: 1, rateLimitOrders: 1},
+	http.MethodGet + " " + endpointOpenOrders:     {rateLimitWeight: 6},
+	http.MethodGet + " " + endpointExchangeInfo:   {rateLimitWeight: 20},
+	http.MethodGet + " " + endpointAccount:        {rateLimitWeight: 20},
+}
+
+// defaultRateLimits ограничения спотового API Binance, которые действуют до загрузки exchangeInfo
+var defaultRateLimits = []binance.RateLimit{
+	{RateLimitType: rateLimitWeight, Interval: "MINUTE", IntervalNum: 1, Limit: 6000},
+	{RateLimitType: rateLimitOrders, Interval: "SECOND", IntervalNum: 10, Limit: 100},
+	{RateLimitType: rateLimitOrders, Interval: "DAY", IntervalNum: 1, Limit: 200000},
+	{RateLimitType: rateLimitRawRequests, Interval: "MINUTE", IntervalNum: 5, Limit: 61000},
+}
+
+// requestCost расход одного запроса method к эндпоинту endpoint
+func requestCost(method, endpoint string) request.Cost {
+	cost := request.Cost{rateLimitWeight: 1, rateLimitRawRequests: 1}
+	for kind, amount := range endpointCosts[method+" "+endpoint] {
+		cost[kind] = amount
+	}
+	return cost
+}
+
+// openOrdersCost расход запроса открытых ордеров по символу symbol или, если он пустой, по всем символам
+func openOrdersCost(symbol string) request.Cost {
+	cost := requestCost(http.MethodGet, endpointOpenOrders)
+	if symbol == "" {
+		cost[rateLimitWeight] = openOrdersAllSymbolsWeight
+	}
+	return cost
+}
+
+// applyRateLimits задает ограничителю ограничения из exchangeInfo. Виды, которых нет в rateLimits, не меняются
+func applyRateLimits(limiter *request.RateLimiter, rateLimits []binance.RateLimit) {
+	limits := make(map[string][]request.Limit)
+	for _, rateLimit := range rateLimits {
+		interval, ok := rateLimitInterval(rateLimit.Interval, rateLimit.IntervalNum)
+		if !ok {
+			continue
+		}
+		limits[rateLimit.RateLimitType] = append(limits[rateLimit.RateLimitType], request.Limit{
+			Interval: interval,
+			Max:      int(rateLimit.Limit),
+		})
+	}
+	for kind, kindLimits := range limits {
+		limiter.SetLimits(kind, kindLimits)
+	}
+}
+
+// rateLimitInterval переводит интервал ограничения exchangeInfo (SECOND, MINUTE, HOUR, DAY) в длительность
+func rateLimitInterval(interval string, num int64) (time.Duration, bool) {
+	var unit time.Duration
+	switch interval {
+	case "SECOND":
+		unit = time.Second
+	case "MINUTE":
+		unit = time.Minute
+	case "HOUR":
+		unit = time.Hour
+	case "DAY":
+		unit = 24 * time.Hour
+	default:
+		return 0, false
+	}
+	return time.Duration(num) * unit, num > 0
+}
+
+// observeRateLimits передает ограничителю расход веса и ордеров из заголовков ответа биржи
+func observeRateLimits(limiter *request.RateLimiter, header http.Header) {
+	for key, values := range header {
+		if len(values) == 0 {
+			continue
+		}
+		key = strings.ToUpper(key)
+
+		var kind, suffix string
+		switch {
+		case strings.HasPrefix(key, headerUsedWeight):
+			kind, suffix = rateLimitWeight, strings.TrimPrefix(key, headerUsedWeight)
+		case strings.HasPrefix(key, headerOrderCount):
+			kind, suffix = rateLimitOrders, strings.TrimPrefix(key, headerOrderCount)
+		default:
+			continue
+		}
+
+		interval, ok := headerInterval(suffix)
+		if !ok {
+			continue
+		}
+		used, err := strconv.Atoi(values[0])
+		if err != nil {
+			continue
+		}
+		limiter.Observe(kind, interval, used)
+	}
+}
+
+// headerInterval разбирает интервал из имени заголовка: число и единица S, M, H или D
+func headerInterval(suffix string) (time.Duration, bool) {
+	if len(suffix) < 2 {
+		return 0, false
+	}
+	num, err := strconv.ParseInt(suffix[:len(suffix)-1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	units := map[byte]string{'S': "SECOND", 'M': "MINUTE", 'H': "HOUR", 'D': "DAY"}
+	unit, ok := units[suffix[len(suffix)-1]]
+	if !ok {
+		return 0, false
+	}
+	return rateLimitInterval(unit, num)
+}
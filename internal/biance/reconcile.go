@@ -0,0 +1,263 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"app/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+)
+
+// Причины событий смены статуса, найденных при сверке с биржей
+const (
+	reconcileReason       = "RECONCILE"
+	reconcileOrphanReason = "ORPHAN"
+)
+
+// reconcileUnsettledWindow сколько после последней смены статуса ордер без ответа биржи ищется на ней
+// по ClientOrderID. Более старые команды, так и не попавшие на биржу, больше не запрашиваются
+const reconcileUnsettledWindow = 24 * time.Hour
+
+// EnableReconciliation сверяет ордера в хранилище с биржей сразу и затем каждые interval до отмены ctx.
+// Требует хранилища: по нему определяются символы и ордера, которые сервис считает открытыми.
+func (bm *BianceManager) EnableReconciliation(ctx context.Context, interval time.Duration) error {
+	if bm.store == nil {
+		return errors.New("для сверки ордеров с биржей нужно хранилище")
+	}
+	if err := bm.Reconcile(ctx); err != nil {
+		return err
+	}
+	bm.goBackground(func() { bm.startReconciling(ctx, interval) })
+	return nil
+}
+
+// startReconciling периодически сверяет ордера до отмены ctx
+func (bm *BianceManager) startReconciling(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := bm.Reconcile(ctx); err != nil {
+				logger.Log.Error("Ошибка при сверке ордеров с биржей: ", err)
+			}
+		}
+	}
+}
+
+// Reconcile сверяет ордера с биржей по всем символам, с которыми работал сервис. Статусы ордеров, изменившиеся
+// на бирже без ведома сервиса, исправляются в хранилище, по ним публикуются события и исправленные результаты.
+// Открытые ордера на бирже, которых нет в хранилище, отмечаются событием с причиной ORPHAN.
+func (bm *BianceManager) Reconcile(ctx context.Context) error {
+	symbols, err := bm.store.Symbols()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, symbol := range symbols {
+		if err := bm.reconcileSymbol(ctx, symbol); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reconcileSymbol сверяет ордера одного символа
+func (bm *BianceManager) reconcileSymbol(ctx context.Context, symbol string) error {
+	var open []*binance.Order
+	err := bm.requester.SyncHandleWeightedRequest(requestCost(http.MethodGet, endpointOpenOrders), func() error {
+		var err error
+		open, err = bm.exchange.OpenOrders(ctx, symbol)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка запроса открытых ордеров %s: %w", symbol, err)
+	}
+	exchangeOpen := make(map[int64]*binance.Order, len(open))
+	for _, exchangeOrder := range open {
+		exchangeOpen[exchangeOrder.OrderID] = exchangeOrder
+	}
+
+	local, err := bm.store.Orders(store.OrderFilter{
+		Symbol: symbol,
+		Statuses: []string{model.StatusNew, model.StatusPartiallyFilled,
+			model.StatusQueued, model.StatusSent, model.StatusFailed},
+	})
+	if err != nil {
+		return err
+	}
+	known := make(map[int64]bool, len(local))
+	for _, order := range local {
+		if !model.IsExchangeStatus(order.Status) {
+			if !bm.isUnsettled(order) {
+				continue
+			}
+			// Ответ биржи на отправку не получен или не сохранен: ищем ордер по ClientOrderID
+			exchangeOrder, err := bm.queryOrder(ctx, order.Symbol, 0, order.ClientOrderID)
+			switch {
+			case isNoSuchOrder(err):
+				continue
+			case err != nil:
+				logger.Log.Error(err)
+				continue
+			}
+			known[exchangeOrder.OrderID] = true
+			bm.repairOrder(order, exchangeOrder)
+			continue
+		}
+
+		known[order.BinanceID] = true
+		exchangeOrder, ok := exchangeOpen[order.BinanceID]
+		if !ok {
+			// Ордер больше не открыт: узнаем, чем он закончился
+			exchangeOrder, err = bm.queryOrder(ctx, order.Symbol, order.BinanceID, "")
+			if err != nil {
+				logger.Log.Error(err)
+				continue
+			}
+		}
+		bm.repairOrder(order, exchangeOrder)
+	}
+
+	for _, exchangeOrder := range open {
+		if known[exchangeOrder.OrderID] {
+			continue
+		}
+		order, ok, err := bm.localOrder(exchangeOrder)
+		if err != nil {
+			logger.Log.Error(err)
+			continue
+		}
+		if ok {
+			bm.repairOrder(order, exchangeOrder)
+		} else {
+			bm.flagOrphan(exchangeOrder)
+		}
+	}
+	return nil
+}
+
+// isUnsettled сообщает, что команда размещения или редактирования могла попасть на биржу, но ее результат
+// неизвестен: запрос ушел и ответ не сохранен из-за сбоя или отправка завершилась временной ошибкой
+func (bm *BianceManager) isUnsettled(order model.Order) bool {
+	if order.ClientOrderID == "" || (order.Action != PlaceOrder && order.Action != EditOrder) {
+		return false
+	}
+	if order.Status == model.StatusFailed && !order.Retryable {
+		return false
+	}
+	return time.Since(order.StatusUpdatedAt) < reconcileUnsettledWindow
+}
+
+// queryOrder запрашивает на бирже ордер binanceID или, если он не задан, ордер с идентификатором clientOrderID
+func (bm *BianceManager) queryOrder(ctx context.Context, symbol string, binanceID int64, clientOrderID string) (*binance.Order, error) {
+	var exchangeOrder *binance.Order
+	err := bm.requester.SyncHandleWeightedRequest(requestCost(http.MethodGet, endpointOrder), func() error {
+		var err error
+		exchangeOrder, err = bm.exchange.QueryOrder(ctx, symbol, binanceID, clientOrderID)
+		return err
+	})
+	if err != nil && binanceID == 0 {
+		return nil, fmt.Errorf("ошибка запроса ордера %s: %w", clientOrderID, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса ордера %d: %w", binanceID, err)
+	}
+	return exchangeOrder, nil
+}
+
+// localOrder ищет в хранилище команду размещения или редактирования, создавшую ордер биржи. Ордер ищется
+// по BinanceID, а если его результат не успели сохранить, по ClientOrderID
+func (bm *BianceManager) localOrder(exchangeOrder *binance.Order) (model.Order, bool, error) {
+	filters := []store.OrderFilter{
+		{BinanceID: exchangeOrder.OrderID},
+		{Symbol: exchangeOrder.Symbol, ClientOrderID: exchangeOrder.ClientOrderID},
+	}
+	for _, filter := range filters {
+		orders, err := bm.store.Orders(filter)
+		if err != nil {
+			return model.Order{}, false, err
+		}
+		for _, order := range orders {
+			if order.Action == PlaceOrder || order.Action == EditOrder {
+				return order, true, nil
+			}
+		}
+	}
+	return model.Order{}, false, nil
+}
+
+// repairOrder переводит ордер в статус, который он имеет на бирже. Событие перехода публикуется,
+// а исправленный результат ставится в очередь публикации в топик готовых ордеров. Команда, ответ на которую
+// не получен или завершился временной ошибкой, получает результат найденного на бирже ордера
+func (bm *BianceManager) repairOrder(order model.Order, exchangeOrder *binance.Order) {
+	status := exchangeStatus(exchangeOrder.Status)
+	if order.Status == status {
+		return
+	}
+
+	prev := order.Status
+	order.BinanceID = exchangeOrder.OrderID
+	event, err := order.Repair(status, reconcileReason)
+	if err != nil {
+		logger.Log.Warn(fmt.Sprintf("Сверка: ордер %d на бирже в статусе %s: %v", order.BinanceID, status, err))
+		return
+	}
+	bm.publishEvent(event)
+	order.StatusUpdatedAt = time.UnixMilli(exchangeOrder.UpdateTime).UTC()
+	logger.Log.Warn(fmt.Sprintf("Сверка: статус ордера %d (%s) исправлен %s -> %s", order.BinanceID, order.Symbol, prev, status))
+
+	settled := model.IsExchangeStatus(prev)
+	if !settled {
+		order.OrderApiStatus = model.OrderApiStatusSuccess
+		order.Error = ""
+		order.FailedStage = ""
+		order.Retryable = false
+		order.ExecutedQty = parseDecimal(exchangeOrder.ExecutedQuantity)
+		order.CumulativeQuoteQty = parseDecimal(exchangeOrder.CummulativeQuoteQuantity)
+		bm.rememberResult(order)
+	}
+
+	bm.orders.set(order.BinanceID, status)
+	wasOpen := prev == model.StatusNew || prev == model.StatusPartiallyFilled
+	isOpen := status == model.StatusNew || status == model.StatusPartiallyFilled
+	if bm.symbolRules != nil {
+		switch {
+		case wasOpen && model.IsFinalStatus(status):
+			bm.symbolRules.OrderClosed(order.Symbol)
+		case !settled && isOpen:
+			bm.symbolRules.OrderOpened(order.Symbol)
+		}
+	}
+	if err := bm.store.SaveResult(order, true); err != nil {
+		logger.Log.Error(err)
+	}
+}
+
+// flagOrphan отмечает открытый ордер биржи, о котором сервис ничего не знает, например размещенный вручную
+func (bm *BianceManager) flagOrphan(exchangeOrder *binance.Order) {
+	status := exchangeStatus(exchangeOrder.Status)
+	// Ордер отмечается один раз, пока не изменится его статус
+	if prev, changed := bm.orders.set(exchangeOrder.OrderID, status); !changed || prev == status {
+		return
+	}
+	logger.Log.Warn(fmt.Sprintf("Сверка: на бирже открыт неизвестный сервису ордер %d (%s, client_order_id %s) в статусе %s",
+		exchangeOrder.OrderID, exchangeOrder.Symbol, exchangeOrder.ClientOrderID, status))
+	bm.publishEvent(model.OrderEvent{
+		ClientOrderID: exchangeOrder.ClientOrderID,
+		BinanceID:     exchangeOrder.OrderID,
+		Symbol:        exchangeOrder.Symbol,
+		To:            status,
+		Reason:        reconcileOrphanReason,
+		At:            time.UnixMilli(exchangeOrder.UpdateTime).UTC(),
+	})
+}
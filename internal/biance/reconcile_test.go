@@ -0,0 +1,129 @@
+package biance_test
+
+import (
+	"app/internal/biance"
+	"app/internal/model"
+	"app/internal/store"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// saveUnsettled сохраняет команду в статусе status, как ее оставил сбой или временная ошибка отправки
+func saveUnsettled(t *testing.T, s *store.Store, order model.Order, status string) {
+	t.Helper()
+	order.Action = biance.PlaceOrder
+	order.Status = status
+	order.StatusUpdatedAt = time.Now().UTC()
+	if _, err := s.SaveCommand(order); err != nil {
+		t.Fatal(err)
+	}
+	if status != model.StatusFailed {
+		return
+	}
+	order.OrderApiStatus = model.OrderApiStatusError
+	order.Error = "timeout"
+	order.FailedStage = model.StageExchange
+	order.Retryable = true
+	if err := s.SaveResult(order, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func storedOrder(t *testing.T, s *store.Store, clientOrderID string) model.Order {
+	t.Helper()
+	orders, err := s.Orders(store.OrderFilter{ClientOrderID: clientOrderID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("записей команды %s: %d, ожидалась 1", clientOrderID, len(orders))
+	}
+	return orders[0]
+}
+
+func TestReconcileRecoversUnsettledOrders(t *testing.T) {
+	sim := newTestSimulator(map[string]string{"USDT": "1000"})
+	ctx := context.Background()
+	if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(100)); err != nil {
+		t.Fatal(err)
+	}
+	orderStore := newTestStore(t)
+
+	// Запрос ушел на биржу, но сервис упал до ответа
+	sent := limitOrder("BUY", "1", "90")
+	sent.ClientOrderID = "sent-1"
+	if _, err := sim.PlaceOrder(ctx, sent); err != nil {
+		t.Fatal(err)
+	}
+	saveUnsettled(t, orderStore, sent, model.StatusSent)
+
+	// Отправка завершилась временной ошибкой, хотя ордер исполнен
+	failed := marketOrder("BUY", "1")
+	failed.ClientOrderID = "failed-1"
+	if _, err := sim.PlaceOrder(ctx, failed); err != nil {
+		t.Fatal(err)
+	}
+	saveUnsettled(t, orderStore, failed, model.StatusFailed)
+
+	// Отправка завершилась временной ошибкой, и ордер не попал на биржу
+	lost := limitOrder("BUY", "1", "80")
+	lost.ClientOrderID = "lost-1"
+	saveUnsettled(t, orderStore, lost, model.StatusFailed)
+
+	bm := newTestManager(t, sim, orderStore)
+	if err := bm.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		clientOrderID string
+		status        string
+		placed        bool
+	}{
+		{"sent-1", model.StatusNew, true},
+		{"failed-1", model.StatusFilled, true},
+		{"lost-1", model.StatusFailed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.clientOrderID, func(t *testing.T) {
+			got := storedOrder(t, orderStore, tt.clientOrderID)
+			if got.Status != tt.status || (got.BinanceID != 0) != tt.placed {
+				t.Fatalf("статус %s, ордер %d, ожидался %s", got.Status, got.BinanceID, tt.status)
+			}
+			if tt.placed && (got.OrderApiStatus != model.OrderApiStatusSuccess || got.Retryable || got.Error != "") {
+				t.Fatalf("результат не исправлен: %s, повтор %v, ошибка %q", got.OrderApiStatus, got.Retryable, got.Error)
+			}
+
+			events, err := orderStore.Events(tt.clientOrderID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, event := range events {
+				if event.Reason == "ORPHAN" {
+					t.Fatalf("ордер сервиса отмечен как неизвестный: %+v", event)
+				}
+			}
+		})
+	}
+
+	// Исправленные результаты ставятся в очередь публикации
+	pending, err := orderStore.PendingOutbox(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("в очереди публикации %d результатов, ожидалось 2", len(pending))
+	}
+
+	// Повтор команды после сверки получает найденный ордер, а не размещает новый
+	failed.Action = biance.PlaceOrder
+	failed.Attempt = 1
+	replayed := bm.Execute(failed)
+	if replayed.Status != model.StatusFilled || replayed.BinanceID != storedOrder(t, orderStore, "failed-1").BinanceID {
+		t.Fatalf("повтор: статус %s, ордер %d: %s", replayed.Status, replayed.BinanceID, replayed.Error)
+	}
+	assertBalance(t, sim, "BTC", "0.998", "0")
+}
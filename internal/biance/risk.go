@@ -0,0 +1,857 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"app/internal/store"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/shopspring/decimal"
+)
+
+// riskUnmatchedTTL сколько хранится отчет об исполнении ордера, ответ о размещении которого еще не получен
+const riskUnmatchedTTL = time.Minute
+
+// riskUncertainTTL через сколько ордер с неизвестным после временной ошибки результатом запрашивается на бирже
+const riskUncertainTTL = time.Minute
+
+// executionTypeTrade тип отчета об исполнении со сделкой
+const executionTypeTrade = "TRADE"
+
+// RiskLimits ограничения стратегии для проверки ордеров перед отправкой. Нулевое или не заданное значение
+// не ограничивает. Суммы задаются по активам котировки, например {"USDT": "1000"}, позиции по базовым активам
+type RiskLimits struct {
+	StrategyID int64 `json:"strategy_id"`
+	// MaxOrderNotional наибольшая сумма одного ордера
+	MaxOrderNotional map[string]decimal.Decimal `json:"max_order_notional"`
+	// MaxOpenOrders наибольшее число открытых ордеров стратегии
+	MaxOpenOrders int `json:"max_open_orders"`
+	// MaxPosition наибольшая позиция по активу с учетом открытых ордеров. Позиция считается по сделкам
+	// ордеров стратегии, сохраненным в хранилище, и сделкам из потока пользовательских данных: покупки
+	// увеличивают ее, продажи уменьшают. Без хранилища позицию не восстановить после перезапуска, поэтому
+	// лимит требует хранилища
+	MaxPosition map[string]decimal.Decimal `json:"max_position"`
+	// MaxDailyTurnover наибольшая сумма ордеров стратегии за сутки UTC. После перезапуска оборот восстанавливается
+	// по сохраненным сделкам и открытым ордерам, поэтому лимит, как и MaxPosition, требует хранилища
+	MaxDailyTurnover map[string]decimal.Decimal `json:"max_daily_turnover"`
+}
+
+// LoadRiskLimits читает ограничения стратегий из JSON файла path со списком RiskLimits
+func LoadRiskLimits(path string) ([]RiskLimits, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения лимитов стратегий: %v", err)
+	}
+	var list []RiskLimits
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("ошибка разбора лимитов стратегий: %v", err)
+	}
+
+	seen := make(map[int64]bool, len(list))
+	for _, limits := range list {
+		if seen[limits.StrategyID] {
+			return nil, fmt.Errorf("лимиты стратегии %d заданы дважды", limits.StrategyID)
+		}
+		seen[limits.StrategyID] = true
+		if limits.MaxOpenOrders < 0 {
+			return nil, fmt.Errorf("стратегия %d: max_open_orders не может быть отрицательным", limits.StrategyID)
+		}
+		for name, values := range map[string]map[string]decimal.Decimal{
+			"max_order_notional": limits.MaxOrderNotional,
+			"max_position":       limits.MaxPosition,
+			"max_daily_turnover": limits.MaxDailyTurnover,
+		} {
+			for asset, value := range values {
+				if value.IsNegative() {
+					return nil, fmt.Errorf("стратегия %d: %s для %s не может быть отрицательным", limits.StrategyID, name, asset)
+				}
+			}
+		}
+	}
+	return list, nil
+}
+
+// riskBalance свободный и заблокированный остаток актива на бирже
+type riskBalance struct {
+	free   decimal.Decimal
+	locked decimal.Decimal
+}
+
+// riskOrder ордер стратегии, учтенный в проверке рисков: от резерва перед отправкой до закрытия на бирже
+type riskOrder struct {
+	strategyID    int64
+	clientOrderID string
+	symbol        string
+	side          string
+	baseAsset     string
+	quoteAsset    string
+	binanceID     int64
+	// quantity количество ордера. Для рыночного ордера на сумму оценивается по последней цене, 0 если цена неизвестна
+	quantity decimal.Decimal
+	// notional сумма ордера в активе котировки, 0 если до исполнения она неизвестна
+	notional decimal.Decimal
+	// reserved сумма, заблокированная под ордер в reservedAsset: актив котировки для покупки, базовый для продажи
+	reserved      decimal.Decimal
+	reservedAsset string
+	// day сутки, в оборот которых записан notional
+	day time.Time
+	// filled и filledQuote исполненное количество и сумма, уже учтенные в позиции и обороте
+	filled      decimal.Decimal
+	filledQuote decimal.Decimal
+	// uncertainSince время временной ошибки, после которой неизвестно, размещен ли ордер
+	uncertainSince time.Time
+}
+
+// remaining неисполненное количество ордера
+func (o *riskOrder) remaining() decimal.Decimal {
+	if o.filled.GreaterThanOrEqual(o.quantity) {
+		return decimal.Zero
+	}
+	return o.quantity.Sub(o.filled)
+}
+
+// unmatchedReport отчет об исполнении ордера, который еще не сопоставлен со стратегией
+type unmatchedReport struct {
+	report     model.ExecutionReport
+	receivedAt time.Time
+}
+
+// riskEngine проверка ордеров перед отправкой на биржу: хватает ли свободного баланса и не нарушены ли
+// лимиты стратегии. Балансы берутся из /api/v3/account и потока пользовательских данных, позиции,
+// открытые ордера и оборот стратегий считаются по командам сервиса и отчетам об исполнении
+type riskEngine struct {
+	limits map[int64]RiskLimits
+	// assets возвращает базовый актив и актив котировки символа
+	assets func(symbol string) (base, quote string, ok bool)
+	now    func() time.Time
+
+	mu sync.Mutex
+	// balances остатки по активам. Баланс не проверяется, пока не загружен с биржи
+	balances       map[string]riskBalance
+	balancesLoaded bool
+	// pending ордера, отправленные на биржу и еще не получившие ответ
+	pending map[*riskOrder]struct{}
+	// uncertain ордера по ClientOrderID, отправка которых завершилась временной ошибкой: они могли попасть
+	// на биржу, поэтому резерв сохраняется, пока это не выяснят поток пользовательских данных, повтор команды
+	// или запрос ордера на бирже
+	uncertain map[string]*riskOrder
+	// open открытые ордера по BinanceID
+	open map[int64]*riskOrder
+	// unmatched отчеты об исполнении, пришедшие раньше ответа о размещении
+	unmatched map[int64]unmatchedReport
+	// positions позиции стратегий по базовым активам
+	positions map[int64]map[string]decimal.Decimal
+	// turnover оборот стратегий за сутки turnoverDay по активам котировки
+	turnover    map[int64]map[string]decimal.Decimal
+	turnoverDay time.Time
+	// lastPrices цены последних сделок по символам для оценки рыночных ордеров
+	lastPrices map[string]decimal.Decimal
+}
+
+func newRiskEngine(limits []RiskLimits, assets func(symbol string) (string, string, bool)) *riskEngine {
+	e := riskEngine{
+		limits:     make(map[int64]RiskLimits, len(limits)),
+		assets:     assets,
+		now:        time.Now,
+		balances:   make(map[string]riskBalance),
+		pending:    make(map[*riskOrder]struct{}),
+		uncertain:  make(map[string]*riskOrder),
+		open:       make(map[int64]*riskOrder),
+		unmatched:  make(map[int64]unmatchedReport),
+		positions:  make(map[int64]map[string]decimal.Decimal),
+		turnover:   make(map[int64]map[string]decimal.Decimal),
+		lastPrices: make(map[string]decimal.Decimal),
+	}
+	for _, strategyLimits := range limits {
+		e.limits[strategyLimits.StrategyID] = strategyLimits
+	}
+	return &e
+}
+
+// EnableRiskChecks включает проверку ордеров перед отправкой: баланса по всем ордерам и лимитов limits
+// по стратегиям, для которых они заданы. Требует правил символов: по ним определяются активы ордера.
+// Балансы загружаются с биржи сразу и затем каждые refreshInterval до отмены ctx, между загрузками обновляются
+// из потока пользовательских данных. Если задано хранилище, открытые ордера на бирже относятся к стратегиям по нему,
+// а позиции и оборот стратегий восстанавливаются по сохраненным сделкам. Лимиты позиций и оборота без хранилища
+// не принимаются.
+func (bm *BianceManager) EnableRiskChecks(ctx context.Context, limits []RiskLimits, refreshInterval time.Duration) error {
+	if bm.symbolRules == nil {
+		return errors.New("для проверки рисков нужны правила символов")
+	}
+	if bm.store == nil {
+		for _, strategyLimits := range limits {
+			if len(strategyLimits.MaxPosition) > 0 {
+				return fmt.Errorf("стратегия %d: для max_position нужно хранилище ордеров (DATABASE_DSN), "+
+					"иначе позиция сбрасывается при перезапуске", strategyLimits.StrategyID)
+			}
+			if len(strategyLimits.MaxDailyTurnover) > 0 {
+				return fmt.Errorf("стратегия %d: для max_daily_turnover нужно хранилище ордеров (DATABASE_DSN), "+
+					"иначе оборот сбрасывается при перезапуске", strategyLimits.StrategyID)
+			}
+		}
+	}
+	risk := newRiskEngine(limits, bm.symbolRules.Assets)
+	if err := bm.refreshRiskBalances(ctx, risk); err != nil {
+		return err
+	}
+	if err := bm.restoreRiskOrders(ctx, risk); err != nil {
+		return err
+	}
+	if err := bm.restoreRiskFills(risk); err != nil {
+		return err
+	}
+	bm.risk = risk
+	bm.goBackground(func() { bm.startRefreshingRiskBalances(ctx, refreshInterval) })
+
+	logger.Log.Info(fmt.Sprintf("Проверка рисков включена: лимиты для %d стратегий", len(limits)))
+	return nil
+}
+
+// refreshRiskBalances загружает балансы аккаунта с биржи
+func (bm *BianceManager) refreshRiskBalances(ctx context.Context, risk *riskEngine) error {
+	var balances []binance.Balance
+	err := bm.requester.SyncHandleWeightedRequest(requestCost(http.MethodGet, endpointAccount), func() error {
+		var err error
+		balances, err = bm.exchange.Balances(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка при получении балансов для проверки рисков: %w", err)
+	}
+	risk.setBalances(balances)
+	return nil
+}
+
+// startRefreshingRiskBalances периодически загружает балансы и выясняет судьбу ордеров с неизвестным
+// результатом до отмены ctx
+func (bm *BianceManager) startRefreshingRiskBalances(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := bm.refreshRiskBalances(ctx, bm.risk); err != nil {
+				// Продолжаем с балансами из потока пользовательских данных
+				logger.Log.Error(err)
+			}
+			bm.resolveRiskOrders(ctx, bm.risk)
+		}
+	}
+}
+
+// restoreRiskOrders учитывает открытые ордера биржи, размещенные до запуска. Стратегия ордера определяется
+// по хранилищу, ордера без хранилища или неизвестные ему не относятся ни к одной стратегии
+func (bm *BianceManager) restoreRiskOrders(ctx context.Context, risk *riskEngine) error {
+	if bm.store == nil {
+		return nil
+	}
+	var openOrders []*binance.Order
+	err := bm.requester.SyncHandleWeightedRequest(openOrdersCost(""), func() error {
+		var err error
+		openOrders, err = bm.exchange.OpenOrders(ctx, "")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка при получении открытых ордеров для проверки рисков: %w", err)
+	}
+	for _, exchangeOrder := range openOrders {
+		order, ok, err := bm.localOrder(exchangeOrder)
+		if err != nil {
+			return err
+		}
+		if ok {
+			risk.restore(order.StrategyID, exchangeOrder)
+		}
+	}
+	return nil
+}
+
+// resolveRiskOrders запрашивает на бирже ордера, результат отправки которых неизвестен дольше riskUncertainTTL.
+// Найденный ордер учитывается как открытый или исполненный, резерв ненайденного снимается. Если запрос
+// не удался, ордер остается в резерве до следующей попытки
+func (bm *BianceManager) resolveRiskOrders(ctx context.Context, risk *riskEngine) {
+	for _, o := range risk.uncertainOrders() {
+		var exchangeOrder *binance.Order
+		err := bm.requester.SyncHandleWeightedRequest(requestCost(http.MethodGet, endpointOrder), func() error {
+			var err error
+			exchangeOrder, err = bm.exchange.QueryOrder(ctx, o.symbol, 0, o.clientOrderID)
+			return err
+		})
+		switch {
+		case err == nil:
+			risk.resolve(o, exchangeOrder)
+		case isNoSuchOrder(err):
+			logger.Log.Info(fmt.Sprintf("Ордер %s не размещен на бирже: резерв проверки рисков снят", o.clientOrderID))
+			risk.resolve(o, nil)
+		default:
+			logger.Log.Warn(fmt.Sprintf("Не удалось выяснить, размещен ли ордер %s: %v", o.clientOrderID, err))
+		}
+	}
+}
+
+// isNoSuchOrder сообщает, что биржа не нашла запрошенный ордер
+func isNoSuchOrder(err error) bool {
+	var apiErr *common.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == codeNoSuchOrder
+}
+
+// restoreRiskFills восстанавливает по сделкам ордеров из хранилища позиции и оборот за текущие сутки стратегий
+// с лимитом позиции или оборота, чтобы перезапуск не сбрасывал их. Исполнение открытых ордеров, учтенных
+// в restoreRiskOrders, входит в позицию и оборот здесь, поэтому fill его не повторит
+func (bm *BianceManager) restoreRiskFills(risk *riskEngine) error {
+	var strategyIDs []int64
+	for strategyID, limits := range risk.limits {
+		if len(limits.MaxPosition) > 0 || len(limits.MaxDailyTurnover) > 0 {
+			strategyIDs = append(strategyIDs, strategyID)
+		}
+	}
+	if bm.store == nil || len(strategyIDs) == 0 {
+		return nil
+	}
+
+	orders, err := bm.store.Orders(store.OrderFilter{StrategyIDs: strategyIDs, Actions: []string{PlaceOrder, EditOrder}})
+	if err != nil {
+		return fmt.Errorf("ошибка восстановления позиций и оборота для проверки рисков: %w", err)
+	}
+	seen := make(map[int64]bool, len(orders))
+	for _, order := range orders {
+		if order.BinanceID == 0 || seen[order.BinanceID] {
+			continue
+		}
+		seen[order.BinanceID] = true
+		fills, err := bm.store.Fills(order.BinanceID)
+		if err != nil {
+			return fmt.Errorf("ошибка восстановления позиций и оборота для проверки рисков: %w", err)
+		}
+		for _, fill := range fills {
+			risk.addFill(order.StrategyID, fill)
+		}
+	}
+	return nil
+}
+
+// checkRisk проверяет ордер и резервирует под него баланс, оборот и место среди открытых ордеров стратегии.
+// Резерв снимается или закрепляется в settleRisk по результату команды. Для dry_run резерв не создается
+func (bm *BianceManager) checkRisk(order model.Order) (*riskOrder, error) {
+	if bm.risk == nil {
+		return nil, nil
+	}
+	return bm.risk.reserve(order)
+}
+
+// settleRisk учитывает результат выполненной команды
+func (bm *BianceManager) settleRisk(reservation *riskOrder, order model.Order) {
+	if bm.risk == nil || order.DryRun {
+		return
+	}
+	bm.risk.settle(reservation, order)
+}
+
+// setBalances заменяет балансы снимком с биржи и резервирует в нем отправленные ордера
+func (e *riskEngine) setBalances(balances []binance.Balance) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.balances = make(map[string]riskBalance, len(balances))
+	assets := make(map[string]bool, len(balances))
+	for _, balance := range balances {
+		e.balances[balance.Asset] = riskBalance{free: parseDecimal(balance.Free), locked: parseDecimal(balance.Locked)}
+		assets[balance.Asset] = true
+	}
+	e.lockUnsettled(assets)
+	e.balancesLoaded = true
+}
+
+// lockUnsettled резервирует в снимке балансов assets ордера, ответ на отправку которых не получен или результат
+// которых неизвестен: биржа их может еще не учитывать. Если ордер уже на бирже, его сумма резервируется дважды
+// до следующего снимка, что лишь строже проверяет баланс
+func (e *riskEngine) lockUnsettled(assets map[string]bool) {
+	for o := range e.pending {
+		if assets[o.reservedAsset] {
+			e.lock(o.reservedAsset, o.reserved)
+		}
+	}
+	for _, o := range e.uncertain {
+		if assets[o.reservedAsset] {
+			e.lock(o.reservedAsset, o.reserved)
+		}
+	}
+}
+
+// restore учитывает открытый ордер биржи стратегии strategyID
+func (e *riskEngine) restore(strategyID int64, exchangeOrder *binance.Order) {
+	base, quote, ok := e.assets(exchangeOrder.Symbol)
+	if !ok {
+		return
+	}
+	price := parseDecimal(exchangeOrder.Price)
+	quantity := parseDecimal(exchangeOrder.OrigQuantity)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	o := &riskOrder{
+		strategyID:  strategyID,
+		symbol:      exchangeOrder.Symbol,
+		side:        string(exchangeOrder.Side),
+		baseAsset:   base,
+		quoteAsset:  quote,
+		binanceID:   exchangeOrder.OrderID,
+		quantity:    quantity,
+		notional:    price.Mul(quantity),
+		filled:      parseDecimal(exchangeOrder.ExecutedQuantity),
+		filledQuote: parseDecimal(exchangeOrder.CummulativeQuoteQuantity),
+	}
+	if exchangeOrder.Side == binance.SideTypeBuy {
+		o.reserved, o.reservedAsset = price.Mul(o.remaining()), quote
+	} else {
+		o.reserved, o.reservedAsset = o.remaining(), base
+	}
+	// Неисполненная часть ордера, размещенного в текущие сутки, входит в оборот. Исполненная учитывается
+	// по сделкам из хранилища
+	e.rollDay()
+	if !time.UnixMilli(exchangeOrder.Time).Before(e.turnoverDay) {
+		o.day = e.turnoverDay
+		e.addTurnover(strategyID, quote, price.Mul(o.remaining()))
+	}
+	e.open[exchangeOrder.OrderID] = o
+}
+
+// addFill учитывает сделку стратегии strategyID в ее позиции и, если сделка совершена в текущие сутки UTC, в обороте
+func (e *riskEngine) addFill(strategyID int64, fill model.Fill) {
+	base, quote, ok := e.assets(fill.Symbol)
+	if !ok {
+		return
+	}
+	quantity := fill.Quantity
+	if fill.Side != string(binance.SideTypeBuy) {
+		quantity = quantity.Neg()
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.movePosition(strategyID, base, quantity)
+	e.rollDay()
+	if !fill.Time.Before(e.turnoverDay) {
+		e.addTurnover(strategyID, quote, fill.Price.Mul(fill.Quantity))
+	}
+}
+
+// reserve проверяет команду place_order или edit_order и, если это не dry_run, резервирует ее
+func (e *riskEngine) reserve(order model.Order) (*riskOrder, error) {
+	if order.Action != PlaceOrder && order.Action != EditOrder {
+		return nil, nil
+	}
+	base, quote, ok := e.assets(order.Symbol)
+	if !ok {
+		return nil, fmt.Errorf("символ %s не найден в exchangeInfo", order.Symbol)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rollDay()
+
+	candidate := &riskOrder{
+		strategyID:    order.StrategyID,
+		clientOrderID: order.ClientOrderID,
+		symbol:        order.Symbol,
+		side:          order.Side,
+		baseAsset:     base,
+		quoteAsset:    quote,
+		quantity:      order.Quantity,
+		day:           e.turnoverDay,
+	}
+	price := order.Price
+	if !price.IsPositive() {
+		price = e.lastPrices[order.Symbol]
+	}
+	switch {
+	case order.QuoteOrderQty.IsPositive():
+		candidate.notional = order.QuoteOrderQty
+		if price.IsPositive() {
+			candidate.quantity = order.QuoteOrderQty.Div(price)
+		}
+	case price.IsPositive():
+		candidate.notional = price.Mul(order.Quantity)
+	}
+	if order.Side == string(binance.SideTypeBuy) {
+		candidate.reserved, candidate.reservedAsset = candidate.notional, quote
+	} else {
+		candidate.reserved, candidate.reservedAsset = candidate.quantity, base
+	}
+
+	// Повтор команды после временной ошибки заменяет резерв предыдущей попытки
+	retried, isRetry := e.uncertain[order.ClientOrderID]
+	if isRetry && !order.DryRun {
+		delete(e.uncertain, order.ClientOrderID)
+		e.release(retried)
+	}
+	// Редактирование заменяет ордер: его резерв и исполнение не учитываются
+	var replaced *riskOrder
+	if order.Action == EditOrder {
+		replaced = e.open[order.BinanceID]
+	}
+	var err error
+	if limits, ok := e.limits[order.StrategyID]; ok {
+		err = e.checkLimits(order, candidate, replaced, limits)
+	}
+	if err == nil {
+		err = e.checkBalance(candidate, replaced)
+	}
+	if err != nil {
+		if isRetry && !order.DryRun {
+			// Ордер предыдущей попытки мог попасть на биржу: его резерв остается
+			e.hold(retried)
+			e.uncertain[order.ClientOrderID] = retried
+		}
+		return nil, err
+	}
+
+	if order.DryRun {
+		return nil, nil
+	}
+	e.lock(candidate.reservedAsset, candidate.reserved)
+	e.addTurnover(candidate.strategyID, quote, candidate.notional)
+	e.pending[candidate] = struct{}{}
+	return candidate, nil
+}
+
+// checkLimits проверяет ордер candidate по лимитам стратегии
+func (e *riskEngine) checkLimits(order model.Order, candidate, replaced *riskOrder, limits RiskLimits) error {
+	strategyID, quote, base := candidate.strategyID, candidate.quoteAsset, candidate.baseAsset
+
+	if maxNotional := limits.MaxOrderNotional[quote]; maxNotional.IsPositive() {
+		if candidate.notional.IsZero() {
+			return fmt.Errorf("стратегия %d: сумма рыночного ордера по %s неизвестна до первой сделки, лимит %s %s",
+				strategyID, order.Symbol, maxNotional, quote)
+		}
+		if candidate.notional.GreaterThan(maxNotional) {
+			return fmt.Errorf("стратегия %d: сумма ордера %s %s больше лимита %s %s",
+				strategyID, candidate.notional, quote, maxNotional, quote)
+		}
+	}
+
+	if limits.MaxOpenOrders > 0 && order.Action == PlaceOrder {
+		if open := e.openOrders(strategyID, replaced); open >= limits.MaxOpenOrders {
+			return fmt.Errorf("стратегия %d: открыто %d ордеров из %d допустимых", strategyID, open, limits.MaxOpenOrders)
+		}
+	}
+
+	if maxPosition := limits.MaxPosition[base]; maxPosition.IsPositive() {
+		if candidate.quantity.IsZero() {
+			return fmt.Errorf("стратегия %d: количество рыночного ордера по %s неизвестно до первой сделки, лимит позиции %s %s",
+				strategyID, order.Symbol, maxPosition, base)
+		}
+		// Ордер проверяется только в сторону, в которую он увеличивает позицию
+		position := e.positions[strategyID][base]
+		if candidate.side == string(binance.SideTypeBuy) {
+			projected := position.Add(e.openQuantity(strategyID, base, candidate.side, replaced)).Add(candidate.quantity)
+			if projected.GreaterThan(maxPosition) {
+				return fmt.Errorf("стратегия %d: позиция %s с открытыми ордерами станет %s, больше лимита %s",
+					strategyID, base, projected, maxPosition)
+			}
+		} else {
+			projected := position.Sub(e.openQuantity(strategyID, base, candidate.side, replaced)).Sub(candidate.quantity)
+			if projected.LessThan(maxPosition.Neg()) {
+				return fmt.Errorf("стратегия %d: позиция %s с открытыми ордерами станет %s, меньше лимита -%s",
+					strategyID, base, projected, maxPosition)
+			}
+		}
+	}
+
+	if maxTurnover := limits.MaxDailyTurnover[quote]; maxTurnover.IsPositive() {
+		if candidate.notional.IsZero() {
+			return fmt.Errorf("стратегия %d: сумма рыночного ордера по %s неизвестна до первой сделки, лимит оборота %s %s",
+				strategyID, order.Symbol, maxTurnover, quote)
+		}
+		turnover := e.turnover[strategyID][quote]
+		if turnover.Add(candidate.notional).GreaterThan(maxTurnover) {
+			return fmt.Errorf("стратегия %d: оборот за сутки %s %s с ордером на %s превысит лимит %s %s",
+				strategyID, turnover, quote, candidate.notional, maxTurnover, quote)
+		}
+	}
+	return nil
+}
+
+// checkBalance проверяет, что свободного баланса хватает на резерв ордера. Освобождаемый при редактировании
+// резерв прежнего ордера считается свободным
+func (e *riskEngine) checkBalance(candidate, replaced *riskOrder) error {
+	if !e.balancesLoaded || !candidate.reserved.IsPositive() {
+		return nil
+	}
+	free := e.balances[candidate.reservedAsset].free
+	if replaced != nil && replaced.reservedAsset == candidate.reservedAsset {
+		free = free.Add(replaced.reserved)
+	}
+	if free.LessThan(candidate.reserved) {
+		return fmt.Errorf("недостаточно %s: нужно %s, свободно %s", candidate.reservedAsset, candidate.reserved, free)
+	}
+	return nil
+}
+
+// openOrders число открытых и отправленных ордеров стратегии без заменяемого ордера
+func (e *riskEngine) openOrders(strategyID int64, replaced *riskOrder) int {
+	count := 0
+	e.eachOrder(func(o *riskOrder) {
+		if o.strategyID == strategyID && o != replaced {
+			count++
+		}
+	})
+	return count
+}
+
+// openQuantity неисполненное количество открытых и отправленных ордеров стратегии по активу в сторону side
+func (e *riskEngine) openQuantity(strategyID int64, base, side string, replaced *riskOrder) decimal.Decimal {
+	quantity := decimal.Zero
+	e.eachOrder(func(o *riskOrder) {
+		if o.strategyID == strategyID && o.baseAsset == base && o.side == side && o != replaced {
+			quantity = quantity.Add(o.remaining())
+		}
+	})
+	return quantity
+}
+
+func (e *riskEngine) eachOrder(fn func(o *riskOrder)) {
+	for o := range e.pending {
+		fn(o)
+	}
+	for _, o := range e.uncertain {
+		fn(o)
+	}
+	for _, o := range e.open {
+		fn(o)
+	}
+}
+
+// settle закрепляет резерв за размещенным ордером или снимает его, если ордер не размещен,
+// и перестает учитывать отмененные и замененные ордера. После временной ошибки результат отправки неизвестен,
+// и резерв сохраняется за ордером до выяснения по ClientOrderID
+func (e *riskEngine) settle(reservation *riskOrder, order model.Order) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	succeeded := order.OrderApiStatus == model.OrderApiStatusSuccess
+	placed := succeeded && order.Action == PlaceOrder
+	switch order.Action {
+	case EditOrder:
+		if order.CancelResult == CancelReplaceSuccess {
+			delete(e.open, order.ReplacedBinanceID)
+		}
+		placed = order.NewOrderResult == CancelReplaceSuccess
+	case CancelOrder:
+		if succeeded {
+			delete(e.open, order.BinanceID)
+		}
+	}
+
+	if reservation == nil {
+		return
+	}
+	delete(e.pending, reservation)
+	if order.Retryable && reservation.clientOrderID != "" && order.NewOrderResult != CancelReplaceFailure {
+		reservation.uncertainSince = e.now()
+		e.uncertain[reservation.clientOrderID] = reservation
+		return
+	}
+	if !placed || order.BinanceID == 0 {
+		// Ордер не попал на биржу: резерв возвращается. Балансы размещенных ордеров обновляет биржа
+		e.release(reservation)
+		return
+	}
+
+	reservation.binanceID = order.BinanceID
+	final := model.IsFinalStatus(order.Status)
+	// Исполнение из ответа биржи учитывается при любом статусе: IOC и FOK ордер может частично исполниться
+	// и истечь, а количество рыночного ордера на сумму до исполнения неизвестно
+	if order.ExecutedQty.GreaterThan(reservation.filled) {
+		e.fill(reservation, order.ExecutedQty, order.CumulativeQuoteQty)
+	}
+	if unmatched, ok := e.unmatched[order.BinanceID]; ok {
+		delete(e.unmatched, order.BinanceID)
+		e.apply(reservation, unmatched.report)
+		final = final || model.IsFinalStatus(unmatched.report.Status)
+	}
+	if !final {
+		e.open[order.BinanceID] = reservation
+	}
+}
+
+// handleExecutionReport учитывает исполнение ордера стратегии и цену сделки
+func (e *riskEngine) handleExecutionReport(report model.ExecutionReport) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if report.ExecutionType == executionTypeTrade && report.LastFilledPrice.IsPositive() {
+		e.lastPrices[report.Symbol] = report.LastFilledPrice
+	}
+	o, ok := e.open[report.BinanceID]
+	if !ok && report.ClientOrderID != "" {
+		// Ордер, отправка которого завершилась временной ошибкой, все же размещен
+		if o, ok = e.uncertain[report.ClientOrderID]; ok && o.symbol == report.Symbol {
+			delete(e.uncertain, report.ClientOrderID)
+			o.binanceID = report.BinanceID
+			e.open[report.BinanceID] = o
+		} else {
+			ok = false
+		}
+	}
+	if !ok {
+		// Ответ о размещении может прийти позже отчета: отчет сопоставится с ордером в settle
+		now := e.now()
+		for binanceID, unmatched := range e.unmatched {
+			if now.Sub(unmatched.receivedAt) > riskUnmatchedTTL {
+				delete(e.unmatched, binanceID)
+			}
+		}
+		e.unmatched[report.BinanceID] = unmatchedReport{report: report, receivedAt: now}
+		return
+	}
+	e.apply(o, report)
+	if model.IsFinalStatus(report.Status) {
+		delete(e.open, report.BinanceID)
+	}
+}
+
+// uncertainOrders ордера, результат отправки которых неизвестен дольше riskUncertainTTL
+func (e *riskEngine) uncertainOrders() []*riskOrder {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.now()
+	var orders []*riskOrder
+	for _, o := range e.uncertain {
+		if now.Sub(o.uncertainSince) >= riskUncertainTTL {
+			orders = append(orders, o)
+		}
+	}
+	return orders
+}
+
+// resolve учитывает найденный на бирже ордер o с неизвестным результатом отправки или снимает его резерв,
+// если exchangeOrder равен nil. Ордер, который уже выяснен другим путем, не изменяется
+func (e *riskEngine) resolve(o *riskOrder, exchangeOrder *binance.Order) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.uncertain[o.clientOrderID] != o {
+		return
+	}
+	delete(e.uncertain, o.clientOrderID)
+	if exchangeOrder == nil {
+		e.release(o)
+		return
+	}
+
+	o.binanceID = exchangeOrder.OrderID
+	filled := parseDecimal(exchangeOrder.ExecutedQuantity)
+	if filled.GreaterThan(o.filled) {
+		e.fill(o, filled, parseDecimal(exchangeOrder.CummulativeQuoteQuantity))
+	}
+	if !model.IsFinalStatus(exchangeStatus(exchangeOrder.Status)) {
+		e.open[o.binanceID] = o
+	}
+}
+
+// handleAccountPosition обновляет балансы активов из события потока пользовательских данных
+func (e *riskEngine) handleAccountPosition(position model.AccountPosition) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	assets := make(map[string]bool, len(position.Balances))
+	for _, balance := range position.Balances {
+		e.balances[balance.Asset] = riskBalance{free: balance.Free, locked: balance.Locked}
+		assets[balance.Asset] = true
+	}
+	e.lockUnsettled(assets)
+}
+
+// apply учитывает исполнение из отчета, которое еще не учтено в позиции ордера
+func (e *riskEngine) apply(o *riskOrder, report model.ExecutionReport) {
+	if report.CumulativeFilledQty.GreaterThan(o.filled) {
+		e.fill(o, report.CumulativeFilledQty, report.CumulativeQuoteQty)
+	}
+}
+
+// fill доводит исполнение ордера до filled на сумму filledQuote и обновляет позицию стратегии.
+// Оборот рыночного ордера, сумма которого была неизвестна при резерве, учитывается по сделкам
+func (e *riskEngine) fill(o *riskOrder, filled, filledQuote decimal.Decimal) {
+	delta := filled.Sub(o.filled)
+	if o.side != string(binance.SideTypeBuy) {
+		delta = delta.Neg()
+	}
+	e.movePosition(o.strategyID, o.baseAsset, delta)
+
+	if o.notional.IsZero() && filledQuote.GreaterThan(o.filledQuote) {
+		e.rollDay()
+		e.addTurnover(o.strategyID, o.quoteAsset, filledQuote.Sub(o.filledQuote))
+	}
+	o.filled = filled
+	if filledQuote.GreaterThan(o.filledQuote) {
+		o.filledQuote = filledQuote
+	}
+}
+
+// movePosition изменяет позицию стратегии по активу на delta
+func (e *riskEngine) movePosition(strategyID int64, asset string, delta decimal.Decimal) {
+	positions, ok := e.positions[strategyID]
+	if !ok {
+		positions = make(map[string]decimal.Decimal)
+		e.positions[strategyID] = positions
+	}
+	positions[asset] = positions[asset].Add(delta)
+}
+
+// release снимает резерв ордера, который не попал на биржу
+func (e *riskEngine) release(o *riskOrder) {
+	e.lock(o.reservedAsset, o.reserved.Neg())
+	if o.day.Equal(e.turnoverDay) {
+		e.addTurnover(o.strategyID, o.quoteAsset, o.notional.Neg())
+	}
+}
+
+// hold возвращает снятый release резерв ордера
+func (e *riskEngine) hold(o *riskOrder) {
+	e.lock(o.reservedAsset, o.reserved)
+	if o.day.Equal(e.turnoverDay) {
+		e.addTurnover(o.strategyID, o.quoteAsset, o.notional)
+	}
+}
+
+// lock переводит amount актива из свободного баланса в заблокированный. Отрицательная сумма возвращает резерв
+func (e *riskEngine) lock(asset string, amount decimal.Decimal) {
+	if amount.IsZero() {
+		return
+	}
+	balance := e.balances[asset]
+	balance.free = balance.free.Sub(amount)
+	balance.locked = balance.locked.Add(amount)
+	e.balances[asset] = balance
+}
+
+func (e *riskEngine) addTurnover(strategyID int64, asset string, amount decimal.Decimal) {
+	if amount.IsZero() {
+		return
+	}
+	turnover, ok := e.turnover[strategyID]
+	if !ok {
+		turnover = make(map[string]decimal.Decimal)
+		e.turnover[strategyID] = turnover
+	}
+	turnover[asset] = turnover[asset].Add(amount)
+}
+
+// rollDay начинает учет оборота заново с наступлением новых суток UTC
+func (e *riskEngine) rollDay() {
+	day := e.now().UTC().Truncate(24 * time.Hour)
+	if !day.Equal(e.turnoverDay) {
+		e.turnoverDay = day
+		e.turnover = make(map[int64]map[string]decimal.Decimal)
+	}
+}
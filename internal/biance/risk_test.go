@@ -0,0 +1,383 @@
+package biance
+
+import (
+	"app/internal/model"
+	"app/internal/store"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+var riskTestNow = time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+// newTestRisk создает проверку рисков для символа BTCUSDT с балансом 1000 USDT и 10 BTC
+func newTestRisk(limits ...RiskLimits) *riskEngine {
+	e := newRiskEngine(limits, func(symbol string) (string, string, bool) {
+		if symbol != "BTCUSDT" {
+			return "", "", false
+		}
+		return "BTC", "USDT", true
+	})
+	e.now = func() time.Time { return riskTestNow }
+	e.setBalances([]binance.Balance{{Asset: "USDT", Free: "1000", Locked: "0"}, {Asset: "BTC", Free: "10", Locked: "0"}})
+	return e
+}
+
+func riskOrderCommand(strategyID int64, clientOrderID, side, quantity, price string) model.Order {
+	order := model.Order{
+		Action:        PlaceOrder,
+		StrategyID:    strategyID,
+		ClientOrderID: clientOrderID,
+		Symbol:        "BTCUSDT",
+		Side:          side,
+		Type:          string(binance.OrderTypeLimit),
+		Quantity:      decimal.RequireFromString(quantity),
+	}
+	if price != "" {
+		order.Price = decimal.RequireFromString(price)
+	}
+	return order
+}
+
+// placed дополняет команду ответом биржи о размещении
+func placed(order model.Order, binanceID int64, status, executed, quote string) model.Order {
+	order.OrderApiStatus = model.OrderApiStatusSuccess
+	order.BinanceID = binanceID
+	order.Status = status
+	order.ExecutedQty = decimal.RequireFromString(executed)
+	order.CumulativeQuoteQty = decimal.RequireFromString(quote)
+	return order
+}
+
+func rejected(order model.Order) model.Order {
+	order.OrderApiStatus = model.OrderApiStatusError
+	order.Status = model.StatusRejected
+	return order
+}
+
+// uncertain дополняет команду временной ошибкой отправки
+func uncertain(order model.Order) model.Order {
+	order.OrderApiStatus = model.OrderApiStatusError
+	order.Status = model.StatusFailed
+	order.Retryable = true
+	return order
+}
+
+func mustReserve(t *testing.T, e *riskEngine, order model.Order) *riskOrder {
+	t.Helper()
+	reservation, err := e.reserve(order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reservation
+}
+
+func assertRiskBalance(t *testing.T, e *riskEngine, asset, wantFree, wantLocked string) {
+	t.Helper()
+	balance := e.balances[asset]
+	if !balance.free.Equal(decimal.RequireFromString(wantFree)) || !balance.locked.Equal(decimal.RequireFromString(wantLocked)) {
+		t.Fatalf("баланс %s: свободно %s, заблокировано %s, ожидалось %s и %s", asset, balance.free, balance.locked, wantFree, wantLocked)
+	}
+}
+
+func assertDecimal(t *testing.T, name string, got decimal.Decimal, want string) {
+	t.Helper()
+	if !got.Equal(decimal.RequireFromString(want)) {
+		t.Fatalf("%s %s, ожидалось %s", name, got, want)
+	}
+}
+
+func TestRiskCheckLimits(t *testing.T) {
+	e := newTestRisk(
+		RiskLimits{
+			StrategyID:       1,
+			MaxOrderNotional: map[string]decimal.Decimal{"USDT": decimal.NewFromInt(500)},
+			MaxPosition:      map[string]decimal.Decimal{"BTC": decimal.NewFromInt(3)},
+			MaxDailyTurnover: map[string]decimal.Decimal{"USDT": decimal.NewFromInt(800)},
+		},
+		RiskLimits{StrategyID: 2, MaxOpenOrders: 1},
+	)
+	// Позиция стратегии 1 — 1 BTC, открыта покупка еще 1 BTC. Оборот за сутки 500 USDT
+	e.movePosition(1, "BTC", decimal.NewFromInt(1))
+	first := riskOrderCommand(1, "open-1", "BUY", "1", "100")
+	e.settle(mustReserve(t, e, first), placed(first, 1, model.StatusNew, "0", "0"))
+	e.addTurnover(1, "USDT", decimal.NewFromInt(400))
+	second := riskOrderCommand(2, "open-2", "BUY", "0.1", "100")
+	e.settle(mustReserve(t, e, second), placed(second, 2, model.StatusNew, "0", "0"))
+
+	market := riskOrderCommand(1, "market-1", "BUY", "1", "")
+	market.Type = string(binance.OrderTypeMarket)
+
+	tests := []struct {
+		name  string
+		order model.Order
+		err   string
+	}{
+		{"в пределах лимитов", riskOrderCommand(1, "c-1", "BUY", "0.5", "100"), ""},
+		{"сумма ордера больше лимита", riskOrderCommand(1, "c-2", "SELL", "6", "100"), "сумма ордера 600 USDT больше лимита"},
+		{"сумма рыночного ордера неизвестна", market, "неизвестна до первой сделки"},
+		{"позиция с открытыми ордерами больше лимита", riskOrderCommand(1, "c-3", "BUY", "1.5", "100"), "позиция BTC с открытыми ордерами станет 3.5"},
+		{"оборот за сутки больше лимита", riskOrderCommand(1, "c-4", "SELL", "4", "100"), "оборот за сутки 500 USDT"},
+		{"открытых ордеров больше лимита", riskOrderCommand(2, "c-5", "BUY", "0.1", "100"), "открыто 1 ордеров из 1"},
+		{"недостаточно баланса", riskOrderCommand(3, "c-6", "BUY", "20", "100"), "недостаточно USDT: нужно 2000, свободно 890"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reservation, err := e.reserve(tt.order)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				e.settle(reservation, rejected(tt.order))
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("ошибка %v, ожидалась %q", err, tt.err)
+			}
+		})
+	}
+	assertRiskBalance(t, e, "USDT", "890", "110")
+	assertDecimal(t, "оборот", e.turnover[1]["USDT"], "500")
+}
+
+func TestRiskReserveAndRelease(t *testing.T) {
+	e := newTestRisk(RiskLimits{StrategyID: 1})
+	buy := riskOrderCommand(1, "buy-1", "BUY", "2", "100")
+
+	reservation := mustReserve(t, e, buy)
+	assertRiskBalance(t, e, "USDT", "800", "200")
+	assertDecimal(t, "оборот", e.turnover[1]["USDT"], "200")
+
+	// Отклоненный биржей ордер возвращает резерв и оборот
+	e.settle(reservation, rejected(buy))
+	assertRiskBalance(t, e, "USDT", "1000", "0")
+	assertDecimal(t, "оборот", e.turnover[1]["USDT"], "0")
+	if len(e.pending) != 0 {
+		t.Fatalf("после ответа остались отправленные ордера: %d", len(e.pending))
+	}
+
+	// Размещенный ордер остается в резерве до закрытия
+	e.settle(mustReserve(t, e, buy), placed(buy, 1, model.StatusNew, "0", "0"))
+	sell := riskOrderCommand(1, "sell-1", "SELL", "1", "200")
+	e.settle(mustReserve(t, e, sell), placed(sell, 2, model.StatusNew, "0", "0"))
+	assertRiskBalance(t, e, "USDT", "800", "200")
+	assertRiskBalance(t, e, "BTC", "9", "1")
+	if e.open[1] == nil || e.open[2] == nil {
+		t.Fatalf("открытые ордера не учтены: %v", e.open)
+	}
+
+	cancel := model.Order{Action: CancelOrder, StrategyID: 1, Symbol: "BTCUSDT", BinanceID: 1}
+	e.settle(nil, placed(cancel, 1, model.StatusCanceled, "0", "0"))
+	if e.open[1] != nil || e.open[2] == nil {
+		t.Fatalf("после отмены открыты %v, ожидался только ордер 2", e.open)
+	}
+}
+
+func TestRiskUncertainOrder(t *testing.T) {
+	e := newTestRisk(RiskLimits{StrategyID: 1, MaxOpenOrders: 1})
+	order := riskOrderCommand(1, "uncertain-1", "BUY", "2", "100")
+
+	// После временной ошибки ордер мог попасть на биржу: резерв сохраняется
+	e.settle(mustReserve(t, e, order), uncertain(order))
+	if e.uncertain["uncertain-1"] == nil {
+		t.Fatal("ордер с неизвестным результатом не сохранен")
+	}
+	assertRiskBalance(t, e, "USDT", "800", "200")
+	if _, err := e.reserve(riskOrderCommand(1, "other-1", "BUY", "0.1", "100")); err == nil {
+		t.Fatal("ордер с неизвестным результатом не учтен среди открытых")
+	}
+
+	// Снимок балансов с биржи не снимает резерв
+	e.setBalances([]binance.Balance{{Asset: "USDT", Free: "1000", Locked: "0"}})
+	assertRiskBalance(t, e, "USDT", "800", "200")
+
+	// Повтор, не прошедший проверку, оставляет резерв предыдущей попытки
+	retry := riskOrderCommand(1, "uncertain-1", "BUY", "20", "100")
+	if _, err := e.reserve(retry); err == nil {
+		t.Fatal("повтор на 2000 USDT прошел проверку баланса")
+	}
+	assertRiskBalance(t, e, "USDT", "800", "200")
+
+	// Отчет потока по ClientOrderID показывает, что ордер размещен
+	e.handleExecutionReport(model.ExecutionReport{
+		Symbol: "BTCUSDT", ClientOrderID: "uncertain-1", BinanceID: 5, ExecutionType: "NEW", Status: model.StatusNew,
+	})
+	if e.uncertain["uncertain-1"] != nil || e.open[5] == nil {
+		t.Fatal("размещенный ордер не перенесен в открытые")
+	}
+
+	// Ордер, не найденный на бирже после riskUncertainTTL, освобождает резерв
+	lost := riskOrderCommand(2, "lost-1", "BUY", "1", "100")
+	e.settle(mustReserve(t, e, lost), uncertain(lost))
+	if orders := e.uncertainOrders(); len(orders) != 0 {
+		t.Fatalf("ордер запрошен раньше riskUncertainTTL: %d", len(orders))
+	}
+	e.now = func() time.Time { return riskTestNow.Add(riskUncertainTTL) }
+	orders := e.uncertainOrders()
+	if len(orders) != 1 || orders[0].clientOrderID != "lost-1" {
+		t.Fatalf("ордера для запроса: %v", orders)
+	}
+	e.resolve(orders[0], nil)
+	assertRiskBalance(t, e, "USDT", "800", "200")
+}
+
+func TestRiskUnmatchedReport(t *testing.T) {
+	e := newTestRisk(RiskLimits{StrategyID: 1})
+	order := riskOrderCommand(1, "early-1", "BUY", "1", "100")
+	reservation := mustReserve(t, e, order)
+
+	// Отчет пришел раньше ответа на запрос размещения
+	e.handleExecutionReport(model.ExecutionReport{
+		Symbol: "BTCUSDT", BinanceID: 7, ExecutionType: executionTypeTrade, Status: model.StatusPartiallyFilled,
+		LastFilledPrice: decimal.NewFromInt(100), CumulativeFilledQty: decimal.RequireFromString("0.4"),
+		CumulativeQuoteQty: decimal.NewFromInt(40), TransactionTime: riskTestNow,
+	})
+	if _, ok := e.unmatched[7]; !ok {
+		t.Fatal("отчет без ордера не сохранен")
+	}
+	e.settle(reservation, placed(order, 7, model.StatusNew, "0", "0"))
+	assertDecimal(t, "позиция", e.positions[1]["BTC"], "0.4")
+	if _, ok := e.unmatched[7]; ok || e.open[7] == nil {
+		t.Fatal("отчет не сопоставлен с размещенным ордером")
+	}
+
+	// Несопоставленные отчеты хранятся не дольше riskUnmatchedTTL
+	e.handleExecutionReport(model.ExecutionReport{Symbol: "BTCUSDT", BinanceID: 8, Status: model.StatusNew})
+	e.now = func() time.Time { return riskTestNow.Add(riskUnmatchedTTL + time.Second) }
+	e.handleExecutionReport(model.ExecutionReport{Symbol: "BTCUSDT", BinanceID: 9, Status: model.StatusNew})
+	if _, ok := e.unmatched[8]; ok {
+		t.Fatal("устаревший отчет не удален")
+	}
+	if _, ok := e.unmatched[9]; !ok {
+		t.Fatal("новый отчет не сохранен")
+	}
+}
+
+func TestRiskSettlesExecutionFromResponse(t *testing.T) {
+	ioc := riskOrderCommand(1, "ioc-1", "BUY", "1", "100")
+	ioc.TimeInForce = string(binance.TimeInForceTypeIOC)
+	quoteMarket := riskOrderCommand(1, "quote-1", "BUY", "0", "")
+	quoteMarket.Type = string(binance.OrderTypeMarket)
+	quoteMarket.QuoteOrderQty = decimal.NewFromInt(50)
+	market := riskOrderCommand(1, "market-1", "BUY", "1", "")
+	market.Type = string(binance.OrderTypeMarket)
+
+	tests := []struct {
+		name     string
+		order    model.Order
+		status   string
+		executed string
+		quote    string
+		turnover string
+	}{
+		{"IOC частично исполнен и истек", ioc, model.StatusExpired, "0.4", "40", "100"},
+		{"рыночный ордер на сумму", quoteMarket, model.StatusFilled, "0.5", "50", "50"},
+		{"рыночный ордер на количество без цены", market, model.StatusFilled, "1", "101", "101"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestRisk()
+			e.settle(mustReserve(t, e, tt.order), placed(tt.order, 1, tt.status, tt.executed, tt.quote))
+			assertDecimal(t, "позиция", e.positions[1]["BTC"], tt.executed)
+			assertDecimal(t, "оборот", e.turnover[1]["USDT"], tt.turnover)
+			if len(e.open) != 0 {
+				t.Fatalf("закрытый ордер остался открытым: %v", e.open)
+			}
+
+			// Отчет о той же сделке из потока не учитывается второй раз
+			e.handleExecutionReport(model.ExecutionReport{
+				Symbol: "BTCUSDT", BinanceID: 1, ExecutionType: executionTypeTrade, Status: tt.status,
+				CumulativeFilledQty: decimal.RequireFromString(tt.executed), CumulativeQuoteQty: decimal.RequireFromString(tt.quote),
+			})
+			assertDecimal(t, "позиция", e.positions[1]["BTC"], tt.executed)
+			assertDecimal(t, "оборот", e.turnover[1]["USDT"], tt.turnover)
+		})
+	}
+}
+
+func TestRiskBalanceSnapshotKeepsPendingReservations(t *testing.T) {
+	e := newTestRisk()
+	order := riskOrderCommand(1, "pending-1", "BUY", "3", "100")
+	reservation := mustReserve(t, e, order)
+
+	// Ответ на отправку еще не получен: снимки балансов не освобождают резерв
+	e.handleAccountPosition(model.AccountPosition{Balances: []model.Balance{
+		{Asset: "USDT", Free: decimal.NewFromInt(1000), Locked: decimal.Zero},
+	}})
+	assertRiskBalance(t, e, "USDT", "700", "300")
+	e.setBalances([]binance.Balance{{Asset: "USDT", Free: "1000", Locked: "0"}})
+	assertRiskBalance(t, e, "USDT", "700", "300")
+	if _, err := e.reserve(riskOrderCommand(1, "pending-2", "BUY", "8", "100")); err == nil {
+		t.Fatal("второй ордер прошел проверку баланса, уже зарезервированного первым")
+	}
+
+	// Размещенный ордер учитывает биржа
+	e.settle(reservation, placed(order, 1, model.StatusNew, "0", "0"))
+	e.setBalances([]binance.Balance{{Asset: "USDT", Free: "700", Locked: "300"}})
+	assertRiskBalance(t, e, "USDT", "700", "300")
+}
+
+func TestRiskRestoresDailyTurnover(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orders.db")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderStore, err := store.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { orderStore.Close() })
+
+	order := riskOrderCommand(1, "filled-1", "BUY", "3", "100")
+	order.StatusUpdatedAt = riskTestNow
+	if _, err := orderStore.SaveCommand(order); err != nil {
+		t.Fatal(err)
+	}
+	if err := orderStore.SaveResult(placed(order, 42, model.StatusNew, "0", "0"), false); err != nil {
+		t.Fatal(err)
+	}
+	// Сделка прошлых суток входит только в позицию
+	for i, at := range []time.Time{riskTestNow.Add(-24 * time.Hour), riskTestNow.Add(-time.Hour)} {
+		report := model.ExecutionReport{
+			Symbol: "BTCUSDT", ClientOrderID: "filled-1", BinanceID: 42, Side: "BUY", ExecutionType: executionTypeTrade,
+			Status: model.StatusPartiallyFilled, TradeID: int64(i + 1), LastFilledQty: decimal.NewFromInt(int64(i + 1)),
+			LastFilledPrice: decimal.NewFromInt(100), CumulativeFilledQty: decimal.NewFromInt(int64(i + 1)),
+			TransactionTime: at,
+		}
+		if err := orderStore.SaveExecutionReport(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := newTestRisk(RiskLimits{StrategyID: 1, MaxDailyTurnover: map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)}})
+	bm := &BianceManager{}
+	bm.EnableStore(orderStore)
+	if err := bm.restoreRiskFills(e); err != nil {
+		t.Fatal(err)
+	}
+	assertDecimal(t, "позиция", e.positions[1]["BTC"], "3")
+	assertDecimal(t, "оборот", e.turnover[1]["USDT"], "200")
+
+	// Неисполненная часть открытого ордера, размещенного сегодня, тоже входит в оборот
+	e.restore(1, &binance.Order{
+		Symbol: "BTCUSDT", OrderID: 43, Side: binance.SideTypeSell, Price: "100", OrigQuantity: "2",
+		ExecutedQuantity: "0.5", Time: riskTestNow.Add(-time.Minute).UnixMilli(),
+	})
+	e.restore(1, &binance.Order{
+		Symbol: "BTCUSDT", OrderID: 44, Side: binance.SideTypeSell, Price: "100", OrigQuantity: "1",
+		ExecutedQuantity: "0", Time: riskTestNow.Add(-24 * time.Hour).UnixMilli(),
+	})
+	assertDecimal(t, "оборот", e.turnover[1]["USDT"], "350")
+	if _, err := e.reserve(riskOrderCommand(1, "next-1", "SELL", "7", "100")); err == nil ||
+		!strings.Contains(err.Error(), "оборот за сутки 350 USDT") {
+		t.Fatalf("ошибка %v, ожидалось превышение восстановленного оборота", err)
+	}
+}
@@ -0,0 +1,60 @@
+package biance
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/adshao/go-binance/v2/common"
+)
+
+// signedRequest выполняет подписанный запрос к эндпоинту, которого нет в go-binance. Подпись и время запроса
+// формируются так же, как в клиенте библиотеки, с учетом смещения часов и recvWindow. При ответе 4xx возвращает тело
+// ответа вместе с *common.APIError, потому что некоторые эндпоинты кладут в тело ошибки подробности.
+func (e *binanceExchange) signedRequest(ctx context.Context, method, endpoint string, params url.Values) ([]byte, error) {
+	if params == nil {
+		params = url.Values{}
+	}
+	if e.recvWindow > 0 {
+		params.Set("recvWindow", strconv.FormatInt(e.recvWindow.Milliseconds(), 10))
+	}
+	params.Set("timestamp", strconv.FormatInt(time.Now().UnixMilli()-e.timeOffset.Load(), 10))
+
+	query := params.Encode()
+	mac := hmac.New(sha256.New, []byte(e.client.SecretKey))
+	mac.Write([]byte(query))
+	query += "&signature=" + hex.EncodeToString(mac.Sum(nil))
+
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s%s?%s", e.client.BaseURL, endpoint, query), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-MBX-APIKEY", e.client.APIKey)
+
+	resp, err := e.client.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := new(common.APIError)
+		if json.Unmarshal(data, apiErr) != nil || !apiErr.IsValid() {
+			apiErr.Response = data
+		}
+		return data, apiErr
+	}
+	return data, nil
+}
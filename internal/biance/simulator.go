@@ -0,0 +1,746 @@
+package biance
+
+import (
+	"app/internal/model"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/shopspring/decimal"
+)
+
+// Коды ошибок Binance, которые возвращает симулятор вместе с codeNewOrderRejected
+const (
+	codeFilterFailure    = -1013
+	codeInvalidOrderType = -1116
+	codeBadSymbol        = -1121
+	codeCancelRejected   = -2011
+	codeNoSuchOrder      = -2013
+)
+
+// simulatorOrderTypes типы ордеров, которые исполняет симулятор
+var simulatorOrderTypes = map[string]bool{
+	string(binance.OrderTypeLimit):      true,
+	string(binance.OrderTypeMarket):     true,
+	string(binance.OrderTypeLimitMaker): true,
+}
+
+// SimulatorConfig настройки симулятора биржи
+type SimulatorConfig struct {
+	// Symbols символы в формате exchangeInfo. Ордера проверяются по их фильтрам так же, как перед отправкой на биржу
+	Symbols []binance.Symbol
+	// Balances начальные свободные балансы по активам
+	Balances map[string]decimal.Decimal
+	// MakerFee и TakerFee комиссия в долях от сделки, например 0.001. Удерживается из полученного актива
+	MakerFee decimal.Decimal
+	TakerFee decimal.Decimal
+	// Now часы симулятора, по умолчанию time.Now. Для воспроизводимых тестов можно задать свои
+	Now func() time.Time
+}
+
+// Simulator биржа в памяти. Держит книгу ордеров по каждому символу и исполняет ордера по ценам, которые
+// передаются в SetPrice или RunFeed: рыночные ордера и лимитные, пересекающие последнюю цену, исполняются
+// сразу по ней как taker, остальные ждут в книге и исполняются по своей цене как maker, когда цена до них дойдет.
+// Ордер исполняется целиком. Балансы блокируются под открытые ордера, с каждой сделки удерживается комиссия.
+// При одинаковых командах и ценах результат всегда один и тот же.
+type Simulator struct {
+	makerFee decimal.Decimal
+	takerFee decimal.Decimal
+	now      func() time.Time
+
+	mu       sync.Mutex
+	symbols  []binance.Symbol
+	books    map[string]*simBook
+	balances map[string]*simBalance
+	orders   map[int64]*simOrder
+	// strategies результаты сделок по стратегиям и символам
+	strategies  map[int64]map[string]*strategyLedger
+	lastOrderID int64
+	lastTradeID int64
+	// events очередь событий для подписчика. Копится, только пока подписчик есть
+	events     []simEvent
+	subscribed bool
+	eventsCond *sync.Cond
+}
+
+// simBook книга ордеров символа
+type simBook struct {
+	symbol     string
+	rules      symbolRules
+	baseAsset  string
+	quoteAsset string
+	lastPrice  decimal.Decimal
+	// bids по убыванию цены, asks по возрастанию, при равной цене раньше размещенный ордер первый
+	bids []*simOrder
+	asks []*simOrder
+}
+
+type simOrder struct {
+	id            int64
+	strategyID    int64
+	clientOrderID string
+	symbol        string
+	side          string
+	orderType     string
+	timeInForce   string
+	price         decimal.Decimal
+	quantity      decimal.Decimal
+	executedQty   decimal.Decimal
+	quoteQty      decimal.Decimal
+	status        binance.OrderStatusType
+	// locked сумма, заблокированная под ордер: валюта котировки для покупки, базовый актив для продажи
+	locked    decimal.Decimal
+	createdAt time.Time
+	updatedAt time.Time
+}
+
+type simBalance struct {
+	free   decimal.Decimal
+	locked decimal.Decimal
+}
+
+// simTrade сделка по ордеру
+type simTrade struct {
+	id              int64
+	quantity        decimal.Decimal
+	price           decimal.Decimal
+	isMaker         bool
+	commission      decimal.Decimal
+	commissionAsset string
+}
+
+// simEvent событие для подписчика: отчет об исполнении или изменение балансов
+type simEvent struct {
+	report   *model.ExecutionReport
+	position *model.AccountPosition
+}
+
+// NewSimulator создает симулятор с символами и балансами из config
+func NewSimulator(config SimulatorConfig) *Simulator {
+	s := Simulator{
+		makerFee:   config.MakerFee,
+		takerFee:   config.TakerFee,
+		now:        config.Now,
+		symbols:    config.Symbols,
+		books:      make(map[string]*simBook, len(config.Symbols)),
+		balances:   make(map[string]*simBalance, len(config.Balances)),
+		orders:     make(map[int64]*simOrder),
+		strategies: make(map[int64]map[string]*strategyLedger),
+	}
+	if s.now == nil {
+		s.now = time.Now
+	}
+	s.eventsCond = sync.NewCond(&s.mu)
+	for i := range config.Symbols {
+		symbol := &config.Symbols[i]
+		s.books[symbol.Symbol] = &simBook{
+			symbol:     symbol.Symbol,
+			rules:      parseSymbolRules(symbol),
+			baseAsset:  symbol.BaseAsset,
+			quoteAsset: symbol.QuoteAsset,
+		}
+	}
+	for asset, amount := range config.Balances {
+		s.balances[asset] = &simBalance{free: amount}
+	}
+	return &s
+}
+
+// NewSimulatorSymbol описание символа для симулятора с фильтрами PRICE_FILTER, LOT_SIZE и NOTIONAL.
+// tickSize и stepSize также задают минимальные цену и количество
+func NewSimulatorSymbol(symbol, baseAsset, quoteAsset, tickSize, stepSize, minNotional string) binance.Symbol {
+	return binance.Symbol{
+		Symbol:                     symbol,
+		Status:                     symbolStatusTrading,
+		BaseAsset:                  baseAsset,
+		BaseAssetPrecision:         8,
+		QuoteAsset:                 quoteAsset,
+		QuotePrecision:             8,
+		QuoteAssetPrecision:        8,
+		OrderTypes:                 []string{string(binance.OrderTypeLimit), string(binance.OrderTypeMarket), string(binance.OrderTypeLimitMaker)},
+		QuoteOrderQtyMarketAllowed: true,
+		IsSpotTradingAllowed:       true,
+		Filters: []map[string]interface{}{
+			{"filterType": string(binance.SymbolFilterTypePriceFilter), "minPrice": tickSize, "maxPrice": "0", "tickSize": tickSize},
+			{"filterType": string(binance.SymbolFilterTypeLotSize), "minQty": stepSize, "maxQty": "0", "stepSize": stepSize},
+			{"filterType": string(binance.SymbolFilterTypeNotional), "minNotional": minNotional, "applyMinToMarket": true,
+				"maxNotional": "0", "applyMaxToMarket": false},
+		},
+		Permissions: []string{"SPOT"},
+	}
+}
+
+// SetPrice задает последнюю цену символа и исполняет ордера из книги, до цены которых она дошла
+func (s *Simulator) SetPrice(symbol string, price decimal.Decimal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.books[symbol]
+	if !ok {
+		return fmt.Errorf("симулятор: неизвестный символ %s", symbol)
+	}
+	book.lastPrice = price
+
+	for len(book.bids) > 0 && book.bids[0].price.GreaterThanOrEqual(price) {
+		order := book.bids[0]
+		book.bids = book.bids[1:]
+		s.fill(book, order, order.price, true)
+	}
+	for len(book.asks) > 0 && book.asks[0].price.LessThanOrEqual(price) {
+		order := book.asks[0]
+		book.asks = book.asks[1:]
+		s.fill(book, order, order.price, true)
+	}
+	return nil
+}
+
+func (s *Simulator) PlaceOrder(ctx context.Context, order model.Order) (*binance.CreateOrderResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	placed, fills, err := s.place(order)
+	if err != nil {
+		return nil, err
+	}
+	return placed.createResponse(fills), nil
+}
+
+func (s *Simulator) CancelOrder(ctx context.Context, order model.Order) (*binance.CancelOrderResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	canceled, err := s.cancel(order.Symbol, order.BinanceID)
+	if err != nil {
+		return nil, err
+	}
+	return canceled.cancelResponse(), nil
+}
+
+// EditOrder отменяет старый ордер и размещает новый. При CancelReplaceStopOnFailure новый ордер
+// не размещается, если отмена не удалась
+func (s *Simulator) EditOrder(ctx context.Context, order model.Order) (EditResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result EditResult
+	if _, err := s.cancel(order.Symbol, order.BinanceID); err != nil {
+		result.CancelResult = CancelReplaceFailure
+		result.CancelErr = err
+		if cancelReplaceMode(order) == CancelReplaceStopOnFailure {
+			result.NewOrderResult = CancelReplaceNotAttempted
+			result.NewOrderErr = partError(CancelReplaceNotAttempted, nil)
+			return result, nil
+		}
+	} else {
+		result.CancelResult = CancelReplaceSuccess
+	}
+
+	placed, fills, err := s.place(order)
+	if err != nil {
+		result.NewOrderResult = CancelReplaceFailure
+		result.NewOrderErr = err
+		return result, nil
+	}
+	result.NewOrderResult = CancelReplaceSuccess
+	result.NewOrder = placed.createResponse(fills)
+	return result, nil
+}
+
+func (s *Simulator) QueryOrder(ctx context.Context, symbol string, binanceID int64, clientOrderID string) (*binance.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if binanceID != 0 {
+		if order, ok := s.orders[binanceID]; ok && order.symbol == symbol {
+			return order.binanceOrder(), nil
+		}
+		return nil, simulatorError(codeNoSuchOrder, "Order does not exist.")
+	}
+
+	// Идентификатор клиента может повторяться у закрытых ордеров, возвращается последний
+	var found *simOrder
+	for _, order := range s.orders {
+		if order.symbol == symbol && order.clientOrderID == clientOrderID && (found == nil || order.id > found.id) {
+			found = order
+		}
+	}
+	if found == nil {
+		return nil, simulatorError(codeNoSuchOrder, "Order does not exist.")
+	}
+	return found.binanceOrder(), nil
+}
+
+func (s *Simulator) OpenOrders(ctx context.Context, symbol string) ([]*binance.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var open []*simOrder
+	for _, order := range s.orders {
+		if order.isOpen() && (symbol == "" || order.symbol == symbol) {
+			open = append(open, order)
+		}
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].id < open[j].id })
+
+	orders := make([]*binance.Order, 0, len(open))
+	for _, order := range open {
+		orders = append(orders, order.binanceOrder())
+	}
+	return orders, nil
+}
+
+func (s *Simulator) Balances(ctx context.Context) ([]binance.Balance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	assets := make([]string, 0, len(s.balances))
+	for asset := range s.balances {
+		assets = append(assets, asset)
+	}
+	sort.Strings(assets)
+
+	balances := make([]binance.Balance, 0, len(assets))
+	for _, asset := range assets {
+		balance := s.balances[asset]
+		balances = append(balances, binance.Balance{
+			Asset:  asset,
+			Free:   balance.free.String(),
+			Locked: balance.locked.String(),
+		})
+	}
+	return balances, nil
+}
+
+func (s *Simulator) ExchangeInfo(ctx context.Context) (*binance.ExchangeInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &binance.ExchangeInfo{
+		Timezone:   "UTC",
+		ServerTime: s.now().UnixMilli(),
+		Symbols:    append([]binance.Symbol(nil), s.symbols...),
+	}, nil
+}
+
+// TestOrder проверяет символ, тип и фильтры ордера, не размещая его. Ставки комиссии берутся из настроек симулятора
+func (s *Simulator) TestOrder(ctx context.Context, order model.Order) (*model.CommissionRates, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.check(order); err != nil {
+		return nil, err
+	}
+	if !order.ComputeCommissionRates {
+		return nil, nil
+	}
+	return &model.CommissionRates{Maker: s.makerFee, Taker: s.takerFee}, nil
+}
+
+// check проверяет символ, тип ордера и фильтры символа
+func (s *Simulator) check(order model.Order) (*simBook, error) {
+	book, ok := s.books[order.Symbol]
+	if !ok {
+		return nil, simulatorError(codeBadSymbol, "Invalid symbol.")
+	}
+	if !simulatorOrderTypes[order.Type] {
+		return nil, simulatorError(codeInvalidOrderType, "Invalid orderType.")
+	}
+	if err := book.rules.validate(order); err != nil {
+		return nil, simulatorError(codeFilterFailure, "Filter failure: "+err.Error())
+	}
+	return book, nil
+}
+
+// place проверяет и размещает ордер. Возвращает сделки, если ордер исполнен сразу
+func (s *Simulator) place(order model.Order) (*simOrder, []*binance.Fill, error) {
+	book, err := s.check(order)
+	if err != nil {
+		return nil, nil, err
+	}
+	if book.rules.maxNumOrders > 0 && len(book.bids)+len(book.asks) >= book.rules.maxNumOrders {
+		return nil, nil, simulatorError(codeFilterFailure, "Filter failure: MAX_NUM_ORDERS")
+	}
+	if order.ClientOrderID != "" && s.hasOpenOrder(order.Symbol, order.ClientOrderID) {
+		return nil, nil, simulatorError(codeNewOrderRejected, "Duplicate order sent.")
+	}
+
+	now := s.now()
+	placed := &simOrder{
+		id:            s.lastOrderID + 1,
+		strategyID:    order.StrategyID,
+		clientOrderID: order.ClientOrderID,
+		symbol:        order.Symbol,
+		side:          order.Side,
+		orderType:     order.Type,
+		timeInForce:   order.TimeInForce,
+		price:         order.Price,
+		quantity:      order.Quantity,
+		status:        binance.OrderStatusTypeNew,
+		createdAt:     now,
+		updatedAt:     now,
+	}
+	if placed.clientOrderID == "" {
+		placed.clientOrderID = fmt.Sprintf("sim-%d", placed.id)
+	}
+
+	// Рыночный ордер и лимитный, пересекающий последнюю цену, исполняются сразу по ней
+	taker := false
+	switch order.Type {
+	case string(binance.OrderTypeMarket):
+		if !book.lastPrice.IsPositive() {
+			return nil, nil, simulatorError(codeNewOrderRejected, "Market is closed.")
+		}
+		if order.QuoteOrderQty.IsPositive() {
+			placed.quantity = book.marketQuantity(order.QuoteOrderQty)
+			if !placed.quantity.IsPositive() {
+				return nil, nil, simulatorError(codeFilterFailure, "Filter failure: LOT_SIZE")
+			}
+		}
+		taker = true
+	default:
+		taker = book.crosses(placed)
+		if taker && order.Type == string(binance.OrderTypeLimitMaker) {
+			return nil, nil, simulatorError(codeNewOrderRejected, "Order would immediately match and take.")
+		}
+	}
+
+	tif := binance.TimeInForceType(order.TimeInForce)
+	if !taker && (tif == binance.TimeInForceTypeIOC || tif == binance.TimeInForceTypeFOK) {
+		// Исполнить сразу нечего, ордер истекает
+		s.lastOrderID = placed.id
+		s.orders[placed.id] = placed
+		s.report(placed, "NEW", nil)
+		placed.status = binance.OrderStatusTypeExpired
+		s.report(placed, "EXPIRED", nil)
+		return placed, nil, nil
+	}
+
+	asset, amount := book.lockFor(placed)
+	balance := s.balance(asset)
+	if balance.free.LessThan(amount) {
+		return nil, nil, simulatorError(codeNewOrderRejected, "Account has insufficient balance for requested action.")
+	}
+	balance.free = balance.free.Sub(amount)
+	balance.locked = balance.locked.Add(amount)
+	placed.locked = amount
+
+	s.lastOrderID = placed.id
+	s.orders[placed.id] = placed
+	s.report(placed, "NEW", nil)
+	s.position(asset)
+
+	if !taker {
+		book.add(placed)
+		return placed, nil, nil
+	}
+	fill := s.fill(book, placed, book.lastPrice, false)
+	return placed, []*binance.Fill{fill}, nil
+}
+
+// cancel отменяет открытый ордер и снимает блокировку баланса
+func (s *Simulator) cancel(symbol string, binanceID int64) (*simOrder, error) {
+	order, ok := s.orders[binanceID]
+	if !ok || order.symbol != symbol || !order.isOpen() {
+		return nil, simulatorError(codeCancelRejected, "Unknown order sent.")
+	}
+	book := s.books[symbol]
+	book.remove(order)
+
+	asset := book.lockedAsset(order)
+	balance := s.balance(asset)
+	balance.free = balance.free.Add(order.locked)
+	balance.locked = balance.locked.Sub(order.locked)
+	order.locked = decimal.Zero
+	order.status = binance.OrderStatusTypeCanceled
+	order.updatedAt = s.now()
+
+	s.report(order, "CANCELED", nil)
+	s.position(asset)
+	return order, nil
+}
+
+// fill исполняет оставшееся количество ордера по цене price, переводит активы и удерживает комиссию
+func (s *Simulator) fill(book *simBook, order *simOrder, price decimal.Decimal, isMaker bool) *binance.Fill {
+	quantity := order.quantity.Sub(order.executedQty)
+	quote := price.Mul(quantity)
+	rate := s.takerFee
+	if isMaker {
+		rate = s.makerFee
+	}
+
+	base := s.balance(book.baseAsset)
+	quoteBalance := s.balance(book.quoteAsset)
+	trade := simTrade{quantity: quantity, price: price, isMaker: isMaker}
+	if order.side == string(binance.SideTypeBuy) {
+		// Под покупку заблокировано по цене ордера, разница с ценой сделки возвращается
+		quoteBalance.locked = quoteBalance.locked.Sub(order.locked)
+		quoteBalance.free = quoteBalance.free.Add(order.locked).Sub(quote)
+		trade.commission = quantity.Mul(rate)
+		trade.commissionAsset = book.baseAsset
+		base.free = base.free.Add(quantity).Sub(trade.commission)
+	} else {
+		base.locked = base.locked.Sub(order.locked)
+		trade.commission = quote.Mul(rate)
+		trade.commissionAsset = book.quoteAsset
+		quoteBalance.free = quoteBalance.free.Add(quote).Sub(trade.commission)
+	}
+
+	s.ledger(order.strategyID, book.symbol).record(order.side, trade, quote)
+
+	s.lastTradeID++
+	trade.id = s.lastTradeID
+	order.locked = decimal.Zero
+	order.executedQty = order.quantity
+	order.quoteQty = order.quoteQty.Add(quote)
+	order.status = binance.OrderStatusTypeFilled
+	order.updatedAt = s.now()
+
+	s.report(order, "TRADE", &trade)
+	s.position(book.baseAsset, book.quoteAsset)
+	return &binance.Fill{
+		TradeID:         trade.id,
+		Price:           price.String(),
+		Quantity:        quantity.String(),
+		Commission:      trade.commission.String(),
+		CommissionAsset: trade.commissionAsset,
+	}
+}
+
+func (s *Simulator) hasOpenOrder(symbol, clientOrderID string) bool {
+	for _, order := range s.orders {
+		if order.symbol == symbol && order.clientOrderID == clientOrderID && order.isOpen() {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Simulator) balance(asset string) *simBalance {
+	balance, ok := s.balances[asset]
+	if !ok {
+		balance = &simBalance{}
+		s.balances[asset] = balance
+	}
+	return balance
+}
+
+// report добавляет отчет об исполнении ордера в очередь подписчика
+func (s *Simulator) report(order *simOrder, executionType string, trade *simTrade) {
+	if !s.subscribed {
+		return
+	}
+	now := s.now()
+	report := model.ExecutionReport{
+		Symbol:              order.symbol,
+		ClientOrderID:       order.clientOrderID,
+		BinanceID:           order.id,
+		Side:                order.side,
+		Type:                order.orderType,
+		TimeInForce:         order.timeInForce,
+		Quantity:            order.quantity,
+		Price:               order.price,
+		ExecutionType:       executionType,
+		Status:              exchangeStatus(order.status),
+		CumulativeFilledQty: order.executedQty,
+		CumulativeQuoteQty:  order.quoteQty,
+		EventTime:           now,
+		TransactionTime:     now,
+	}
+	if trade != nil {
+		report.TradeID = trade.id
+		report.LastFilledQty = trade.quantity
+		report.LastFilledPrice = trade.price
+		report.IsMaker = trade.isMaker
+		report.Commission = trade.commission
+		report.CommissionAsset = trade.commissionAsset
+	}
+	s.events = append(s.events, simEvent{report: &report})
+	s.eventsCond.Signal()
+}
+
+// position добавляет в очередь подписчика текущие балансы активов assets
+func (s *Simulator) position(assets ...string) {
+	if !s.subscribed {
+		return
+	}
+	now := s.now()
+	position := model.AccountPosition{EventTime: now, UpdateTime: now}
+	for _, asset := range assets {
+		balance := s.balance(asset)
+		position.Balances = append(position.Balances, model.Balance{Asset: asset, Free: balance.free, Locked: balance.locked})
+	}
+	s.events = append(s.events, simEvent{position: &position})
+	s.eventsCond.Signal()
+}
+
+// subscribe передает события симулятора handler по порядку до отмены ctx
+func (s *Simulator) subscribe(ctx context.Context, handler userStreamHandler) chan struct{} {
+	s.mu.Lock()
+	s.subscribed = true
+	s.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		s.eventsCond.Broadcast()
+		s.mu.Unlock()
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer stop()
+		for {
+			s.mu.Lock()
+			for len(s.events) == 0 && ctx.Err() == nil {
+				s.eventsCond.Wait()
+			}
+			if ctx.Err() != nil {
+				s.subscribed = false
+				s.events = nil
+				s.mu.Unlock()
+				return
+			}
+			event := s.events[0]
+			s.events = s.events[1:]
+			s.mu.Unlock()
+
+			if event.report != nil {
+				handler.handleExecutionReport(*event.report)
+			} else {
+				handler.handleAccountPosition(*event.position)
+			}
+		}
+	}()
+	return done
+}
+
+// crosses сообщает, что лимитный ордер исполнится сразу по последней цене
+func (b *simBook) crosses(order *simOrder) bool {
+	if !b.lastPrice.IsPositive() {
+		return false
+	}
+	if order.side == string(binance.SideTypeBuy) {
+		return order.price.GreaterThanOrEqual(b.lastPrice)
+	}
+	return order.price.LessThanOrEqual(b.lastPrice)
+}
+
+// marketQuantity количество рыночного ордера на сумму quote по последней цене, округленное вниз до stepSize
+func (b *simBook) marketQuantity(quote decimal.Decimal) decimal.Decimal {
+	quantity := quote.DivRound(b.lastPrice, 16)
+	if lot, _, ok := b.rules.lotFor(string(binance.OrderTypeMarket)); ok && lot.stepSize.IsPositive() {
+		return roundToStep(quantity, lot.stepSize, false)
+	}
+	return quantity.Truncate(8)
+}
+
+// lockedAsset актив, который блокируется под ордер
+func (b *simBook) lockedAsset(order *simOrder) string {
+	if order.side == string(binance.SideTypeBuy) {
+		return b.quoteAsset
+	}
+	return b.baseAsset
+}
+
+// lockFor актив и сумма, которые нужно заблокировать под ордер. Покупка блокирует сумму по цене ордера,
+// рыночная — по последней цене
+func (b *simBook) lockFor(order *simOrder) (string, decimal.Decimal) {
+	if order.side != string(binance.SideTypeBuy) {
+		return b.baseAsset, order.quantity
+	}
+	price := order.price
+	if order.orderType == string(binance.OrderTypeMarket) {
+		price = b.lastPrice
+	}
+	return b.quoteAsset, price.Mul(order.quantity)
+}
+
+func (b *simBook) add(order *simOrder) {
+	if order.side == string(binance.SideTypeBuy) {
+		b.bids = append(b.bids, order)
+		sort.SliceStable(b.bids, func(i, j int) bool { return b.bids[i].price.GreaterThan(b.bids[j].price) })
+		return
+	}
+	b.asks = append(b.asks, order)
+	sort.SliceStable(b.asks, func(i, j int) bool { return b.asks[i].price.LessThan(b.asks[j].price) })
+}
+
+func (b *simBook) remove(order *simOrder) {
+	for _, side := range []*[]*simOrder{&b.bids, &b.asks} {
+		for i, resting := range *side {
+			if resting == order {
+				*side = append((*side)[:i], (*side)[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (o *simOrder) isOpen() bool {
+	return o.status == binance.OrderStatusTypeNew || o.status == binance.OrderStatusTypePartiallyFilled
+}
+
+func (o *simOrder) binanceOrder() *binance.Order {
+	return &binance.Order{
+		Symbol:                   o.symbol,
+		OrderID:                  o.id,
+		OrderListId:              -1,
+		ClientOrderID:            o.clientOrderID,
+		Price:                    o.price.String(),
+		OrigQuantity:             o.quantity.String(),
+		ExecutedQuantity:         o.executedQty.String(),
+		CummulativeQuoteQuantity: o.quoteQty.String(),
+		Status:                   o.status,
+		TimeInForce:              binance.TimeInForceType(o.timeInForce),
+		Type:                     binance.OrderType(o.orderType),
+		Side:                     binance.SideType(o.side),
+		Time:                     o.createdAt.UnixMilli(),
+		UpdateTime:               o.updatedAt.UnixMilli(),
+		IsWorking:                true,
+	}
+}
+
+func (o *simOrder) createResponse(fills []*binance.Fill) *binance.CreateOrderResponse {
+	return &binance.CreateOrderResponse{
+		Symbol:                   o.symbol,
+		OrderID:                  o.id,
+		ClientOrderID:            o.clientOrderID,
+		TransactTime:             o.updatedAt.UnixMilli(),
+		Price:                    o.price.String(),
+		OrigQuantity:             o.quantity.String(),
+		ExecutedQuantity:         o.executedQty.String(),
+		CummulativeQuoteQuantity: o.quoteQty.String(),
+		Status:                   o.status,
+		TimeInForce:              binance.TimeInForceType(o.timeInForce),
+		Type:                     binance.OrderType(o.orderType),
+		Side:                     binance.SideType(o.side),
+		Fills:                    fills,
+	}
+}
+
+func (o *simOrder) cancelResponse() *binance.CancelOrderResponse {
+	return &binance.CancelOrderResponse{
+		Symbol:                   o.symbol,
+		OrigClientOrderID:        o.clientOrderID,
+		OrderID:                  o.id,
+		OrderListID:              -1,
+		ClientOrderID:            o.clientOrderID,
+		TransactTime:             o.updatedAt.UnixMilli(),
+		Price:                    o.price.String(),
+		OrigQuantity:             o.quantity.String(),
+		ExecutedQuantity:         o.executedQty.String(),
+		CummulativeQuoteQuantity: o.quoteQty.String(),
+		Status:                   o.status,
+		TimeInForce:              binance.TimeInForceType(o.timeInForce),
+		Type:                     binance.OrderType(o.orderType),
+		Side:                     binance.SideType(o.side),
+	}
+}
+
+func simulatorError(code int64, message string) error {
+	return &common.APIError{Code: code, Message: message}
+}
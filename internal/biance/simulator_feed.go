@@ -0,0 +1,178 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+)
+
+// PriceTick цена символа в момент Time
+type PriceTick struct {
+	Symbol string
+	Price  decimal.Decimal
+	Time   time.Time
+}
+
+// PriceFeed источник цен для симулятора
+type PriceFeed interface {
+	// Next возвращает следующую цену. Когда цены закончились, возвращает io.EOF
+	Next(ctx context.Context) (PriceTick, error)
+}
+
+// RunFeed передает симулятору цены из feed, пока они не закончатся или не будет отменен ctx
+func (s *Simulator) RunFeed(ctx context.Context, feed PriceFeed) error {
+	for {
+		tick, err := feed.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.SetPrice(tick.Symbol, tick.Price); err != nil {
+			logger.Log.Warn(err)
+		}
+	}
+}
+
+// ReplayFeed проигрывает записанные цены по порядку
+type ReplayFeed struct {
+	ticks    []PriceTick
+	interval time.Duration
+	next     int
+}
+
+// NewReplayFeed создает источник, который выдает ticks с паузой interval между ними.
+// При interval 0 цены выдаются сразу
+func NewReplayFeed(ticks []PriceTick, interval time.Duration) *ReplayFeed {
+	return &ReplayFeed{ticks: ticks, interval: interval}
+}
+
+func (f *ReplayFeed) Next(ctx context.Context) (PriceTick, error) {
+	if f.next >= len(f.ticks) {
+		return PriceTick{}, io.EOF
+	}
+	if f.next > 0 && f.interval > 0 {
+		timer := time.NewTimer(f.interval)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return PriceTick{}, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	tick := f.ticks[f.next]
+	f.next++
+	return tick, nil
+}
+
+// LoadPriceTicks читает записанные цены из CSV файла со строками time,symbol,price. Время задается в RFC 3339
+// или в миллисекундах Unix, строка заголовка пропускается
+func LoadPriceTicks(path string) ([]PriceTick, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка открытия файла цен: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения файла цен %s: %w", path, err)
+	}
+
+	ticks := make([]PriceTick, 0, len(records))
+	for i, record := range records {
+		price, err := decimal.NewFromString(record[2])
+		if err != nil {
+			if i == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("файл цен %s, строка %d: неверная цена %q", path, i+1, record[2])
+		}
+		tickTime, err := parseTickTime(record[0])
+		if err != nil {
+			return nil, fmt.Errorf("файл цен %s, строка %d: неверное время %q", path, i+1, record[0])
+		}
+		ticks = append(ticks, PriceTick{Symbol: strings.ToUpper(record[1]), Price: price, Time: tickTime})
+	}
+	return ticks, nil
+}
+
+func parseTickTime(value string) (time.Time, error) {
+	if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(millis), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// TickerFeed опрашивает текущие цены Binance каждые interval. Ошибки запроса пишутся в лог, и опрос продолжается
+type TickerFeed struct {
+	client   *binance.Client
+	symbols  []string
+	interval time.Duration
+	pending  []PriceTick
+	polled   bool
+}
+
+// NewTickerFeed создает источник цен символов symbols с публичного API Binance по адресу url.
+// Пустой symbols означает все символы биржи
+func NewTickerFeed(url string, symbols []string, interval time.Duration) *TickerFeed {
+	client := binance.NewClient("", "")
+	client.BaseURL = url
+	client.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	return &TickerFeed{client: client, symbols: symbols, interval: interval}
+}
+
+func (f *TickerFeed) Next(ctx context.Context) (PriceTick, error) {
+	for len(f.pending) == 0 {
+		if f.polled {
+			timer := time.NewTimer(f.interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return PriceTick{}, ctx.Err()
+			case <-timer.C:
+			}
+		}
+		f.polled = true
+
+		service := f.client.NewListPricesService()
+		if len(f.symbols) > 0 {
+			service.Symbols(f.symbols)
+		}
+		prices, err := service.Do(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return PriceTick{}, ctx.Err()
+			}
+			logger.Log.Warn("Ошибка при получении цен биржи: ", err)
+			continue
+		}
+		now := time.Now()
+		for _, price := range prices {
+			value, err := decimal.NewFromString(price.Price)
+			if err != nil {
+				continue
+			}
+			f.pending = append(f.pending, PriceTick{Symbol: price.Symbol, Price: value, Time: now})
+		}
+	}
+
+	tick := f.pending[0]
+	f.pending = f.pending[1:]
+	return tick, nil
+}
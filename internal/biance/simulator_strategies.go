@@ -0,0 +1,109 @@
+package biance
+
+import (
+	"sort"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+)
+
+// strategyLedger изменение балансов стратегии от сделок по одному символу
+type strategyLedger struct {
+	// base и quote изменение базового актива и актива котировки с учетом комиссий
+	base     decimal.Decimal
+	quote    decimal.Decimal
+	fills    int
+	turnover decimal.Decimal
+}
+
+// StrategyPosition результат стратегии по символу в симуляторе
+type StrategyPosition struct {
+	Symbol     string `json:"symbol"`
+	BaseAsset  string `json:"base_asset"`
+	QuoteAsset string `json:"quote_asset"`
+	// Position и QuoteBalance изменение базового актива и актива котировки от сделок с учетом комиссий
+	Position     decimal.Decimal `json:"position"`
+	QuoteBalance decimal.Decimal `json:"quote_balance"`
+	Fills        int             `json:"fills"`
+	// Turnover сумма сделок в активе котировки
+	Turnover  decimal.Decimal `json:"turnover"`
+	LastPrice decimal.Decimal `json:"last_price"`
+	// PnL результат в активе котировки, если закрыть позицию по последней цене
+	PnL decimal.Decimal `json:"pnl"`
+}
+
+// StrategyReport итоги стратегии в симуляторе
+type StrategyReport struct {
+	StrategyID int64 `json:"strategy_id"`
+	Fills      int   `json:"fills"`
+	// Balances изменение балансов по активам от сделок стратегии
+	Balances map[string]decimal.Decimal `json:"balances"`
+	// PnL результат по активам котировки
+	PnL       map[string]decimal.Decimal `json:"pnl"`
+	Positions []StrategyPosition         `json:"positions"`
+}
+
+// StrategyReports возвращает итоги сделок по каждой стратегии. Ордера без StrategyID учитываются под нулевой стратегией
+func (s *Simulator) StrategyReports() []StrategyReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reports := make([]StrategyReport, 0, len(s.strategies))
+	for strategyID, ledgers := range s.strategies {
+		report := StrategyReport{
+			StrategyID: strategyID,
+			Balances:   make(map[string]decimal.Decimal),
+			PnL:        make(map[string]decimal.Decimal),
+		}
+		for symbol, ledger := range ledgers {
+			book := s.books[symbol]
+			position := StrategyPosition{
+				Symbol:       symbol,
+				BaseAsset:    book.baseAsset,
+				QuoteAsset:   book.quoteAsset,
+				Position:     ledger.base,
+				QuoteBalance: ledger.quote,
+				Fills:        ledger.fills,
+				Turnover:     ledger.turnover,
+				LastPrice:    book.lastPrice,
+				PnL:          ledger.quote.Add(ledger.base.Mul(book.lastPrice)),
+			}
+			report.Positions = append(report.Positions, position)
+			report.Fills += ledger.fills
+			report.Balances[book.baseAsset] = report.Balances[book.baseAsset].Add(ledger.base)
+			report.Balances[book.quoteAsset] = report.Balances[book.quoteAsset].Add(ledger.quote)
+			report.PnL[book.quoteAsset] = report.PnL[book.quoteAsset].Add(position.PnL)
+		}
+		sort.Slice(report.Positions, func(i, j int) bool { return report.Positions[i].Symbol < report.Positions[j].Symbol })
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].StrategyID < reports[j].StrategyID })
+	return reports
+}
+
+func (s *Simulator) ledger(strategyID int64, symbol string) *strategyLedger {
+	ledgers, ok := s.strategies[strategyID]
+	if !ok {
+		ledgers = make(map[string]*strategyLedger)
+		s.strategies[strategyID] = ledgers
+	}
+	ledger, ok := ledgers[symbol]
+	if !ok {
+		ledger = &strategyLedger{}
+		ledgers[symbol] = ledger
+	}
+	return ledger
+}
+
+// record учитывает сделку trade на сумму quote. Комиссия уменьшает полученный актив
+func (l *strategyLedger) record(side string, trade simTrade, quote decimal.Decimal) {
+	if side == string(binance.SideTypeBuy) {
+		l.base = l.base.Add(trade.quantity).Sub(trade.commission)
+		l.quote = l.quote.Sub(quote)
+	} else {
+		l.base = l.base.Sub(trade.quantity)
+		l.quote = l.quote.Add(quote).Sub(trade.commission)
+	}
+	l.fills++
+	l.turnover = l.turnover.Add(quote)
+}
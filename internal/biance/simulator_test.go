@@ -0,0 +1,261 @@
+package biance_test
+
+import (
+	"app/internal/biance"
+	"app/internal/model"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/shopspring/decimal"
+)
+
+func newTestSimulator(balances map[string]string) *biance.Simulator {
+	config := biance.SimulatorConfig{
+		Symbols:  []binance.Symbol{biance.NewSimulatorSymbol("BTCUSDT", "BTC", "USDT", "0.01", "0.001", "10")},
+		Balances: make(map[string]decimal.Decimal, len(balances)),
+		MakerFee: decimal.RequireFromString("0.001"),
+		TakerFee: decimal.RequireFromString("0.002"),
+		Now:      func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) },
+	}
+	for asset, amount := range balances {
+		config.Balances[asset] = decimal.RequireFromString(amount)
+	}
+	return biance.NewSimulator(config)
+}
+
+func limitOrder(side, quantity, price string) model.Order {
+	return model.Order{
+		Symbol:      "BTCUSDT",
+		Side:        side,
+		Type:        string(binance.OrderTypeLimit),
+		TimeInForce: string(binance.TimeInForceTypeGTC),
+		Quantity:    decimal.RequireFromString(quantity),
+		Price:       decimal.RequireFromString(price),
+	}
+}
+
+func marketOrder(side, quantity string) model.Order {
+	return model.Order{
+		Symbol:   "BTCUSDT",
+		Side:     side,
+		Type:     string(binance.OrderTypeMarket),
+		Quantity: decimal.RequireFromString(quantity),
+	}
+}
+
+func quoteMarketOrder(side, quoteOrderQty string) model.Order {
+	return model.Order{
+		Symbol:        "BTCUSDT",
+		Side:          side,
+		Type:          string(binance.OrderTypeMarket),
+		QuoteOrderQty: decimal.RequireFromString(quoteOrderQty),
+	}
+}
+
+// balance возвращает свободный и заблокированный остаток актива симулятора
+func balance(t *testing.T, sim *biance.Simulator, asset string) (free, locked string) {
+	t.Helper()
+	balances, err := sim.Balances(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range balances {
+		if b.Asset == asset {
+			return decimal.RequireFromString(b.Free).String(), decimal.RequireFromString(b.Locked).String()
+		}
+	}
+	return "0", "0"
+}
+
+func assertBalance(t *testing.T, sim *biance.Simulator, asset, wantFree, wantLocked string) {
+	t.Helper()
+	free, locked := balance(t, sim, asset)
+	if free != wantFree || locked != wantLocked {
+		t.Fatalf("баланс %s: свободно %s, заблокировано %s, ожидалось %s и %s", asset, free, locked, wantFree, wantLocked)
+	}
+}
+
+func assertAPIError(t *testing.T, err error, code int64, message string) {
+	t.Helper()
+	var apiErr *common.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("ожидалась ошибка биржи %d, получено %v", code, err)
+	}
+	if apiErr.Code != code || !strings.Contains(apiErr.Message, message) {
+		t.Fatalf("ошибка %d %q, ожидалась %d с %q", apiErr.Code, apiErr.Message, code, message)
+	}
+}
+
+func TestSimulatorLimitOrderFillsAsMaker(t *testing.T) {
+	sim := newTestSimulator(map[string]string{"USDT": "1000"})
+	ctx := context.Background()
+	if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(110)); err != nil {
+		t.Fatal(err)
+	}
+
+	placed, err := sim.PlaceOrder(ctx, limitOrder("BUY", "1", "100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed.Status != binance.OrderStatusTypeNew || len(placed.Fills) != 0 {
+		t.Fatalf("ордер ниже цены должен ждать в книге: статус %s, сделок %d", placed.Status, len(placed.Fills))
+	}
+	assertBalance(t, sim, "USDT", "900", "100")
+
+	// Цена не дошла до ордера
+	if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(101)); err != nil {
+		t.Fatal(err)
+	}
+	order, err := sim.QueryOrder(ctx, "BTCUSDT", placed.OrderID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != binance.OrderStatusTypeNew {
+		t.Fatalf("статус %s, ожидался NEW", order.Status)
+	}
+
+	// Цена дошла: исполнение по цене ордера с комиссией maker в базовом активе
+	if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(99)); err != nil {
+		t.Fatal(err)
+	}
+	order, err = sim.QueryOrder(ctx, "BTCUSDT", placed.OrderID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != binance.OrderStatusTypeFilled || order.CummulativeQuoteQuantity != "100" {
+		t.Fatalf("статус %s, сумма %s, ожидались FILLED и 100", order.Status, order.CummulativeQuoteQuantity)
+	}
+	assertBalance(t, sim, "USDT", "900", "0")
+	assertBalance(t, sim, "BTC", "0.999", "0")
+}
+
+func TestSimulatorCrossingOrdersFillAsTaker(t *testing.T) {
+	sim := newTestSimulator(map[string]string{"USDT": "1000", "BTC": "2"})
+	ctx := context.Background()
+	if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(100)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Лимитная покупка выше цены исполняется по последней цене, а не по своей
+	placed, err := sim.PlaceOrder(ctx, limitOrder("BUY", "1", "105"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed.Status != binance.OrderStatusTypeFilled || len(placed.Fills) != 1 {
+		t.Fatalf("статус %s, сделок %d, ожидалось исполнение одной сделкой", placed.Status, len(placed.Fills))
+	}
+	fill := placed.Fills[0]
+	if fill.Price != "100" || fill.Commission != "0.002" || fill.CommissionAsset != "BTC" {
+		t.Fatalf("сделка по %s с комиссией %s %s, ожидалась по 100 с комиссией 0.002 BTC", fill.Price, fill.Commission, fill.CommissionAsset)
+	}
+	assertBalance(t, sim, "USDT", "900", "0")
+	assertBalance(t, sim, "BTC", "2.998", "0")
+
+	// Рыночная продажа: комиссия taker удерживается из актива котировки
+	placed, err = sim.PlaceOrder(ctx, marketOrder("SELL", "0.5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed.Status != binance.OrderStatusTypeFilled || placed.CummulativeQuoteQuantity != "50" {
+		t.Fatalf("статус %s, сумма %s, ожидались FILLED и 50", placed.Status, placed.CummulativeQuoteQuantity)
+	}
+	assertBalance(t, sim, "USDT", "949.9", "0")
+	assertBalance(t, sim, "BTC", "2.498", "0")
+}
+
+func TestSimulatorMarketOrderWithoutPrice(t *testing.T) {
+	sim := newTestSimulator(map[string]string{"USDT": "1000"})
+
+	_, err := sim.PlaceOrder(context.Background(), marketOrder("BUY", "1"))
+	assertAPIError(t, err, -2010, "Market is closed")
+}
+
+func TestSimulatorRejectsFilterFailures(t *testing.T) {
+	sim := newTestSimulator(map[string]string{"USDT": "1000"})
+	if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(200)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		order   model.Order
+		message string
+	}{
+		{"количество не кратно stepSize", limitOrder("BUY", "0.0015", "100"), "LOT_SIZE"},
+		{"количество меньше minQty", limitOrder("BUY", "0.0001", "100"), "LOT_SIZE"},
+		{"цена не кратна tickSize", limitOrder("BUY", "1", "100.005"), "PRICE_FILTER"},
+		{"сумма меньше minNotional", limitOrder("BUY", "0.05", "100"), "NOTIONAL"},
+		{"рыночный ордер на сумму меньше minNotional", quoteMarketOrder("BUY", "5"), "NOTIONAL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := sim.PlaceOrder(context.Background(), tt.order)
+			assertAPIError(t, err, -1013, tt.message)
+		})
+	}
+	assertBalance(t, sim, "USDT", "1000", "0")
+}
+
+func TestSimulatorRejectsInsufficientBalance(t *testing.T) {
+	sim := newTestSimulator(map[string]string{"USDT": "150"})
+	ctx := context.Background()
+	if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(200)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sim.PlaceOrder(ctx, limitOrder("BUY", "1", "100")); err != nil {
+		t.Fatal(err)
+	}
+	// Свободно 50 USDT: 100 заблокированы под первый ордер
+	_, err := sim.PlaceOrder(ctx, limitOrder("BUY", "1", "100"))
+	assertAPIError(t, err, -2010, "insufficient balance")
+	_, err = sim.PlaceOrder(ctx, limitOrder("SELL", "1", "300"))
+	assertAPIError(t, err, -2010, "insufficient balance")
+}
+
+func TestSimulatorCancelReleasesLockedBalance(t *testing.T) {
+	sim := newTestSimulator(map[string]string{"USDT": "1000", "BTC": "1"})
+	ctx := context.Background()
+	if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(100)); err != nil {
+		t.Fatal(err)
+	}
+
+	buy, err := sim.PlaceOrder(ctx, limitOrder("BUY", "2", "90"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sell, err := sim.PlaceOrder(ctx, limitOrder("SELL", "0.4", "120"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, sim, "USDT", "820", "180")
+	assertBalance(t, sim, "BTC", "0.6", "0.4")
+
+	canceled, err := sim.CancelOrder(ctx, model.Order{Symbol: "BTCUSDT", BinanceID: buy.OrderID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if canceled.Status != binance.OrderStatusTypeCanceled {
+		t.Fatalf("статус %s, ожидался CANCELED", canceled.Status)
+	}
+	assertBalance(t, sim, "USDT", "1000", "0")
+	assertBalance(t, sim, "BTC", "0.6", "0.4")
+
+	if _, err := sim.CancelOrder(ctx, model.Order{Symbol: "BTCUSDT", BinanceID: sell.OrderID}); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, sim, "BTC", "1", "0")
+
+	// Отмененный ордер не исполняется и повторно не отменяется
+	if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(80)); err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, sim, "USDT", "1000", "0")
+	_, err = sim.CancelOrder(ctx, model.Order{Symbol: "BTCUSDT", BinanceID: buy.OrderID})
+	assertAPIError(t, err, -2011, "Unknown order")
+}
@@ -0,0 +1,478 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"app/internal/request"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+)
+
+// Фильтр MIN_NOTIONAL, для которого в go-binance нет отдельного метода
+const filterTypeMinNotional = "MIN_NOTIONAL"
+
+// Статус символа, по которому разрешена торговля
+const symbolStatusTrading = "TRADING"
+
+// lotRule ограничения на количество из LOT_SIZE или MARKET_LOT_SIZE
+type lotRule struct {
+	minQty   decimal.Decimal
+	maxQty   decimal.Decimal
+	stepSize decimal.Decimal
+}
+
+// symbolRules правила торговли по символу из exchangeInfo
+type symbolRules struct {
+	status     string
+	baseAsset  string
+	quoteAsset string
+	orderTypes map[string]bool
+
+	icebergAllowed             bool
+	quoteOrderQtyMarketAllowed bool
+
+	// PRICE_FILTER
+	hasPriceFilter bool
+	minPrice       decimal.Decimal
+	maxPrice       decimal.Decimal
+	tickSize       decimal.Decimal
+
+	// LOT_SIZE и MARKET_LOT_SIZE
+	hasLotSize       bool
+	lotSize          lotRule
+	hasMarketLotSize bool
+	marketLotSize    lotRule
+
+	// MIN_NOTIONAL или NOTIONAL
+	minNotional      decimal.Decimal
+	applyMinToMarket bool
+	maxNotional      decimal.Decimal
+	applyMaxToMarket bool
+
+	// MAX_NUM_ORDERS, 0 если ограничения нет
+	maxNumOrders int
+
+	// Точность суммы в валюте котировки
+	quotePrecision int
+}
+
+// SymbolRulesService кэш правил торговли по символам из exchangeInfo. Проверяет ордера по фильтрам
+// PRICE_FILTER, LOT_SIZE, MIN_NOTIONAL/NOTIONAL, MARKET_LOT_SIZE, MAX_NUM_ORDERS до отправки на биржу
+// и, если включено округление, приводит цену и количество к tickSize и stepSize.
+type SymbolRulesService struct {
+	exchange Exchange
+	round    bool
+	// limiter получает лимиты запросов из exchangeInfo при каждом обновлении. Может быть nil
+	limiter *request.RateLimiter
+	// requester выполняет запросы обновления с учетом лимитов биржи. Если nil, запросы выполняются напрямую
+	requester *request.RequestHandler
+
+	mu        sync.RWMutex
+	rules     map[string]symbolRules
+	updatedAt time.Time
+	// openOrders число открытых ордеров по символу. Обновляется с биржи при Refresh
+	// и локально после размещения и отмены
+	openOrders map[string]int
+}
+
+// NewSymbolRulesService создает сервис правил биржи exchange. Перед использованием нужно загрузить правила через Refresh
+func NewSymbolRulesService(exchange Exchange, round bool) *SymbolRulesService {
+	return &SymbolRulesService{
+		exchange:   exchange,
+		round:      round,
+		rules:      make(map[string]symbolRules),
+		openOrders: make(map[string]int),
+	}
+}
+
+// Refresh загружает правила по всем символам и число открытых ордеров
+func (s *SymbolRulesService) Refresh(ctx context.Context) error {
+	var exchangeInfo *binance.ExchangeInfo
+	err := s.call(requestCost(http.MethodGet, endpointExchangeInfo), func() error {
+		var err error
+		exchangeInfo, err = s.exchange.ExchangeInfo(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка при получении exchangeInfo: %w", err)
+	}
+
+	if s.limiter != nil {
+		applyRateLimits(s.limiter, exchangeInfo.RateLimits)
+	}
+
+	rules := make(map[string]symbolRules, len(exchangeInfo.Symbols))
+	for i := range exchangeInfo.Symbols {
+		symbol := &exchangeInfo.Symbols[i]
+		rules[symbol.Symbol] = parseSymbolRules(symbol)
+	}
+
+	var openOrders []*binance.Order
+	err = s.call(openOrdersCost(""), func() error {
+		var err error
+		openOrders, err = s.exchange.OpenOrders(ctx, "")
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка при получении открытых ордеров: %w", err)
+	}
+	counts := make(map[string]int)
+	for _, order := range openOrders {
+		counts[order.Symbol]++
+	}
+
+	s.mu.Lock()
+	s.rules = rules
+	s.openOrders = counts
+	s.updatedAt = time.Now()
+	s.mu.Unlock()
+
+	logger.Log.Info(fmt.Sprintf("Правила торговли обновлены: %d символов, %d открытых ордеров", len(rules), len(openOrders)))
+	return nil
+}
+
+// call выполняет запрос к бирже fn с расходом cost через requester, если он задан
+func (s *SymbolRulesService) call(cost request.Cost, fn func() error) error {
+	if s.requester == nil {
+		return fn()
+	}
+	return s.requester.SyncHandleWeightedRequest(cost, fn)
+}
+
+// StartRefreshing периодически обновляет правила до отмены ctx
+func (s *SymbolRulesService) StartRefreshing(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				// Продолжаем работать с прежними правилами
+				logger.Log.Error("Ошибка при обновлении правил торговли: ", err)
+			}
+		}
+	}
+}
+
+// OrderOpened учитывает новый открытый ордер по символу
+func (s *SymbolRulesService) OrderOpened(symbol string) {
+	s.mu.Lock()
+	s.openOrders[symbol]++
+	s.mu.Unlock()
+}
+
+// OrderClosed учитывает закрытие ордера по символу
+func (s *SymbolRulesService) OrderClosed(symbol string) {
+	s.mu.Lock()
+	if s.openOrders[symbol] > 0 {
+		s.openOrders[symbol]--
+	}
+	s.mu.Unlock()
+}
+
+// Apply проверяет ордер по правилам символа. Если включено округление, сначала приводит цену к tickSize,
+// а количество к stepSize. Возвращает ордер, который нужно отправить на биржу.
+func (s *SymbolRulesService) Apply(order model.Order) (model.Order, error) {
+	if order.Action != PlaceOrder && order.Action != EditOrder {
+		return order, nil
+	}
+
+	s.mu.RLock()
+	rules, ok := s.rules[order.Symbol]
+	openOrders := s.openOrders[order.Symbol]
+	s.mu.RUnlock()
+	if !ok {
+		return order, fmt.Errorf("символ %s не найден в exchangeInfo", order.Symbol)
+	}
+
+	if s.round {
+		order = rules.roundOrder(order)
+	}
+	if err := rules.validate(order); err != nil {
+		return order, err
+	}
+
+	// Редактирование заменяет ордер и не меняет число открытых
+	if order.Action == PlaceOrder && rules.maxNumOrders > 0 && openOrders >= rules.maxNumOrders {
+		return order, fmt.Errorf("MAX_NUM_ORDERS: по %s уже открыто %d ордеров из %d допустимых", order.Symbol, openOrders, rules.maxNumOrders)
+	}
+	return order, nil
+}
+
+// Precision возвращает точность цены, количества и суммы в валюте котировки для ордера данного типа по символу.
+// ok равен false, если правила символа еще не загружены.
+func (s *SymbolRulesService) Precision(symbol, orderType string) (symbolPrecision, bool) {
+	s.mu.RLock()
+	rules, ok := s.rules[symbol]
+	s.mu.RUnlock()
+	if !ok {
+		return symbolPrecision{}, false
+	}
+	return rules.precision(orderType), true
+}
+
+// Assets возвращает базовый актив и актив котировки символа. ok равен false, если правила символа еще не загружены
+func (s *SymbolRulesService) Assets(symbol string) (base, quote string, ok bool) {
+	s.mu.RLock()
+	rules, ok := s.rules[symbol]
+	s.mu.RUnlock()
+	return rules.baseAsset, rules.quoteAsset, ok
+}
+
+func parseSymbolRules(symbol *binance.Symbol) symbolRules {
+	rules := symbolRules{
+		status:                     symbol.Status,
+		baseAsset:                  symbol.BaseAsset,
+		quoteAsset:                 symbol.QuoteAsset,
+		orderTypes:                 make(map[string]bool, len(symbol.OrderTypes)),
+		icebergAllowed:             symbol.IcebergAllowed,
+		quoteOrderQtyMarketAllowed: symbol.QuoteOrderQtyMarketAllowed,
+		quotePrecision:             symbol.QuoteAssetPrecision,
+	}
+	for _, orderType := range symbol.OrderTypes {
+		rules.orderTypes[orderType] = true
+	}
+
+	if filter := symbol.PriceFilter(); filter != nil {
+		rules.hasPriceFilter = true
+		rules.minPrice = parseDecimal(filter.MinPrice)
+		rules.maxPrice = parseDecimal(filter.MaxPrice)
+		rules.tickSize = parseDecimal(filter.TickSize)
+	}
+	if filter := symbol.LotSizeFilter(); filter != nil {
+		rules.hasLotSize = true
+		rules.lotSize = lotRule{
+			minQty:   parseDecimal(filter.MinQuantity),
+			maxQty:   parseDecimal(filter.MaxQuantity),
+			stepSize: parseDecimal(filter.StepSize),
+		}
+	}
+	if filter := symbol.MarketLotSizeFilter(); filter != nil {
+		rules.hasMarketLotSize = true
+		rules.marketLotSize = lotRule{
+			minQty:   parseDecimal(filter.MinQuantity),
+			maxQty:   parseDecimal(filter.MaxQuantity),
+			stepSize: parseDecimal(filter.StepSize),
+		}
+	}
+	if filter := symbol.NotionalFilter(); filter != nil {
+		rules.minNotional = parseDecimal(filter.MinNotional)
+		rules.applyMinToMarket = filter.ApplyMinToMarket
+		rules.maxNotional = parseDecimal(filter.MaxNotional)
+		rules.applyMaxToMarket = filter.ApplyMaxToMarket
+	}
+	for _, filter := range symbol.Filters {
+		if filter["filterType"] != filterTypeMinNotional {
+			continue
+		}
+		if value, ok := filter["minNotional"].(string); ok {
+			rules.minNotional = parseDecimal(value)
+		}
+		if value, ok := filter["applyToMarket"].(bool); ok {
+			rules.applyMinToMarket = value
+		}
+	}
+	if filter := symbol.MaxNumOrdersFilter(); filter != nil {
+		rules.maxNumOrders = filter.MaxNumOrders
+	}
+	return rules
+}
+
+func parseDecimal(value string) decimal.Decimal {
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero
+	}
+	return d
+}
+
+// lotFor возвращает ограничения на количество для типа ордера: MARKET_LOT_SIZE для рыночных, если он задан
+func (r symbolRules) lotFor(orderType string) (lotRule, string, bool) {
+	if orderType == string(binance.OrderTypeMarket) && r.hasMarketLotSize && r.marketLotSize.maxQty.IsPositive() {
+		return r.marketLotSize, "MARKET_LOT_SIZE", true
+	}
+	return r.lotSize, "LOT_SIZE", r.hasLotSize
+}
+
+// roundOrder приводит цены к tickSize, а количество к stepSize. Количество округляется вниз,
+// цена покупки вниз, цена продажи вверх, чтобы округление не ухудшало условия сделки
+func (r symbolRules) roundOrder(order model.Order) model.Order {
+	if r.hasPriceFilter && r.tickSize.IsPositive() {
+		roundUp := order.Side == string(binance.SideTypeSell)
+		if order.Price.IsPositive() {
+			order.Price = roundToStep(order.Price, r.tickSize, roundUp)
+		}
+		if order.StopPrice.IsPositive() {
+			order.StopPrice = roundToStep(order.StopPrice, r.tickSize, roundUp)
+		}
+	}
+	if lot, _, ok := r.lotFor(order.Type); ok && lot.stepSize.IsPositive() {
+		if order.Quantity.IsPositive() {
+			order.Quantity = roundToStep(order.Quantity, lot.stepSize, false)
+		}
+		if order.IcebergQty.IsPositive() {
+			order.IcebergQty = roundToStep(order.IcebergQty, lot.stepSize, false)
+		}
+	}
+	return order
+}
+
+func roundToStep(value, step decimal.Decimal, up bool) decimal.Decimal {
+	steps := value.Div(step)
+	if up {
+		steps = steps.Ceil()
+	} else {
+		steps = steps.Floor()
+	}
+	return steps.Mul(step)
+}
+
+// validate проверяет ордер по фильтрам символа
+func (r symbolRules) validate(order model.Order) error {
+	if r.status != symbolStatusTrading {
+		return fmt.Errorf("торговля по символу %s недоступна, статус %s", order.Symbol, r.status)
+	}
+	if !r.orderTypes[order.Type] {
+		return fmt.Errorf("тип ордера %s не поддерживается для %s", order.Type, order.Symbol)
+	}
+	if order.IcebergQty.IsPositive() && !r.icebergAllowed {
+		return fmt.Errorf("айсберг-ордера не поддерживаются для %s", order.Symbol)
+	}
+	if order.QuoteOrderQty.IsPositive() && !r.quoteOrderQtyMarketAllowed {
+		return fmt.Errorf("quote_order_qty не поддерживается для %s", order.Symbol)
+	}
+
+	price := order.Price
+	if r.hasPriceFilter {
+		if order.Price.IsPositive() {
+			if err := r.validatePrice("price", price); err != nil {
+				return err
+			}
+		}
+		if order.StopPrice.IsPositive() {
+			if err := r.validatePrice("stop_price", order.StopPrice); err != nil {
+				return err
+			}
+		}
+	}
+
+	quantity := order.Quantity
+	if lot, filterName, ok := r.lotFor(order.Type); ok && order.Quantity.IsPositive() {
+		if lot.minQty.IsPositive() && quantity.LessThan(lot.minQty) {
+			return fmt.Errorf("%s: количество %s меньше minQty %s", filterName, quantity, lot.minQty)
+		}
+		if lot.maxQty.IsPositive() && quantity.GreaterThan(lot.maxQty) {
+			return fmt.Errorf("%s: количество %s больше maxQty %s", filterName, quantity, lot.maxQty)
+		}
+		if lot.stepSize.IsPositive() && !quantity.Sub(lot.minQty).Mod(lot.stepSize).IsZero() {
+			return fmt.Errorf("%s: количество %s не кратно stepSize %s", filterName, quantity, lot.stepSize)
+		}
+	}
+
+	return r.validateNotional(order, price, quantity)
+}
+
+// validatePrice проверяет цену по PRICE_FILTER. Нулевые границы означают отсутствие ограничения
+func (r symbolRules) validatePrice(field string, price decimal.Decimal) error {
+	if r.minPrice.IsPositive() && price.LessThan(r.minPrice) {
+		return fmt.Errorf("PRICE_FILTER: %s %s меньше minPrice %s", field, price, r.minPrice)
+	}
+	if r.maxPrice.IsPositive() && price.GreaterThan(r.maxPrice) {
+		return fmt.Errorf("PRICE_FILTER: %s %s больше maxPrice %s", field, price, r.maxPrice)
+	}
+	if r.tickSize.IsPositive() && !price.Sub(r.minPrice).Mod(r.tickSize).IsZero() {
+		return fmt.Errorf("PRICE_FILTER: %s %s не кратна tickSize %s", field, price, r.tickSize)
+	}
+	return nil
+}
+
+// validateNotional проверяет сумму ордера по MIN_NOTIONAL/NOTIONAL. Для рыночных ордеров с количеством
+// сумма до исполнения неизвестна, поэтому они проверяются только при заданном quote_order_qty
+func (r symbolRules) validateNotional(order model.Order, price, quantity decimal.Decimal) error {
+	isMarket := order.Type == string(binance.OrderTypeMarket)
+
+	var notional decimal.Decimal
+	switch {
+	case order.QuoteOrderQty.IsPositive():
+		notional = order.QuoteOrderQty
+	case order.Price.IsPositive():
+		notional = price.Mul(quantity)
+	default:
+		return nil
+	}
+
+	if r.minNotional.IsPositive() && (!isMarket || r.applyMinToMarket) && notional.LessThan(r.minNotional) {
+		return fmt.Errorf("NOTIONAL: сумма ордера %s меньше minNotional %s", notional, r.minNotional)
+	}
+	if r.maxNotional.IsPositive() && (!isMarket || r.applyMaxToMarket) && notional.GreaterThan(r.maxNotional) {
+		return fmt.Errorf("NOTIONAL: сумма ордера %s больше maxNotional %s", notional, r.maxNotional)
+	}
+	return nil
+}
+
+// precision определяет число знаков после запятой по tickSize и stepSize
+func (r symbolRules) precision(orderType string) symbolPrecision {
+	precision := symbolPrecision{price: -1, quantity: -1, quote: -1}
+	if r.hasPriceFilter && r.tickSize.IsPositive() {
+		precision.price = stepPlaces(r.tickSize)
+	}
+	if lot, _, ok := r.lotFor(orderType); ok && lot.stepSize.IsPositive() {
+		precision.quantity = stepPlaces(lot.stepSize)
+	} else if r.hasLotSize && r.lotSize.stepSize.IsPositive() {
+		precision.quantity = stepPlaces(r.lotSize.stepSize)
+	}
+	if r.quotePrecision > 0 {
+		precision.quote = r.quotePrecision
+	}
+	return precision
+}
+
+// stepPlaces число значащих знаков после запятой в шаге: 0.01000000 -> 2
+func stepPlaces(step decimal.Decimal) int {
+	places := -step.Exponent()
+	for places > 0 && step.Shift(places-1).IsInteger() {
+		places--
+	}
+	if places < 0 {
+		return 0
+	}
+	return int(places)
+}
+
+// symbolPrecision число знаков после запятой для параметров ордера. -1 означает, что точность неизвестна
+// и значение передается как есть
+type symbolPrecision struct {
+	price    int
+	quantity int
+	quote    int
+}
+
+func (p symbolPrecision) formatPrice(value decimal.Decimal) string {
+	return formatDecimal(value, p.price)
+}
+
+func (p symbolPrecision) formatQuantity(value decimal.Decimal) string {
+	return formatDecimal(value, p.quantity)
+}
+
+func (p symbolPrecision) formatQuote(value decimal.Decimal) string {
+	return formatDecimal(value, p.quote)
+}
+
+// formatDecimal форматирует значение без экспоненты. Значение не округляется: если в нем больше знаков,
+// чем допускает символ, оно передается как есть и биржа отклонит ордер
+func formatDecimal(value decimal.Decimal, places int) string {
+	if places < 0 || -value.Exponent() > int32(places) && !value.Equal(value.Truncate(int32(places))) {
+		return value.String()
+	}
+	return value.StringFixed(int32(places))
+}
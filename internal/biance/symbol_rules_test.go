@@ -0,0 +1,164 @@
+package biance
+
+import (
+	"app/internal/model"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+)
+
+// testSymbol символ с шагом цены 0.01, шагом количества 0.001, рыночным лотом до 5 и минимальной суммой 10
+func testSymbol() binance.Symbol {
+	symbol := NewSimulatorSymbol("BTCUSDT", "BTC", "USDT", "0.01", "0.001", "10")
+	symbol.Filters = append(symbol.Filters,
+		map[string]interface{}{"filterType": string(binance.SymbolFilterTypeMarketLotSize), "minQty": "0.01", "maxQty": "5", "stepSize": "0.01"},
+		map[string]interface{}{"filterType": string(binance.SymbolFilterTypeMaxNumOrders), "maxNumOrders": 2},
+	)
+	return symbol
+}
+
+func newTestRules(round bool, symbol binance.Symbol) *SymbolRulesService {
+	s := NewSymbolRulesService(nil, round)
+	s.rules[symbol.Symbol] = parseSymbolRules(&symbol)
+	return s
+}
+
+func ruleOrder(orderType, side, quantity, price string) model.Order {
+	order := model.Order{
+		Action:   PlaceOrder,
+		Symbol:   "BTCUSDT",
+		Side:     side,
+		Type:     orderType,
+		Quantity: decimal.RequireFromString(quantity),
+	}
+	if price != "" {
+		order.TimeInForce = string(binance.TimeInForceTypeGTC)
+		order.Price = decimal.RequireFromString(price)
+	}
+	return order
+}
+
+func TestSymbolRulesApply(t *testing.T) {
+	limit := string(binance.OrderTypeLimit)
+	market := string(binance.OrderTypeMarket)
+	tests := []struct {
+		name  string
+		order model.Order
+		// err часть текста ошибки, пустая если ордер проходит проверку
+		err string
+	}{
+		{"лимитный ордер", ruleOrder(limit, "BUY", "0.5", "100.01"), ""},
+		{"цена не кратна tickSize", ruleOrder(limit, "BUY", "0.5", "100.005"), "PRICE_FILTER"},
+		{"количество меньше minQty", ruleOrder(limit, "BUY", "0.0005", "100000"), "LOT_SIZE"},
+		{"количество не кратно stepSize", ruleOrder(limit, "BUY", "0.5005", "100"), "LOT_SIZE"},
+		{"сумма меньше minNotional", ruleOrder(limit, "BUY", "0.01", "100"), "NOTIONAL"},
+		{"рыночный ордер сверх MARKET_LOT_SIZE", ruleOrder(market, "BUY", "6", ""), "MARKET_LOT_SIZE"},
+		{"лимитный ордер сверх MARKET_LOT_SIZE", ruleOrder(limit, "BUY", "6", "100"), ""},
+		{"рыночный ордер не кратен шагу MARKET_LOT_SIZE", ruleOrder(market, "BUY", "0.015", ""), "MARKET_LOT_SIZE"},
+		// Сумма рыночного ордера по количеству до исполнения неизвестна
+		{"рыночный ордер на малую сумму", ruleOrder(market, "BUY", "0.01", ""), ""},
+		{"рыночный ордер на сумму меньше minNotional", model.Order{Action: PlaceOrder, Symbol: "BTCUSDT", Side: "BUY",
+			Type: market, QuoteOrderQty: decimal.NewFromInt(5)}, "NOTIONAL"},
+		{"неподдерживаемый тип", ruleOrder(string(binance.OrderTypeStopLoss), "SELL", "0.5", ""), "не поддерживается"},
+		{"неизвестный символ", model.Order{Action: PlaceOrder, Symbol: "ETHUSDT"}, "не найден"},
+		{"отмена не проверяется", model.Order{Action: CancelOrder, Symbol: "ETHUSDT"}, ""},
+	}
+	s := newTestRules(false, testSymbol())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Apply(tt.order)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("ордер отклонен: %v", err)
+			case tt.err != "" && err == nil:
+				t.Fatalf("ордер принят, ожидалась ошибка %s", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Fatalf("ошибка %q, ожидалась %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSymbolRulesApplyRounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    model.Order
+		price    string
+		quantity string
+	}{
+		{"покупка округляет цену вниз", ruleOrder(string(binance.OrderTypeLimit), "BUY", "0.5009", "100.019"), "100.01", "0.5"},
+		{"продажа округляет цену вверх", ruleOrder(string(binance.OrderTypeLimit), "SELL", "0.5009", "100.011"), "100.02", "0.5"},
+		{"рыночный ордер по шагу MARKET_LOT_SIZE", ruleOrder(string(binance.OrderTypeMarket), "BUY", "0.019", ""), "0", "0.01"},
+	}
+	s := newTestRules(true, testSymbol())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := s.Apply(tt.order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !order.Price.Equal(decimal.RequireFromString(tt.price)) || !order.Quantity.Equal(decimal.RequireFromString(tt.quantity)) {
+				t.Fatalf("цена %s, количество %s, ожидались %s и %s", order.Price, order.Quantity, tt.price, tt.quantity)
+			}
+		})
+	}
+
+	// Округление не спасает ордер, который меньше минимального количества
+	if _, err := s.Apply(ruleOrder(string(binance.OrderTypeLimit), "BUY", "0.0009", "100000")); err == nil {
+		t.Fatal("ордер меньше minQty принят после округления")
+	}
+}
+
+func TestSymbolRulesSymbolState(t *testing.T) {
+	halted := testSymbol()
+	halted.Status = "BREAK"
+	if _, err := newTestRules(false, halted).Apply(ruleOrder(string(binance.OrderTypeLimit), "BUY", "0.5", "100")); err == nil {
+		t.Fatal("ордер принят по символу без торговли")
+	}
+
+	iceberg := ruleOrder(string(binance.OrderTypeLimit), "BUY", "0.5", "100")
+	iceberg.IcebergQty = decimal.RequireFromString("0.1")
+	if _, err := newTestRules(false, testSymbol()).Apply(iceberg); err == nil {
+		t.Fatal("айсберг-ордер принят по символу без айсбергов")
+	}
+}
+
+func TestSymbolRulesMaxNumOrders(t *testing.T) {
+	sim := NewSimulator(SimulatorConfig{
+		Symbols:  []binance.Symbol{testSymbol()},
+		Balances: map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)},
+	})
+	ctx := context.Background()
+	order := ruleOrder(string(binance.OrderTypeLimit), "BUY", "0.5", "100")
+	if _, err := sim.PlaceOrder(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSymbolRulesService(sim, false)
+	if err := s.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Один ордер открыт на бирже, второй допустим
+	if _, err := s.Apply(order); err != nil {
+		t.Fatal(err)
+	}
+	s.OrderOpened("BTCUSDT")
+	if _, err := s.Apply(order); err == nil || !strings.Contains(err.Error(), "MAX_NUM_ORDERS") {
+		t.Fatalf("ошибка %v, ожидалась MAX_NUM_ORDERS", err)
+	}
+
+	// Редактирование заменяет открытый ордер и не упирается в ограничение
+	edit := order
+	edit.Action = EditOrder
+	if _, err := s.Apply(edit); err != nil {
+		t.Fatal(err)
+	}
+
+	s.OrderClosed("BTCUSDT")
+	if _, err := s.Apply(order); err != nil {
+		t.Fatal(err)
+	}
+}
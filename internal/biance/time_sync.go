@@ -0,0 +1,106 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+)
+
+// codeInvalidTimestamp ошибка Binance: время запроса вне recvWindow относительно времени сервера
+const codeInvalidTimestamp = -1021
+
+// EnableTimeSync синхронизирует время подписанных запросов с сервером биржи сейчас и затем каждые interval
+// до отмены ctx. recvWindow задает, сколько запрос остается действительным после отправки; 0 оставляет
+// значение биржи по умолчанию. Запрос, отклоненный с -1021, повторяется один раз после синхронизации
+// и без EnableTimeSync. Если команды выполняет не Binance, ничего не делает.
+func (bm *BianceManager) EnableTimeSync(ctx context.Context, interval, recvWindow time.Duration) error {
+	if bm.binanceAPI == nil {
+		return nil
+	}
+	bm.binanceAPI.recvWindow = recvWindow
+	if err := bm.SyncTime(ctx); err != nil {
+		return err
+	}
+	bm.goBackground(func() { bm.startTimeSync(ctx, interval) })
+	return nil
+}
+
+// SyncTime измеряет смещение локальных часов относительно сервера биржи и применяет его к меткам времени
+// подписанных запросов
+func (bm *BianceManager) SyncTime(ctx context.Context) error {
+	if bm.binanceAPI == nil {
+		return nil
+	}
+	return bm.binanceAPI.syncTime(ctx)
+}
+
+// syncTime сравнивает время сервера с серединой интервала запроса, чтобы не учитывать задержку сети
+func (e *binanceExchange) syncTime(ctx context.Context) error {
+	e.timeMu.Lock()
+	defer e.timeMu.Unlock()
+
+	sent := time.Now()
+	serverTime, err := e.client.NewServerTimeService().Do(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка при получении времени биржи: %w", err)
+	}
+	received := time.Now()
+
+	roundTrip := received.Sub(sent)
+	offset := sent.Add(roundTrip/2).UnixMilli() - serverTime
+	if prev := e.timeOffset.Swap(offset); prev != offset {
+		logger.Log.Info(fmt.Sprintf("Смещение часов относительно биржи: %d мс (было %d мс, задержка %v)",
+			offset, prev, roundTrip))
+	}
+	return nil
+}
+
+func (bm *BianceManager) startTimeSync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := bm.SyncTime(ctx); err != nil {
+				logger.Log.Error(err)
+			}
+		}
+	}
+}
+
+// signedOptions параметры подписанных запросов go-binance
+func (e *binanceExchange) signedOptions() []binance.RequestOption {
+	if e.recvWindow <= 0 {
+		return nil
+	}
+	return []binance.RequestOption{binance.WithRecvWindow(e.recvWindow.Milliseconds())}
+}
+
+// retryOnTimestamp выполняет подписанный запрос call. Если биржа отклонила его из-за расхождения времени,
+// синхронизирует время и повторяет запрос один раз. Такой запрос биржа не выполняла, поэтому повтор безопасен
+func (e *binanceExchange) retryOnTimestamp(ctx context.Context, call func() error) error {
+	err := call()
+	if !isTimestampError(err) {
+		return err
+	}
+
+	logger.Log.Warn("Биржа отклонила запрос из-за расхождения времени, синхронизация и повтор: ", err)
+	if syncErr := e.syncTime(ctx); syncErr != nil {
+		logger.Log.Error(syncErr)
+		return err
+	}
+	return call()
+}
+
+// isTimestampError сообщает, что биржа отклонила запрос из-за метки времени вне recvWindow
+func isTimestampError(err error) bool {
+	var apiErr *common.APIError
+	return errors.As(err, &apiErr) && apiErr.Code == codeInvalidTimestamp
+}
@@ -0,0 +1,318 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+// Типы событий потока пользовательских данных
+const (
+	userEventExecutionReport  = "executionReport"
+	userEventAccountPosition  = "outboundAccountPosition"
+	userEventListenKeyExpired = "listenKeyExpired"
+)
+
+const (
+	// Биржа отправляет ping раз в несколько минут. Если за это время не пришло ничего, соединение считается потерянным
+	userStreamReadTimeout = 10 * time.Minute
+	userStreamWriteWait   = 10 * time.Second
+	// Пауза перед переподключением растет от минимальной до максимальной при подряд идущих ошибках
+	userStreamMinReconnectDelay = time.Second
+	userStreamMaxReconnectDelay = time.Minute
+	// Биржа закрывает listenKey через 60 минут без продления
+	userStreamDefaultKeepalive = 30 * time.Minute
+)
+
+var errListenKeyExpired = errors.New("срок действия listenKey истек")
+
+// userStreamHandler получатель событий потока пользовательских данных
+type userStreamHandler interface {
+	handleExecutionReport(report model.ExecutionReport)
+	handleAccountPosition(position model.AccountPosition)
+}
+
+// reconnectHandler получатель событий, которому нужно знать о переподключении потока: события за время
+// разрыва биржа не присылает повторно, и их восстанавливают по состоянию ордеров на бирже
+type reconnectHandler interface {
+	handleReconnect(ctx context.Context)
+}
+
+// UserStream поток пользовательских данных Binance. Получает listenKey, продлевает его каждые keepalive,
+// разбирает события executionReport и outboundAccountPosition. При обрыве соединения или истечении
+// listenKey получает новый ключ и подключается заново.
+type UserStream struct {
+	client *binance.Client
+	// url адрес websocket без listenKey, например wss://stream.binance.com:9443/ws
+	url       string
+	keepalive time.Duration
+	dialer    *websocket.Dialer
+	handler   userStreamHandler
+	// connected поток уже подключался: следующее подключение восстанавливает разрыв
+	connected bool
+}
+
+// NewUserStream создает поток пользовательских данных. listenKey запрашивается через client,
+// поэтому REST и websocket можно направить на локальную заглушку.
+func NewUserStream(client *binance.Client, url string, keepalive time.Duration, handler userStreamHandler) *UserStream {
+	if keepalive <= 0 {
+		keepalive = userStreamDefaultKeepalive
+	}
+	return &UserStream{
+		client:    client,
+		url:       strings.TrimRight(url, "/"),
+		keepalive: keepalive,
+		dialer:    websocket.DefaultDialer,
+		handler:   handler,
+	}
+}
+
+// Run обрабатывает события потока до отмены ctx, переподключаясь при ошибках
+func (s *UserStream) Run(ctx context.Context) {
+	delay := userStreamMinReconnectDelay
+	for {
+		startedAt := time.Now()
+		err := s.session(ctx)
+		if ctx.Err() != nil {
+			logger.Log.Info("Поток пользовательских данных остановлен")
+			return
+		}
+
+		// Соединение проработало долго, значит это не серия подряд идущих ошибок
+		if time.Since(startedAt) > userStreamMaxReconnectDelay {
+			delay = userStreamMinReconnectDelay
+		}
+		logger.Log.Warn(fmt.Sprintf("Поток пользовательских данных прерван: %v. Переподключение через %s", err, delay))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > userStreamMaxReconnectDelay {
+			delay = userStreamMaxReconnectDelay
+		}
+	}
+}
+
+// session получает listenKey, подключается к потоку и читает события до ошибки или отмены ctx
+func (s *UserStream) session(ctx context.Context) error {
+	listenKey, err := s.client.NewStartUserStreamService().Do(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка получения listenKey: %w", err)
+	}
+	defer s.closeListenKey(listenKey)
+
+	conn, _, err := s.dialer.DialContext(ctx, s.url+"/"+listenKey, nil)
+	if err != nil {
+		return fmt.Errorf("ошибка подключения к потоку пользовательских данных: %w", err)
+	}
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-sessionCtx.Done()
+		conn.Close()
+	}()
+	go s.keepListenKeyAlive(sessionCtx, cancel, listenKey)
+
+	conn.SetReadDeadline(time.Now().Add(userStreamReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(userStreamReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(userStreamWriteWait))
+	})
+
+	logger.Log.Info("Подключен поток пользовательских данных")
+	if handler, ok := s.handler.(reconnectHandler); ok && s.connected {
+		// Отчеты за время разрыва потеряны: получатель восстанавливает их, пока новые события читаются дальше
+		handler.handleReconnect(ctx)
+	}
+	s.connected = true
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if sessionCtx.Err() != nil {
+				return sessionCtx.Err()
+			}
+			return fmt.Errorf("ошибка чтения потока пользовательских данных: %w", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(userStreamReadTimeout))
+
+		if err := s.handleMessage(message); err != nil {
+			if errors.Is(err, errListenKeyExpired) {
+				return err
+			}
+			logger.Log.Error(fmt.Sprintf("Ошибка обработки события потока пользовательских данных: %v: %s", err, message))
+		}
+	}
+}
+
+// keepListenKeyAlive продлевает listenKey. Если продлить не удалось, завершает сессию через cancel,
+// чтобы переподключиться с новым ключом
+func (s *UserStream) keepListenKeyAlive(ctx context.Context, cancel context.CancelFunc, listenKey string) {
+	ticker := time.NewTicker(s.keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := s.client.NewKeepaliveUserStreamService().ListenKey(listenKey).Do(ctx)
+			if err != nil {
+				logger.Log.Error("Ошибка продления listenKey: ", err)
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// closeListenKey закрывает listenKey завершенной сессии
+func (s *UserStream) closeListenKey(listenKey string) {
+	ctx, cancel := context.WithTimeout(context.Background(), userStreamWriteWait)
+	defer cancel()
+	if err := s.client.NewCloseUserStreamService().ListenKey(listenKey).Do(ctx); err != nil {
+		logger.Log.Warn("Ошибка закрытия listenKey: ", err)
+	}
+}
+
+// userEvent общие поля событий. Поля e и E различаются только регистром, поэтому оба объявлены явно:
+// encoding/json сопоставляет ключи без учета регистра, если нет точного совпадения
+type userEvent struct {
+	Type string `json:"e"`
+	Time int64  `json:"E"`
+}
+
+// wsExecutionReport событие executionReport. Все ключи, различающиеся только регистром, объявлены явно
+type wsExecutionReport struct {
+	Type                string          `json:"e"`
+	Time                int64           `json:"E"`
+	Symbol              string          `json:"s"`
+	Side                string          `json:"S"`
+	ClientOrderID       string          `json:"c"`
+	OrigClientOrderID   string          `json:"C"`
+	OrderType           string          `json:"o"`
+	CreationTime        int64           `json:"O"`
+	TimeInForce         string          `json:"f"`
+	IcebergQty          decimal.Decimal `json:"F"`
+	Quantity            decimal.Decimal `json:"q"`
+	QuoteOrderQty       decimal.Decimal `json:"Q"`
+	Price               decimal.Decimal `json:"p"`
+	StopPrice           decimal.Decimal `json:"P"`
+	ExecutionType       string          `json:"x"`
+	Status              string          `json:"X"`
+	RejectReason        string          `json:"r"`
+	OrderID             int64           `json:"i"`
+	IgnoreI             int64           `json:"I"`
+	LastFilledQty       decimal.Decimal `json:"l"`
+	LastFilledPrice     decimal.Decimal `json:"L"`
+	CumulativeFilledQty decimal.Decimal `json:"z"`
+	CumulativeQuoteQty  decimal.Decimal `json:"Z"`
+	Commission          decimal.Decimal `json:"n"`
+	CommissionAsset     *string         `json:"N"`
+	TransactionTime     int64           `json:"T"`
+	TradeID             int64           `json:"t"`
+	IsWorking           bool            `json:"w"`
+	WorkingTime         int64           `json:"W"`
+	IsMaker             bool            `json:"m"`
+	IgnoreM             bool            `json:"M"`
+}
+
+// wsAccountPosition событие outboundAccountPosition
+type wsAccountPosition struct {
+	Type       string `json:"e"`
+	Time       int64  `json:"E"`
+	UpdateTime int64  `json:"u"`
+	Balances   []struct {
+		Asset  string          `json:"a"`
+		Free   decimal.Decimal `json:"f"`
+		Locked decimal.Decimal `json:"l"`
+	} `json:"B"`
+}
+
+// handleMessage разбирает событие и передает его обработчику. Остальные типы событий пропускаются
+func (s *UserStream) handleMessage(message []byte) error {
+	var event userEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case userEventExecutionReport:
+		var raw wsExecutionReport
+		if err := json.Unmarshal(message, &raw); err != nil {
+			return err
+		}
+		s.handler.handleExecutionReport(raw.report())
+
+	case userEventAccountPosition:
+		var raw wsAccountPosition
+		if err := json.Unmarshal(message, &raw); err != nil {
+			return err
+		}
+		position := model.AccountPosition{
+			EventTime:  time.UnixMilli(raw.Time).UTC(),
+			UpdateTime: time.UnixMilli(raw.UpdateTime).UTC(),
+		}
+		for _, balance := range raw.Balances {
+			position.Balances = append(position.Balances, model.Balance{
+				Asset:  balance.Asset,
+				Free:   balance.Free,
+				Locked: balance.Locked,
+			})
+		}
+		s.handler.handleAccountPosition(position)
+
+	case userEventListenKeyExpired:
+		return errListenKeyExpired
+	}
+	return nil
+}
+
+// report переводит событие в модель сервиса
+func (r wsExecutionReport) report() model.ExecutionReport {
+	report := model.ExecutionReport{
+		Symbol:              r.Symbol,
+		ClientOrderID:       r.ClientOrderID,
+		BinanceID:           r.OrderID,
+		Side:                r.Side,
+		Type:                r.OrderType,
+		TimeInForce:         r.TimeInForce,
+		Quantity:            r.Quantity,
+		Price:               r.Price,
+		StopPrice:           r.StopPrice,
+		ExecutionType:       r.ExecutionType,
+		Status:              exchangeStatus(binance.OrderStatusType(r.Status)),
+		TradeID:             r.TradeID,
+		LastFilledQty:       r.LastFilledQty,
+		LastFilledPrice:     r.LastFilledPrice,
+		IsMaker:             r.IsMaker,
+		Commission:          r.Commission,
+		CumulativeFilledQty: r.CumulativeFilledQty,
+		CumulativeQuoteQty:  r.CumulativeQuoteQty,
+		EventTime:           time.UnixMilli(r.Time).UTC(),
+		TransactionTime:     time.UnixMilli(r.TransactionTime).UTC(),
+	}
+	// При отмене в c приходит идентификатор запроса отмены, а идентификатор ордера в C
+	if r.OrigClientOrderID != "" {
+		report.ClientOrderID = r.OrigClientOrderID
+	}
+	if r.RejectReason != "NONE" {
+		report.RejectReason = r.RejectReason
+	}
+	if r.CommissionAsset != nil {
+		report.CommissionAsset = *r.CommissionAsset
+	}
+	return report
+}
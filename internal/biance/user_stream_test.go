@@ -0,0 +1,246 @@
+package biance
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/gorilla/websocket"
+	"github.com/shopspring/decimal"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewConsoleLogger()
+	os.Exit(m.Run())
+}
+
+// streamServer заглушка REST эндпоинтов listenKey и websocket потока пользовательских данных.
+// Сессии отправляют события из sessions по порядку: i-я сессия отправляет sessions[i] и, если она
+// не последняя, закрывает соединение после первого продления ключа
+type streamServer struct {
+	t        *testing.T
+	server   *httptest.Server
+	sessions [][]string
+
+	mu         sync.Mutex
+	started    int
+	keepalives map[string]int
+	closed     []string
+}
+
+func newStreamServer(t *testing.T, sessions ...[]string) *streamServer {
+	s := &streamServer{t: t, sessions: sessions, keepalives: make(map[string]int)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/userDataStream", s.listenKey)
+	mux.HandleFunc("/ws/", s.stream)
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *streamServer) listenKey(w http.ResponseWriter, r *http.Request) {
+	// Для DELETE ParseForm не читает тело, поэтому параметры разбираются явно
+	body, _ := io.ReadAll(r.Body)
+	params, _ := url.ParseQuery(string(body))
+	if params.Get("listenKey") == "" {
+		params = r.URL.Query()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPost:
+		s.started++
+		fmt.Fprintf(w, `{"listenKey":"key-%d"}`, s.started)
+		return
+	case http.MethodPut:
+		s.keepalives[params.Get("listenKey")]++
+	case http.MethodDelete:
+		s.closed = append(s.closed, params.Get("listenKey"))
+	}
+	fmt.Fprint(w, "{}")
+}
+
+func (s *streamServer) stream(w http.ResponseWriter, r *http.Request) {
+	listenKey := strings.TrimPrefix(r.URL.Path, "/ws/")
+	var session int
+	if _, err := fmt.Sscanf(listenKey, "key-%d", &session); err != nil || session > len(s.sessions) {
+		http.NotFound(w, r)
+		return
+	}
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		s.t.Error(err)
+		return
+	}
+	defer conn.Close()
+
+	for _, event := range s.sessions[session-1] {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(event)); err != nil {
+			return
+		}
+	}
+	if session == len(s.sessions) {
+		// Последняя сессия открыта, пока клиент не отключится
+		conn.ReadMessage()
+		return
+	}
+	// Обрыв соединения после продления ключа
+	for s.keepaliveCount(listenKey) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *streamServer) keepaliveCount(listenKey string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keepalives[listenKey]
+}
+
+// recordingHandler сохраняет события потока и переподключения
+type recordingHandler struct {
+	reports    chan model.ExecutionReport
+	positions  chan model.AccountPosition
+	reconnects chan struct{}
+}
+
+func (h *recordingHandler) handleExecutionReport(report model.ExecutionReport) {
+	h.reports <- report
+}
+
+func (h *recordingHandler) handleAccountPosition(position model.AccountPosition) {
+	h.positions <- position
+}
+
+func (h *recordingHandler) handleReconnect(ctx context.Context) {
+	h.reconnects <- struct{}{}
+}
+
+func receive[T any](t *testing.T, events chan T) T {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("событие не получено")
+	}
+	var zero T
+	return zero
+}
+
+const (
+	canceledReport = `{"e":"executionReport","E":1700000000100,"s":"BTCUSDT","c":"cancel-1","S":"BUY","o":"LIMIT",` +
+		`"f":"GTC","q":"1.00000000","p":"100.00000000","P":"0.00000000","F":"0.00000000","C":"order-1","x":"CANCELED",` +
+		`"X":"CANCELED","r":"NONE","i":42,"l":"0.00000000","z":"0.00000000","L":"0.00000000","n":"0","N":null,` +
+		`"T":1700000000099,"t":-1,"I":1,"w":false,"m":false,"M":false,"O":1700000000000,"Z":"0.00000000","Y":"0","Q":"0"}`
+	tradeReport = `{"e":"executionReport","E":1700000001100,"s":"BTCUSDT","c":"order-2","S":"SELL","o":"LIMIT",` +
+		`"f":"GTC","q":"2.00000000","p":"101.00000000","P":"0.00000000","F":"0.00000000","C":"","x":"TRADE",` +
+		`"X":"PARTIALLY_FILLED","r":"NONE","i":43,"l":"0.50000000","z":"1.50000000","L":"101.00000000","n":"0.01",` +
+		`"N":"BNB","T":1700000001099,"t":7,"I":2,"w":false,"m":true,"M":true,"O":1700000001000,"Z":"151.50000000",` +
+		`"Y":"50.5","Q":"0"}`
+	accountPosition = `{"e":"outboundAccountPosition","E":1700000000200,"u":1700000000199,` +
+		`"B":[{"a":"USDT","f":"1000.00000000","l":"0.00000000"},{"a":"BTC","f":"0.50000000","l":"1.00000000"}]}`
+)
+
+func TestUserStreamParsesEventsAndReconnects(t *testing.T) {
+	server := newStreamServer(t,
+		[]string{canceledReport, accountPosition, `{"e":"balanceUpdate","E":1700000000300}`},
+		[]string{tradeReport},
+	)
+	client := binance.NewClient("key", "secret")
+	client.BaseURL = server.server.URL
+	handler := &recordingHandler{
+		reports:    make(chan model.ExecutionReport, 10),
+		positions:  make(chan model.AccountPosition, 10),
+		reconnects: make(chan struct{}, 10),
+	}
+	stream := NewUserStream(client, "ws"+strings.TrimPrefix(server.server.URL, "http")+"/ws/", 50*time.Millisecond, handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stream.Run(ctx)
+	}()
+
+	// Отмена: идентификатор ордера берется из C, а не из идентификатора запроса отмены
+	report := receive(t, handler.reports)
+	if report.ClientOrderID != "order-1" || report.BinanceID != 42 || report.Status != model.StatusCanceled {
+		t.Fatalf("отчет об отмене разобран неверно: %+v", report)
+	}
+	if report.RejectReason != "" || report.CommissionAsset != "" || !report.Price.Equal(decimal.NewFromInt(100)) {
+		t.Fatalf("поля отчета об отмене разобраны неверно: %+v", report)
+	}
+	if !report.TransactionTime.Equal(time.UnixMilli(1700000000099)) {
+		t.Fatalf("время сделки %v", report.TransactionTime)
+	}
+
+	position := receive(t, handler.positions)
+	if len(position.Balances) != 2 || position.Balances[1].Asset != "BTC" ||
+		!position.Balances[1].Free.Equal(decimal.RequireFromString("0.5")) ||
+		!position.Balances[1].Locked.Equal(decimal.NewFromInt(1)) {
+		t.Fatalf("балансы разобраны неверно: %+v", position.Balances)
+	}
+
+	// После обрыва соединения поток получает новый listenKey, сообщает о переподключении для восстановления
+	// пропущенных отчетов и продолжает
+	receive(t, handler.reconnects)
+	report = receive(t, handler.reports)
+	if report.ClientOrderID != "order-2" || report.ExecutionType != executionTypeTrade ||
+		report.Status != model.StatusPartiallyFilled || report.TradeID != 7 || !report.IsMaker {
+		t.Fatalf("отчет о сделке разобран неверно: %+v", report)
+	}
+	if !report.LastFilledQty.Equal(decimal.RequireFromString("0.5")) ||
+		!report.CumulativeQuoteQty.Equal(decimal.RequireFromString("151.5")) ||
+		report.CommissionAsset != "BNB" {
+		t.Fatalf("исполнение разобрано неверно: %+v", report)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("поток не остановился после отмены контекста")
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.started != 2 {
+		t.Fatalf("listenKey получен %d раз, ожидалось 2", server.started)
+	}
+	if server.keepalives["key-1"] == 0 {
+		t.Fatal("listenKey не продлевался")
+	}
+	if len(server.closed) != 2 || server.closed[0] != "key-1" {
+		t.Fatalf("закрыты listenKey %v, ожидались key-1 и key-2", server.closed)
+	}
+	select {
+	case report := <-handler.reports:
+		t.Fatalf("лишний отчет: %+v", report)
+	case <-handler.reconnects:
+		t.Fatal("первое подключение принято за переподключение")
+	default:
+	}
+}
+
+func TestUserStreamStopsSessionOnExpiredListenKey(t *testing.T) {
+	stream := NewUserStream(nil, "", 0, &recordingHandler{})
+
+	err := stream.handleMessage([]byte(`{"e":"listenKeyExpired","E":1700000000000,"listenKey":"key-1"}`))
+	if err != errListenKeyExpired {
+		t.Fatalf("ошибка %v, ожидалось истечение listenKey", err)
+	}
+	if err := stream.handleMessage([]byte(`not json`)); err == nil {
+		t.Fatal("поврежденное событие разобрано без ошибки")
+	}
+}
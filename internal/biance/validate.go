@@ -0,0 +1,50 @@
+package biance
+
+import (
+	"app/internal/model"
+	"errors"
+	"fmt"
+)
+
+// validateOrder проверяет, что в команде заполнены поля, необходимые для ее действия
+func validateOrder(order model.Order) error {
+	if order.Symbol == "" {
+		return errors.New("не указан symbol")
+	}
+	if order.DryRun && order.Action != PlaceOrder {
+		return fmt.Errorf("dry_run поддерживается только для %s", PlaceOrder)
+	}
+
+	switch order.Action {
+	case PlaceOrder:
+		if err := validateSide(order.Side); err != nil {
+			return err
+		}
+		return validateOrderType(order)
+	case EditOrder:
+		if order.BinanceID == 0 {
+			return errors.New("не указан binance_id редактируемого ордера")
+		}
+		if err := validateSide(order.Side); err != nil {
+			return err
+		}
+		if mode := order.CancelReplaceMode; mode != "" && mode != CancelReplaceStopOnFailure && mode != CancelReplaceAllowFailure {
+			return fmt.Errorf("некорректный cancel_replace_mode: %q", mode)
+		}
+		return validateOrderType(order)
+	case CancelOrder:
+		if order.BinanceID == 0 {
+			return errors.New("не указан binance_id отменяемого ордера")
+		}
+	default:
+		return fmt.Errorf("неизвестное действие: %q", order.Action)
+	}
+	return nil
+}
+
+func validateSide(side string) error {
+	if side != "BUY" && side != "SELL" {
+		return fmt.Errorf("некорректная сторона сделки: %q", side)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package binancetest
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Коды ошибок Binance для сбоев из сценария
+const (
+	codeFilterFailure    = -1013
+	codeNewOrderRejected = -2010
+)
+
+// Fault сбой, который сервер возвращает на запросы вместо обычного ответа
+type Fault struct {
+	// Method и Path запросы, к которым применяется сбой. Пустое значение подходит к любому
+	Method string
+	Path   string
+	// Times сколько запросов затронет сбой. 0 — все последующие
+	Times int
+	// Latency задержка перед ответом
+	Latency time.Duration
+	// Status код HTTP ответа. 0 — после задержки запрос обрабатывается как обычно
+	Status int
+	// RetryAfter значение заголовка Retry-After
+	RetryAfter time.Duration
+	// Code и Message ошибка Binance в теле ответа. Если Code равен 0, тело пустое
+	Code    int64
+	Message string
+}
+
+// Latency задерживает ответы на запросы к path на delay
+func Latency(path string, delay time.Duration) Fault {
+	return Fault{Path: path, Latency: delay}
+}
+
+// ServerError отвечает на запросы к path ошибкой 500 без тела
+func ServerError(path string) Fault {
+	return Fault{Path: path, Status: http.StatusInternalServerError}
+}
+
+// TooManyRequests отвечает на запросы к path ошибкой 429 с Retry-After
+func TooManyRequests(path string, retryAfter time.Duration) Fault {
+	return Fault{
+		Path:       path,
+		Status:     http.StatusTooManyRequests,
+		RetryAfter: retryAfter,
+		Code:       -1003,
+		Message:    "Too many requests; current limit is exceeded.",
+	}
+}
+
+// InsufficientBalance отклоняет ордера ошибкой -2010 о недостатке баланса
+func InsufficientBalance() Fault {
+	return Fault{
+		Method:  http.MethodPost,
+		Path:    "/api/v3/order",
+		Status:  http.StatusBadRequest,
+		Code:    codeNewOrderRejected,
+		Message: "Account has insufficient balance for requested action.",
+	}
+}
+
+// FilterFailure отклоняет ордера ошибкой -1013 по фильтру filter, например LOT_SIZE
+func FilterFailure(filter string) Fault {
+	return Fault{
+		Method:  http.MethodPost,
+		Path:    "/api/v3/order",
+		Status:  http.StatusBadRequest,
+		Code:    codeFilterFailure,
+		Message: "Filter failure: " + filter,
+	}
+}
+
+// Repeat ограничивает сбой n запросами
+func (f Fault) Repeat(n int) Fault {
+	f.Times = n
+	return f
+}
+
+// Inject добавляет сбои в конец сценария. На запрос действует первый подходящий сбой
+func (s *Server) Inject(faults ...Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, fault := range faults {
+		s.faults = append(s.faults, &fault)
+	}
+}
+
+// ClearFaults удаляет все сбои из сценария
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// nextFault находит первый сбой для запроса и убирает его из сценария, если его запросы исчерпаны
+func (s *Server) nextFault(r *http.Request) *Fault {
+	for i, fault := range s.faults {
+		if (fault.Method != "" && fault.Method != r.Method) || (fault.Path != "" && fault.Path != r.URL.Path) {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+	return nil
+}
+
+// apply выполняет сбой. Возвращает true, если ответ уже отправлен
+func (f *Fault) apply(w http.ResponseWriter, r *http.Request) bool {
+	if f.Latency > 0 {
+		timer := time.NewTimer(f.Latency)
+		defer timer.Stop()
+		select {
+		case <-r.Context().Done():
+			return true
+		case <-timer.C:
+		}
+	}
+	if f.Status == 0 {
+		return false
+	}
+
+	if f.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((f.RetryAfter+time.Second-1)/time.Second)))
+	}
+	if f.Code == 0 {
+		w.WriteHeader(f.Status)
+		return true
+	}
+	writeError(w, f.Status, f.Code, f.Message)
+	return true
+}
@@ -0,0 +1,338 @@
+package binancetest
+
+import (
+	"app/internal/biance"
+	"app/internal/model"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/adshao/go-binance/v2/common"
+	"github.com/shopspring/decimal"
+)
+
+// Коды ошибок Binance, когда cancel-replace выполнен частично или не выполнен
+const (
+	codeCancelReplaceFailed         = -2021
+	codeCancelReplacePartialSuccess = -2022
+)
+
+// codeCancelRejected ошибка Binance: отменяемый ордер не найден
+const codeCancelRejected = -2011
+
+type serverTimeResponse struct {
+	ServerTime int64 `json:"serverTime"`
+}
+
+func (s *Server) ping(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, struct{}{})
+}
+
+func (s *Server) serverTime(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, serverTimeResponse{ServerTime: s.now().UnixMilli()})
+}
+
+func (s *Server) exchangeInfo(w http.ResponseWriter, r *http.Request) {
+	info, err := s.exchange.ExchangeInfo(r.Context())
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+	s.mu.Lock()
+	info.RateLimits = s.rateLimits
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) account(w http.ResponseWriter, r *http.Request, params url.Values) {
+	balances, err := s.exchange.Balances(r.Context())
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, binance.Account{
+		CanTrade:    true,
+		UpdateTime:  uint64(s.now().UnixMilli()),
+		AccountType: "SPOT",
+		Balances:    balances,
+		Permissions: []string{"SPOT"},
+	})
+}
+
+func (s *Server) placeOrder(w http.ResponseWriter, r *http.Request, params url.Values) {
+	order, ok := parseOrder(w, params)
+	if !ok {
+		return
+	}
+	resp, err := s.exchange.PlaceOrder(r.Context(), order)
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// commissionRates ставки комиссии в ответе проверки ордера
+type commissionRates struct {
+	Maker string `json:"maker"`
+	Taker string `json:"taker"`
+}
+
+type testOrderResponse struct {
+	StandardCommissionForOrder commissionRates `json:"standardCommissionForOrder"`
+	TaxCommissionForOrder      commissionRates `json:"taxCommissionForOrder"`
+	Discount                   struct {
+		EnabledForAccount bool   `json:"enabledForAccount"`
+		EnabledForSymbol  bool   `json:"enabledForSymbol"`
+		DiscountAsset     string `json:"discountAsset"`
+		Discount          string `json:"discount"`
+	} `json:"discount"`
+}
+
+func (s *Server) testOrder(w http.ResponseWriter, r *http.Request, params url.Values) {
+	order, ok := parseOrder(w, params)
+	if !ok {
+		return
+	}
+	order.ComputeCommissionRates = params.Get("computeCommissionRates") == "true"
+	rates, err := s.exchange.TestOrder(r.Context(), order)
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+	if rates == nil {
+		writeJSON(w, http.StatusOK, struct{}{})
+		return
+	}
+
+	resp := testOrderResponse{
+		StandardCommissionForOrder: commissionRates{Maker: rates.Maker.String(), Taker: rates.Taker.String()},
+		TaxCommissionForOrder:      commissionRates{Maker: rates.TaxMaker.String(), Taker: rates.TaxTaker.String()},
+	}
+	resp.Discount.DiscountAsset = "BNB"
+	resp.Discount.Discount = "0"
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) queryOrder(w http.ResponseWriter, r *http.Request, params url.Values) {
+	symbol, binanceID, clientOrderID, ok := orderKey(w, params, "orderId", "origClientOrderId")
+	if !ok {
+		return
+	}
+	order, err := s.exchange.QueryOrder(r.Context(), symbol, binanceID, clientOrderID)
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+func (s *Server) cancelOrder(w http.ResponseWriter, r *http.Request, params url.Values) {
+	symbol, binanceID, clientOrderID, ok := orderKey(w, params, "orderId", "origClientOrderId")
+	if !ok {
+		return
+	}
+	if binanceID == 0 {
+		order, err := s.exchange.QueryOrder(r.Context(), symbol, 0, clientOrderID)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeCancelRejected, "Unknown order sent.")
+			return
+		}
+		binanceID = order.OrderID
+	}
+	resp, err := s.exchange.CancelOrder(r.Context(), model.Order{Symbol: symbol, BinanceID: binanceID})
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) openOrders(w http.ResponseWriter, r *http.Request, params url.Values) {
+	orders, err := s.exchange.OpenOrders(r.Context(), params.Get("symbol"))
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+// cancelReplaceResponse ответ cancel-replace. При неудаче хотя бы одной части он лежит в поле data ошибки
+type cancelReplaceResponse struct {
+	CancelResult     string      `json:"cancelResult"`
+	NewOrderResult   string      `json:"newOrderResult"`
+	CancelResponse   interface{} `json:"cancelResponse"`
+	NewOrderResponse interface{} `json:"newOrderResponse"`
+}
+
+type cancelReplaceError struct {
+	apiError
+	Data cancelReplaceResponse `json:"data"`
+}
+
+func (s *Server) cancelReplace(w http.ResponseWriter, r *http.Request, params url.Values) {
+	order, ok := parseOrder(w, params)
+	if !ok {
+		return
+	}
+	mode := params.Get("cancelReplaceMode")
+	if mode != biance.CancelReplaceStopOnFailure && mode != biance.CancelReplaceAllowFailure {
+		writeError(w, http.StatusBadRequest, codeMandatoryParam,
+			"Mandatory parameter 'cancelReplaceMode' was not sent, was empty/null, or malformed.")
+		return
+	}
+	order.CancelReplaceMode = mode
+	if order.BinanceID, ok = parseInt(w, params, "cancelOrderId"); !ok {
+		return
+	}
+
+	result, err := s.exchange.EditOrder(r.Context(), order)
+	if err != nil {
+		writeExchangeError(w, err)
+		return
+	}
+
+	resp := cancelReplaceResponse{
+		CancelResult:     result.CancelResult,
+		NewOrderResult:   result.NewOrderResult,
+		CancelResponse:   partResponse(result.CancelErr),
+		NewOrderResponse: partResponse(result.NewOrderErr),
+	}
+	if result.CancelErr == nil {
+		// Отмененный ордер отдается в том же виде, что и ответ на отмену
+		if canceled, err := s.exchange.QueryOrder(r.Context(), order.Symbol, order.BinanceID, ""); err == nil {
+			resp.CancelResponse = cancelResponse(canceled)
+		}
+	}
+	if result.NewOrder != nil {
+		resp.NewOrderResponse = result.NewOrder
+	}
+
+	switch {
+	case result.CancelErr == nil && result.NewOrderErr == nil:
+		writeJSON(w, http.StatusOK, resp)
+	case result.CancelErr != nil && result.NewOrderErr != nil:
+		writeJSON(w, http.StatusBadRequest, cancelReplaceError{
+			apiError: apiError{Code: codeCancelReplaceFailed, Message: "Order cancel-replace failed."},
+			Data:     resp,
+		})
+	default:
+		writeJSON(w, http.StatusConflict, cancelReplaceError{
+			apiError: apiError{Code: codeCancelReplacePartialSuccess, Message: "Order cancel-replace partially failed."},
+			Data:     resp,
+		})
+	}
+}
+
+// partResponse тело не выполненной части cancel-replace: ошибка биржи или null, если часть не выполнялась
+func partResponse(err error) interface{} {
+	if err == nil {
+		return nil
+	}
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) {
+		return apiError{Code: apiErr.Code, Message: apiErr.Message}
+	}
+	return nil
+}
+
+func cancelResponse(order *binance.Order) *binance.CancelOrderResponse {
+	return &binance.CancelOrderResponse{
+		Symbol:                   order.Symbol,
+		OrigClientOrderID:        order.ClientOrderID,
+		OrderID:                  order.OrderID,
+		OrderListID:              -1,
+		ClientOrderID:            order.ClientOrderID,
+		TransactTime:             order.UpdateTime,
+		Price:                    order.Price,
+		OrigQuantity:             order.OrigQuantity,
+		ExecutedQuantity:         order.ExecutedQuantity,
+		CummulativeQuoteQuantity: order.CummulativeQuoteQuantity,
+		Status:                   order.Status,
+		TimeInForce:              order.TimeInForce,
+		Type:                     order.Type,
+		Side:                     order.Side,
+	}
+}
+
+// parseOrder разбирает параметры нового ордера
+func parseOrder(w http.ResponseWriter, params url.Values) (model.Order, bool) {
+	order := model.Order{
+		Symbol:        params.Get("symbol"),
+		Side:          params.Get("side"),
+		Type:          params.Get("type"),
+		TimeInForce:   params.Get("timeInForce"),
+		ClientOrderID: params.Get("newClientOrderId"),
+	}
+	for _, name := range []string{"symbol", "side", "type"} {
+		if params.Get(name) == "" {
+			writeError(w, http.StatusBadRequest, codeMandatoryParam,
+				"Mandatory parameter '"+name+"' was not sent, was empty/null, or malformed.")
+			return order, false
+		}
+	}
+
+	fields := map[string]*decimal.Decimal{
+		"quantity":      &order.Quantity,
+		"quoteOrderQty": &order.QuoteOrderQty,
+		"price":         &order.Price,
+		"stopPrice":     &order.StopPrice,
+		"icebergQty":    &order.IcebergQty,
+	}
+	for name, field := range fields {
+		value := params.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := decimal.NewFromString(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeIllegalChars, "Illegal characters found in parameter '"+name+"'; legal range is '^([0-9]{1,20})(\\.[0-9]{1,20})?$'.")
+			return order, false
+		}
+		*field = parsed
+	}
+	return order, true
+}
+
+// orderKey разбирает символ и идентификатор ордера: по бирже из idParam или клиентский из clientIDParam
+func orderKey(w http.ResponseWriter, params url.Values, idParam, clientIDParam string) (string, int64, string, bool) {
+	symbol := params.Get("symbol")
+	if symbol == "" {
+		writeError(w, http.StatusBadRequest, codeMandatoryParam,
+			"Mandatory parameter 'symbol' was not sent, was empty/null, or malformed.")
+		return "", 0, "", false
+	}
+	clientOrderID := params.Get(clientIDParam)
+	if params.Get(idParam) == "" {
+		if clientOrderID == "" {
+			writeError(w, http.StatusBadRequest, codeMandatoryParam,
+				"Param '"+idParam+"' or '"+clientIDParam+"' must be sent, but both were empty/null!")
+			return "", 0, "", false
+		}
+		return symbol, 0, clientOrderID, true
+	}
+	binanceID, ok := parseInt(w, params, idParam)
+	return symbol, binanceID, clientOrderID, ok
+}
+
+func parseInt(w http.ResponseWriter, params url.Values, name string) (int64, bool) {
+	value, err := strconv.ParseInt(params.Get(name), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeMandatoryParam,
+			"Mandatory parameter '"+name+"' was not sent, was empty/null, or malformed.")
+		return 0, false
+	}
+	return value, true
+}
+
+// writeExchangeError отдает ошибку симулятора так же, как биржа: ошибки Binance с кодом 400, остальные с 500
+func writeExchangeError(w http.ResponseWriter, err error) {
+	var apiErr *common.APIError
+	if errors.As(err, &apiErr) {
+		writeError(w, http.StatusBadRequest, apiErr.Code, apiErr.Message)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, codeUnknown, err.Error())
+}
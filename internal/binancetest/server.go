@@ -0,0 +1,262 @@
+// Package binancetest заглушка REST API Binance для интеграционных тестов. Сервер принимает те же запросы,
+// что и биржа, проверяет подпись HMAC и recvWindow, исполняет ордера на biance.Simulator и по сценарию
+// возвращает сбои: задержки, 5xx, 429 с Retry-After и ошибки биржи.
+package binancetest
+
+import (
+	"app/internal/biance"
+	"app/internal/logger"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/gorilla/mux"
+)
+
+// defaultRecvWindow срок действия подписанного запроса, если recvWindow не передан
+const defaultRecvWindow = 5000
+
+// maxRecvWindow наибольший допустимый recvWindow в миллисекундах
+const maxRecvWindow = 60000
+
+// Коды ошибок Binance, которые сервер возвращает сам
+const (
+	codeUnknown          = -1000
+	codeInvalidTimestamp = -1021
+	codeInvalidSignature = -1022
+	codeIllegalChars     = -1100
+	codeMandatoryParam   = -1102
+	codeBadRecvWindow    = -1131
+	codeRejectedMbxKey   = -2015
+)
+
+// Server заглушка Binance на httptest.Server. URL передается в biance.NewBianceManager вместо адреса биржи
+type Server struct {
+	// URL адрес сервера
+	URL string
+
+	apiKey    string
+	secretKey string
+	exchange  *biance.Simulator
+	server    *httptest.Server
+
+	mu sync.Mutex
+	// clockOffset смещение часов сервера относительно локальных
+	clockOffset time.Duration
+	// rateLimits ограничения для exchangeInfo
+	rateLimits []binance.RateLimit
+	// faults сценарий сбоев, проверяется по порядку
+	faults []*Fault
+	// requests число запросов по "МЕТОД путь"
+	requests map[string]int
+	// weightWindow начало текущей минуты и число запросов в ней для заголовка X-MBX-USED-WEIGHT-1M
+	weightWindow time.Time
+	weightUsed   int
+}
+
+// NewServer запускает сервер, который принимает ключи apiKey и secretKey и исполняет ордера на exchange
+func NewServer(apiKey, secretKey string, exchange *biance.Simulator) *Server {
+	s := Server{
+		apiKey:    apiKey,
+		secretKey: secretKey,
+		exchange:  exchange,
+		requests:  make(map[string]int),
+	}
+	s.server = httptest.NewServer(s.routes())
+	s.URL = s.server.URL
+	return &s
+}
+
+// Close останавливает сервер
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Exchange симулятор, на котором исполняются ордера. Через него задаются цены
+func (s *Server) Exchange() *biance.Simulator {
+	return s.exchange
+}
+
+// SetClockOffset сдвигает часы сервера на offset относительно локальных, чтобы проверить синхронизацию времени
+func (s *Server) SetClockOffset(offset time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clockOffset = offset
+}
+
+// SetRateLimits задает ограничения, которые отдает exchangeInfo. По умолчанию список пустой
+func (s *Server) SetRateLimits(rateLimits []binance.RateLimit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimits = rateLimits
+}
+
+// Requests число запросов method к пути path, включая отклоненные
+func (s *Server) Requests(method, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[method+" "+path]
+}
+
+func (s *Server) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Add(s.clockOffset)
+}
+
+func (s *Server) routes() http.Handler {
+	router := mux.NewRouter()
+	router.Use(s.middleware)
+	router.HandleFunc("/api/v3/ping", s.ping).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/time", s.serverTime).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/exchangeInfo", s.exchangeInfo).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/account", s.signed(s.account)).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/order", s.signed(s.placeOrder)).Methods(http.MethodPost)
+	router.HandleFunc("/api/v3/order", s.signed(s.queryOrder)).Methods(http.MethodGet)
+	router.HandleFunc("/api/v3/order", s.signed(s.cancelOrder)).Methods(http.MethodDelete)
+	router.HandleFunc("/api/v3/order/test", s.signed(s.testOrder)).Methods(http.MethodPost)
+	router.HandleFunc("/api/v3/order/cancelReplace", s.signed(s.cancelReplace)).Methods(http.MethodPost)
+	router.HandleFunc("/api/v3/openOrders", s.signed(s.openOrders)).Methods(http.MethodGet)
+	return router
+}
+
+// middleware считает запросы, отдает расход веса в заголовке и применяет сбои из сценария
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fault, used := s.track(r)
+		w.Header().Set("X-MBX-USED-WEIGHT-1M", strconv.Itoa(used))
+		if fault != nil && fault.apply(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// track учитывает запрос и возвращает сбой, который к нему применяется
+func (s *Server) track(r *http.Request) (*Fault, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests[r.Method+" "+r.URL.Path]++
+	window := time.Now().Add(s.clockOffset).Truncate(time.Minute)
+	if !window.Equal(s.weightWindow) {
+		s.weightWindow = window
+		s.weightUsed = 0
+	}
+	s.weightUsed++
+	return s.nextFault(r), s.weightUsed
+}
+
+// signed проверяет ключ API, подпись и recvWindow подписанного запроса и передает handler его параметры
+func (s *Server) signed(handler func(w http.ResponseWriter, r *http.Request, params url.Values)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-MBX-APIKEY") != s.apiKey {
+			writeError(w, http.StatusUnauthorized, codeRejectedMbxKey, "Invalid API-key, IP, or permissions for action.")
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeUnknown, err.Error())
+			return
+		}
+		query, signature := splitSignature(r.URL.RawQuery)
+		mac := hmac.New(sha256.New, []byte(s.secretKey))
+		mac.Write([]byte(query + string(body)))
+		if !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+			writeError(w, http.StatusBadRequest, codeInvalidSignature, "Signature for this request is not valid.")
+			return
+		}
+
+		params, err := url.ParseQuery(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeIllegalChars, "Illegal characters found in a parameter.")
+			return
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeIllegalChars, "Illegal characters found in a parameter.")
+			return
+		}
+		for key, values := range form {
+			params[key] = append(params[key], values...)
+		}
+
+		if !s.checkTimestamp(w, params) {
+			return
+		}
+		handler(w, r, params)
+	}
+}
+
+// checkTimestamp проверяет, что запрос отправлен не раньше recvWindow и не позже чем через секунду по часам сервера
+func (s *Server) checkTimestamp(w http.ResponseWriter, params url.Values) bool {
+	recvWindow := int64(defaultRecvWindow)
+	if value := params.Get("recvWindow"); value != "" {
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || parsed <= 0 || parsed > maxRecvWindow {
+			writeError(w, http.StatusBadRequest, codeBadRecvWindow, "recvWindow must be less than 60000")
+			return false
+		}
+		recvWindow = parsed
+	}
+
+	timestamp, err := strconv.ParseInt(params.Get("timestamp"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeMandatoryParam,
+			"Mandatory parameter 'timestamp' was not sent, was empty/null, or malformed.")
+		return false
+	}
+	serverTime := s.now().UnixMilli()
+	if timestamp >= serverTime+1000 {
+		writeError(w, http.StatusBadRequest, codeInvalidTimestamp, "Timestamp for this request was 1000ms ahead of the server's time.")
+		return false
+	}
+	if serverTime-timestamp > recvWindow {
+		writeError(w, http.StatusBadRequest, codeInvalidTimestamp, "Timestamp for this request is outside of the recvWindow.")
+		return false
+	}
+	return true
+}
+
+// splitSignature отделяет параметр signature от остальной строки запроса, по которой считается подпись
+func splitSignature(rawQuery string) (query, signature string) {
+	parts := strings.Split(rawQuery, "&")
+	rest := parts[:0]
+	for _, part := range parts {
+		if value, ok := strings.CutPrefix(part, "signature="); ok {
+			signature = value
+			continue
+		}
+		rest = append(rest, part)
+	}
+	return strings.Join(rest, "&"), signature
+}
+
+// apiError тело ответа с ошибкой Binance
+type apiError struct {
+	Code    int64  `json:"code"`
+	Message string `json:"msg"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Log.Error("Ошибка при отправке ответа: ", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code int64, message string) {
+	writeJSON(w, status, apiError{Code: code, Message: message})
+}
@@ -0,0 +1,168 @@
+package binancetest_test
+
+import (
+	"app/internal/biance"
+	"app/internal/binancetest"
+	"app/internal/logger"
+	"app/internal/model"
+	"context"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adshao/go-binance/v2"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	apiKey    = "test-key"
+	secretKey = "test-secret"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewConsoleLogger()
+	os.Exit(m.Run())
+}
+
+// newServer запускает заглушку с символом BTCUSDT по цене 100 и балансом 1000 USDT
+func newServer(t *testing.T) *binancetest.Server {
+	t.Helper()
+	sim := biance.NewSimulator(biance.SimulatorConfig{
+		Symbols:  []binance.Symbol{biance.NewSimulatorSymbol("BTCUSDT", "BTC", "USDT", "0.01", "0.001", "10")},
+		Balances: map[string]decimal.Decimal{"USDT": decimal.NewFromInt(1000)},
+	})
+	if err := sim.SetPrice("BTCUSDT", decimal.NewFromInt(100)); err != nil {
+		t.Fatal(err)
+	}
+	server := binancetest.NewServer(apiKey, secretKey, sim)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newManager(t *testing.T, server *binancetest.Server, secret string) *biance.BianceManager {
+	t.Helper()
+	bm, err := biance.NewBianceManager(server.URL, apiKey, secret, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bm
+}
+
+func placeOrder(clientOrderID string) model.Order {
+	return model.Order{
+		Action:        biance.PlaceOrder,
+		ClientOrderID: clientOrderID,
+		Symbol:        "BTCUSDT",
+		Side:          string(binance.SideTypeBuy),
+		Type:          string(binance.OrderTypeLimit),
+		TimeInForce:   string(binance.TimeInForceTypeGTC),
+		Quantity:      decimal.NewFromInt(1),
+		Price:         decimal.NewFromInt(90),
+	}
+}
+
+func assertRejected(t *testing.T, result model.Order, code string) {
+	t.Helper()
+	if result.Status != model.StatusRejected || result.Retryable {
+		t.Fatalf("статус %s, повтор %v, ожидался REJECTED без повтора: %s", result.Status, result.Retryable, result.Error)
+	}
+	if !strings.Contains(result.Error, "code="+code) {
+		t.Fatalf("ошибка %q, ожидался код %s", result.Error, code)
+	}
+}
+
+func TestPlaceOrder(t *testing.T) {
+	server := newServer(t)
+	bm := newManager(t, server, secretKey)
+
+	result := bm.Execute(placeOrder("place-1"))
+	if result.Status != model.StatusNew || result.BinanceID == 0 {
+		t.Fatalf("статус %s, BinanceID %d, ожидался размещенный ордер: %s", result.Status, result.BinanceID, result.Error)
+	}
+	if n := server.Requests(http.MethodPost, "/api/v3/order"); n != 1 {
+		t.Fatalf("запросов на размещение %d, ожидался 1", n)
+	}
+}
+
+func TestRejectsInvalidSignature(t *testing.T) {
+	server := newServer(t)
+	bm := newManager(t, server, "wrong-secret")
+
+	assertRejected(t, bm.Execute(placeOrder("signature-1")), "-1022")
+}
+
+func TestRejectsRecvWindow(t *testing.T) {
+	server := newServer(t)
+	bm := newManager(t, server, secretKey)
+	if err := bm.EnableTimeSync(context.Background(), time.Hour, 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	assertRejected(t, bm.Execute(placeOrder("recv-window-1")), "-1131")
+}
+
+func TestResyncsClockAfterInvalidTimestamp(t *testing.T) {
+	server := newServer(t)
+	bm := newManager(t, server, secretKey)
+	// Часы сервера отстают: метка времени запроса для него из будущего
+	server.SetClockOffset(-30 * time.Second)
+
+	result := bm.Execute(placeOrder("timestamp-1"))
+	if result.Status != model.StatusNew {
+		t.Fatalf("статус %s, ожидался NEW после синхронизации времени: %s", result.Status, result.Error)
+	}
+	if n := server.Requests(http.MethodPost, "/api/v3/order"); n != 2 {
+		t.Fatalf("запросов на размещение %d, ожидалось 2: отклоненный и повтор", n)
+	}
+	if n := server.Requests(http.MethodGet, "/api/v3/time"); n != 1 {
+		t.Fatalf("запросов времени %d, ожидался 1", n)
+	}
+}
+
+func TestTooManyRequestsTripsBreaker(t *testing.T) {
+	server := newServer(t)
+	bm := newManager(t, server, secretKey)
+	server.Inject(binancetest.TooManyRequests("/api/v3/order", time.Second).Repeat(1))
+
+	started := time.Now()
+	result := bm.Execute(placeOrder("limited-1"))
+	if result.Status != model.StatusFailed || !result.Retryable {
+		t.Fatalf("статус %s, повтор %v, ожидалась временная ошибка FAILED: %s", result.Status, result.Retryable, result.Error)
+	}
+
+	// Следующий запрос ждет Retry-After и проверки ping, а не уходит на биржу сразу
+	result = bm.Execute(placeOrder("limited-2"))
+	if result.Status != model.StatusNew {
+		t.Fatalf("статус %s, ожидался NEW после паузы: %s", result.Status, result.Error)
+	}
+	if elapsed := time.Since(started); elapsed < 900*time.Millisecond {
+		t.Fatalf("запрос отправлен через %v, раньше Retry-After", elapsed)
+	}
+	if n := server.Requests(http.MethodGet, "/api/v3/ping"); n == 0 {
+		t.Fatal("перед возобновлением биржа не проверена через ping")
+	}
+	if n := server.Requests(http.MethodPost, "/api/v3/order"); n != 2 {
+		t.Fatalf("запросов на размещение %d, ожидалось 2", n)
+	}
+}
+
+func TestDecodesExchangeRejects(t *testing.T) {
+	server := newServer(t)
+	bm := newManager(t, server, secretKey)
+
+	server.Inject(binancetest.InsufficientBalance().Repeat(1))
+	result := bm.Execute(placeOrder("reject-1"))
+	assertRejected(t, result, "-2010")
+	if !strings.Contains(result.Error, "insufficient balance") {
+		t.Fatalf("ошибка %q без сообщения биржи", result.Error)
+	}
+
+	server.Inject(binancetest.FilterFailure("LOT_SIZE").Repeat(1))
+	result = bm.Execute(placeOrder("reject-2"))
+	assertRejected(t, result, "-1013")
+	if !strings.Contains(result.Error, "LOT_SIZE") {
+		t.Fatalf("ошибка %q без имени фильтра", result.Error)
+	}
+}
@@ -0,0 +1,196 @@
+package dedupe
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// entry запись хранилища: результат выполнения команды и время его сохранения
+type entry struct {
+	Order   model.Order `json:"order"`
+	SavedAt time.Time   `json:"saved_at"`
+}
+
+// Store хранилище результатов выполненных команд по ClientOrderID. Позволяет узнать повторно доставленную
+// команду и вернуть исходный результат вместо повторного размещения ордера. Записи дописываются в файл,
+// поэтому переживают перезапуск сервиса. Записи старше ttl отбрасываются при загрузке и при очистке,
+// которая выполняется при сохранении не чаще раза в ttl.
+type Store struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	ttl     time.Duration
+	results map[string]entry
+	// sweptAt время последней очистки устаревших записей
+	sweptAt time.Time
+}
+
+// NewStore открывает хранилище в файле path. Если path пустой, результаты хранятся только в памяти.
+func NewStore(path string, ttl time.Duration) (*Store, error) {
+	store := Store{
+		path:    path,
+		ttl:     ttl,
+		results: make(map[string]entry),
+		sweptAt: time.Now(),
+	}
+	if path == "" {
+		return &store, nil
+	}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+// Get возвращает сохраненный результат команды с идентификатором clientOrderID
+func (s *Store) Get(clientOrderID string) (model.Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.results[clientOrderID]
+	if !ok || s.expired(e) {
+		return model.Order{}, false
+	}
+	return e.Order, true
+}
+
+// Save сохраняет результат выполнения команды
+func (s *Store) Save(order model.Order) error {
+	if order.ClientOrderID == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep()
+
+	e := entry{Order: order, SavedAt: time.Now().UTC()}
+	s.results[order.ClientOrderID] = e
+	if s.file == nil {
+		return nil
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("ошибка записи в хранилище повторов: %v", err)
+	}
+	return s.file.Sync()
+}
+
+// Close закрывает файл хранилища
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// sweep раз в ttl удаляет устаревшие записи из памяти и сжимает файл хранилища, чтобы он не рос
+// все время работы сервиса. Ошибка сжатия не мешает сохранению: записи дописываются в прежний файл
+func (s *Store) sweep() {
+	if s.ttl <= 0 || time.Since(s.sweptAt) < s.ttl {
+		return
+	}
+	s.sweptAt = time.Now()
+
+	if s.path == "" {
+		for id, e := range s.results {
+			if s.expired(e) {
+				delete(s.results, id)
+			}
+		}
+		return
+	}
+	if err := s.compact(); err != nil {
+		logger.Log.Error("Ошибка сжатия хранилища повторов: ", err)
+	}
+}
+
+func (s *Store) expired(e entry) bool {
+	return s.ttl > 0 && time.Since(e.SavedAt) > s.ttl
+}
+
+// load читает записи из файла хранилища
+func (s *Store) load() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка открытия хранилища повторов: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			// Последняя строка может быть записана не полностью при аварийном завершении
+			logger.Log.Warn("Пропущена поврежденная запись хранилища повторов: ", err)
+			continue
+		}
+		s.results[e.Order.ClientOrderID] = e
+	}
+	return scanner.Err()
+}
+
+// compact перезаписывает файл хранилища без устаревших записей и открывает его для дозаписи.
+// Ранее открытый файл закрывается только после успешной замены
+func (s *Store) compact() error {
+	tmpPath := s.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("ошибка создания хранилища повторов: %v", err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	for id, e := range s.results {
+		if s.expired(e) {
+			delete(s.results, id)
+			continue
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("ошибка замены файла хранилища повторов: %v", err)
+	}
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия хранилища повторов: %v", err)
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file = file
+	return nil
+}
@@ -0,0 +1,177 @@
+package dedupe
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewConsoleLogger()
+	os.Exit(m.Run())
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines
+}
+
+func TestStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedupe.jsonl")
+	s, err := NewStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(model.Order{ClientOrderID: "order-1", BinanceID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(model.Order{ClientOrderID: "order-1", BinanceID: 2}); err != nil {
+		t.Fatal(err)
+	}
+	// Команды без идентификатора не сохраняются
+	if err := s.Save(model.Order{BinanceID: 3}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// Дописываем поврежденную строку, как после аварийного завершения
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"order":{"client_order_id":`)
+	file.Close()
+
+	reopened, err := NewStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	order, ok := reopened.Get("order-1")
+	if !ok || order.BinanceID != 2 {
+		t.Fatalf("после перезапуска найден %v, ордер %d, ожидался последний результат 2", ok, order.BinanceID)
+	}
+	// При открытии файл сжимается до одной записи на команду
+	if lines := countLines(t, path); lines != 1 {
+		t.Fatalf("в файле %d записей, ожидалась 1", lines)
+	}
+}
+
+func TestStoreExpiresResults(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"в памяти", ""},
+		{"в файле", filepath.Join(t.TempDir(), "dedupe.jsonl")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewStore(tt.path, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			if err := s.Save(model.Order{ClientOrderID: "old"}); err != nil {
+				t.Fatal(err)
+			}
+			s.results["old"] = entry{Order: s.results["old"].Order, SavedAt: time.Now().Add(-2 * time.Hour)}
+			if _, ok := s.Get("old"); ok {
+				t.Fatal("устаревший результат возвращен")
+			}
+
+			// Очистка не выполняется чаще раза в ttl
+			if err := s.Save(model.Order{ClientOrderID: "fresh"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := s.results["old"]; !ok {
+				t.Fatal("очистка выполнена раньше ttl")
+			}
+
+			s.sweptAt = time.Now().Add(-2 * time.Hour)
+			if err := s.Save(model.Order{ClientOrderID: "next"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := s.results["old"]; ok {
+				t.Fatal("устаревший результат не удален очисткой")
+			}
+			for _, id := range []string{"fresh", "next"} {
+				if _, ok := s.Get(id); !ok {
+					t.Fatalf("результат %s потерян при очистке", id)
+				}
+			}
+			if tt.path == "" {
+				return
+			}
+			if lines := countLines(t, tt.path); lines != 2 {
+				t.Fatalf("в файле после сжатия %d записей, ожидалось 2", lines)
+			}
+
+			// Сжатый файл по-прежнему открыт для дозаписи
+			if err := s.Save(model.Order{ClientOrderID: "after"}); err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+			reopened, err := NewStore(tt.path, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+			if _, ok := reopened.Get("after"); !ok {
+				t.Fatal("запись после сжатия потеряна")
+			}
+			if _, ok := reopened.Get("old"); ok {
+				t.Fatal("устаревший результат вернулся после перезапуска")
+			}
+		})
+	}
+}
+
+func TestStoreDropsExpiredOnLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedupe.jsonl")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []entry{
+		{Order: model.Order{ClientOrderID: "old"}, SavedAt: time.Now().Add(-2 * time.Hour)},
+		{Order: model.Order{ClientOrderID: "fresh"}, SavedAt: time.Now()},
+	} {
+		line, err := json.Marshal(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(append(line, '\n'))
+	}
+	file.Close()
+
+	s, err := NewStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok := s.Get("old"); ok {
+		t.Fatal("устаревший результат загружен")
+	}
+	if _, ok := s.Get("fresh"); !ok {
+		t.Fatal("актуальный результат не загружен")
+	}
+	if lines := countLines(t, path); lines != 1 {
+		t.Fatalf("в файле после загрузки %d записей, ожидалась 1", lines)
+	}
+}
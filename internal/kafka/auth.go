@@ -0,0 +1,16 @@
+package kafka
+
+import "github.com/segmentio/kafka-go"
+
+// Заголовок с токеном JWT владельца команды
+const headerAuthToken = "x-auth-token"
+
+// headerValue возвращает значение заголовка key или пустую строку
+func headerValue(headers []kafka.Header, key string) string {
+	for _, header := range headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+	return ""
+}
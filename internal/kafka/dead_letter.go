@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Заголовок сообщения в топике недоставленных сообщений с этапом, на котором команда отклонена
+const headerFailedStage = "x-failed-stage"
+
+// DeadLetter сообщение топика недоставленных сообщений. Содержит исходное сообщение без изменений,
+// чтобы его можно было изучить и отправить повторно.
+type DeadLetter struct {
+	// Исходное значение сообщения. В JSON кодируется в base64
+	Payload   []byte            `json:"payload"`
+	Headers   map[string]string `json:"headers"`
+	Topic     string            `json:"topic"`
+	Partition int               `json:"partition"`
+	Offset    int64             `json:"offset"`
+	// Этап, на котором команда отклонена: decode, authorize, validate, risk, exchange
+	Stage    string    `json:"stage"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// sendDeadLetter отправляет исходное сообщение msg в топик недоставленных сообщений
+func (k *OrderKafka) sendDeadLetter(ctx context.Context, msg kafka.Message, stage string, cause error) error {
+	deadLetter := DeadLetter{
+		Payload:   msg.Value,
+		Headers:   make(map[string]string, len(msg.Headers)),
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Stage:     stage,
+		Error:     cause.Error(),
+		FailedAt:  time.Now().UTC(),
+	}
+	for _, header := range msg.Headers {
+		deadLetter.Headers[header.Key] = string(header.Value)
+	}
+
+	value, err := json.Marshal(deadLetter)
+	if err != nil {
+		return err
+	}
+
+	headers := append([]kafka.Header{}, msg.Headers...)
+	headers = append(headers, kafka.Header{Key: headerFailedStage, Value: []byte(stage)})
+
+	err = k.deadLetterWriter.WriteMessages(ctx, kafka.Message{
+		Key:     msg.Key,
+		Value:   value,
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка при отправке в топик недоставленных сообщений: %v", err)
+	}
+
+	logger.Log.Warn(fmt.Sprintf("Сообщение (partition: %d, offset: %d) отправлено в топик недоставленных сообщений, этап %s: %v",
+		msg.Partition, msg.Offset, stage, cause))
+	return nil
+}
+
+// sendOrderDeadLetter отправляет в топик недоставленных сообщений исходное сообщение ордера, не выполненного на этапе
+// order.FailedStage. Для ордеров не из кафки исходным сообщением считается сам ордер.
+func (k *OrderKafka) sendOrderDeadLetter(ctx context.Context, order model.Order) error {
+	k.mu.Lock()
+	message, ok := k.inflight[order.MessageKey]
+	k.mu.Unlock()
+	msg := message.msg
+
+	if !ok {
+		payload, err := json.Marshal(order)
+		if err != nil {
+			return err
+		}
+		msg = kafka.Message{Value: payload, Partition: -1, Offset: -1}
+	}
+
+	return k.sendDeadLetter(ctx, msg, order.FailedStage, errors.New(order.Error))
+}
@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"app/internal/auth"
+	"app/internal/model"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// deadLetter разбирает сообщение топика недоставленных сообщений
+func deadLetter(t *testing.T, msg kafka.Message) DeadLetter {
+	t.Helper()
+	var letter DeadLetter
+	if err := json.Unmarshal(msg.Value, &letter); err != nil {
+		t.Fatal(err)
+	}
+	if stage := headerValue(msg.Headers, headerFailedStage); stage != letter.Stage {
+		t.Fatalf("заголовок этапа %q, в сообщении %q", stage, letter.Stage)
+	}
+	return letter
+}
+
+// Сообщения, которые не передаются в обработку, уходят в топик недоставленных сообщений без изменений,
+// и только потом фиксируется их смещение
+func TestRejectedMessagesGoToDeadLetter(t *testing.T) {
+	authenticator, err := auth.NewAuthenticator("secret", time.Hour, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		auth  *auth.Authenticator
+		value string
+		stage string
+	}{
+		{"некорректный JSON", nil, `{"action":`, model.StageDecode},
+		{"команда без токена", authenticator, `{"action":"place_order","strategy_id":1}`, model.StageAuthorize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, j := newTestKafka(t, 0)
+			k.auth = tt.auth
+			reader := k.reader.(*fakeReader)
+			reader.messages <- kafka.Message{
+				Topic:   "new_orders",
+				Offset:  7,
+				Key:     []byte("key"),
+				Value:   []byte(tt.value),
+				Headers: []kafka.Header{{Key: "x-source", Value: []byte("strategy")}},
+			}
+			close(reader.messages)
+			k.readTopic(k.reader, 0)
+
+			if len(k.new_orders) != 0 {
+				t.Fatal("отклоненная команда передана в обработку")
+			}
+			assertJournal(t, j, "write dead_letters", "commit new_orders/0/7")
+			written := k.deadLetterWriter.(*fakeWriter).written()
+			letter := deadLetter(t, written[0])
+			if string(letter.Payload) != tt.value || letter.Stage != tt.stage || letter.Offset != 7 || letter.Topic != "new_orders" {
+				t.Fatalf("недоставленное сообщение %+v", letter)
+			}
+			if letter.Headers["x-source"] != "strategy" || string(written[0].Key) != "key" || letter.Error == "" {
+				t.Fatalf("заголовки %v, ключ %q, ошибка %q", letter.Headers, written[0].Key, letter.Error)
+			}
+		})
+	}
+}
+
+// Команда, отклоненная при проверке или биржей, уходит в топик недоставленных сообщений исходным сообщением,
+// а ее результат публикуется
+func TestFailedOrderGoesToDeadLetter(t *testing.T) {
+	k, j := newTestKafka(t, 0)
+	msg := commandMessage(3, model.Order{Action: "place_order", Symbol: "BTCUSDT"})
+	failed := model.Order{
+		Action:         "place_order",
+		OrderApiStatus: model.OrderApiStatusError,
+		Error:          "LOT_SIZE",
+		FailedStage:    model.StageValidate,
+		MessageKey:     k.track(k.reader, msg),
+	}
+	// Ордер не из кафки отправляется как есть и без фиксации смещения
+	local := failed
+	local.MessageKey = ""
+	local.FailedStage = model.StageExchange
+
+	writeResults(k, failed, local)
+	assertJournal(t, j, "write dead_letters", "write ready_orders", "commit new_orders/0/3", "write dead_letters", "write ready_orders")
+
+	written := k.deadLetterWriter.(*fakeWriter).written()
+	letter := deadLetter(t, written[0])
+	if string(letter.Payload) != string(msg.Value) || letter.Stage != model.StageValidate || letter.Error != "LOT_SIZE" {
+		t.Fatalf("недоставленное сообщение %+v", letter)
+	}
+	letter = deadLetter(t, written[1])
+	var order model.Order
+	if err := json.Unmarshal(letter.Payload, &order); err != nil {
+		t.Fatal(err)
+	}
+	if letter.Offset != -1 || letter.Stage != model.StageExchange || order.Error != "LOT_SIZE" {
+		t.Fatalf("недоставленное сообщение ордера не из кафки %+v", letter)
+	}
+}
+
+// Смещение отклоненного сообщения не фиксируется, пока оно не попало в топик недоставленных сообщений
+func TestDeadLetterRetriedBeforeCommit(t *testing.T) {
+	k, j := newTestKafka(t, 0)
+	k.deadLetterWriter.(*fakeWriter).failures = 1
+	reader := k.reader.(*fakeReader)
+	reader.messages <- kafka.Message{Topic: "new_orders", Offset: 9, Value: []byte("not json")}
+	close(reader.messages)
+	k.readTopic(k.reader, 0)
+	assertJournal(t, j, "error dead_letters", "write dead_letters", "commit new_orders/0/9")
+}
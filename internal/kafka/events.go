@@ -0,0 +1,56 @@
+package kafka
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"encoding/json"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// StartWritingEvents публикует события смены статуса ордеров в топик событий. Ключ сообщения ClientOrderID,
+// поэтому события одного ордера попадают в одну партицию и читаются по порядку.
+// Завершается после закрытия канала events.
+func (k *OrderKafka) StartWritingEvents(events chan model.OrderEvent) {
+	defer close(k.eventsDone)
+
+	for event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			logger.Log.Error(fmt.Sprintf("Ошибка при кодировании события ордера %s: %v", event.ClientOrderID, err))
+			continue
+		}
+
+		err = k.eventsWriter.WriteMessages(k.writeCtx, kafka.Message{
+			Key:   []byte(event.ClientOrderID),
+			Value: value,
+		})
+		if err != nil {
+			// События служат для наблюдения, поэтому ошибка публикации не останавливает обработку ордеров
+			logger.Log.Error(fmt.Sprintf("Ошибка при отправке события ордера %s (%s -> %s): %v", event.ClientOrderID, event.From, event.To, err))
+		}
+	}
+}
+
+// StartWritingReports публикует отчеты об исполнении из потока пользовательских данных в топик отчетов.
+// Ключ сообщения ClientOrderID. Завершается после закрытия канала reports.
+func (k *OrderKafka) StartWritingReports(reports chan model.ExecutionReport) {
+	defer close(k.reportsDone)
+
+	for report := range reports {
+		value, err := json.Marshal(report)
+		if err != nil {
+			logger.Log.Error(fmt.Sprintf("Ошибка при кодировании отчета об исполнении ордера %d: %v", report.BinanceID, err))
+			continue
+		}
+
+		err = k.reportsWriter.WriteMessages(k.writeCtx, kafka.Message{
+			Key:   []byte(report.ClientOrderID),
+			Value: value,
+		})
+		if err != nil {
+			logger.Log.Error(fmt.Sprintf("Ошибка при отправке отчета об исполнении ордера %d (%s): %v", report.BinanceID, report.ExecutionType, err))
+		}
+	}
+}
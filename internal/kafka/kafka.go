@@ -1,59 +1,253 @@
 package kafka
 
 import (
+	"app/internal/auth"
 	"app/internal/logger"
 	"app/internal/model"
+	"app/internal/store"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/segmentio/kafka-go"
 )
 
+// Config настройки менеджера кафки
+type Config struct {
+	BrokerAddress string
+	// Группа потребителей. Обязательна: без группы нельзя фиксировать смещения
+	GroupID         string
+	NewOrderTopic   string
+	ReadyOrderTopic string
+	// В DeadLetterTopic попадают сообщения, которые не удалось разобрать, и команды, отклоненные при проверке,
+	// биржей или исчерпавшие попытки повтора
+	DeadLetterTopic string
+	// В OrderEventsTopic публикуются события смены статуса ордеров
+	OrderEventsTopic string
+	// В ExecutionReportTopic публикуются отчеты об исполнении из потока пользовательских данных
+	ExecutionReportTopic string
+	Retry                RetryPolicy
+	// Store хранилище ордеров. Если задано, результат сохраняется в одной транзакции с постановкой в очередь
+	// публикации, а в ReadyOrderTopic его публикует StartRelay. Если nil, результат публикуется сразу
+	Store *store.Store
+	// Период опроса очереди публикации и срок хранения опубликованных результатов
+	OutboxInterval  time.Duration
+	OutboxRetention time.Duration
+	// Authenticator, если задан, проверяет токен из заголовка x-auth-token каждой команды NewOrderTopic
+	// и топиков повтора: владельцу токена должны быть разрешены стратегия и символ ордера. Команды без токена
+	// или с чужой стратегией уходят в DeadLetterTopic. В топик повтора токен переносится из исходной команды
+	Authenticator *auth.Authenticator
+}
+
 // Небольшая надстройка над структурой для работы с ордерами из кафки
 type OrderKafka struct {
 	new_orders chan model.Order
-	ctx        context.Context
-	writer     *kafka.Writer
-	reader     *kafka.Reader
+	// ctx контекст чтения новых сообщений, отменяется в StopReading
+	ctx    context.Context
+	cancel context.CancelFunc
+	// writeCtx контекст публикации результатов и фиксации смещений. Живет дольше ctx,
+	// чтобы при остановке успеть опубликовать результаты уже принятых ордеров
+	writeCtx    context.Context
+	writeCancel context.CancelFunc
+	writer      messageWriter
+	reader      messageReader
+	// deadLetterWriter пишет в топик недоставленных сообщений
+	deadLetterWriter messageWriter
+	// eventsWriter пишет в топик событий ордеров
+	eventsWriter messageWriter
+	// reportsWriter пишет в топик отчетов об исполнении
+	reportsWriter messageWriter
+
+	retry      RetryPolicy
+	retryTiers []retryTier
+
+	// store хранилище с очередью публикации результатов. Может быть nil
+	store           *store.Store
+	outboxInterval  time.Duration
+	outboxRetention time.Duration
+
+	// auth проверка токенов команд. Может быть nil
+	auth *auth.Authenticator
+
+	// inflight сообщения, смещение которых еще не зафиксировано
+	mu       sync.Mutex
+	inflight map[string]inflightMessage
+
+	readingDone chan struct{}
+	writingDone chan struct{}
+	eventsDone  chan struct{}
+	reportsDone chan struct{}
+	relayDone   chan struct{}
+}
+
+// inflightMessage сообщение и читатель, через которого нужно зафиксировать его смещение
+type inflightMessage struct {
+	msg    kafka.Message
+	reader messageReader
+}
+
+// messageReader читатель топика в группе потребителей, которым реализован *kafka.Reader
+type messageReader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// messageWriter писатель в топик, которым реализован *kafka.Writer
+type messageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
 }
 
-func NewKafkaManager(newOrderTopic, readyOrderTopic, brokerAddress string, new_orders chan model.Order) (*OrderKafka, error) {
+func NewKafkaManager(config Config, new_orders chan model.Order) (*OrderKafka, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	writeCtx, writeCancel := context.WithCancel(context.Background())
 	orderKafka := OrderKafka{
-		ctx: context.Background(),
+		new_orders:  new_orders,
+		ctx:         ctx,
+		cancel:      cancel,
+		writeCtx:    writeCtx,
+		writeCancel: writeCancel,
 		reader: kafka.NewReader(kafka.ReaderConfig{
-			Brokers: []string{brokerAddress},
-			Topic:   newOrderTopic,
+			Brokers: []string{config.BrokerAddress},
+			Topic:   config.NewOrderTopic,
+			GroupID: config.GroupID,
 		}),
 		writer: kafka.NewWriter(kafka.WriterConfig{
-			Brokers: []string{brokerAddress},
-			Topic:   readyOrderTopic,
+			Brokers: []string{config.BrokerAddress},
+			Topic:   config.ReadyOrderTopic,
 		}),
+		deadLetterWriter: kafka.NewWriter(kafka.WriterConfig{
+			Brokers: []string{config.BrokerAddress},
+			Topic:   config.DeadLetterTopic,
+		}),
+		eventsWriter: kafka.NewWriter(kafka.WriterConfig{
+			Brokers: []string{config.BrokerAddress},
+			Topic:   config.OrderEventsTopic,
+		}),
+		reportsWriter: kafka.NewWriter(kafka.WriterConfig{
+			Brokers: []string{config.BrokerAddress},
+			Topic:   config.ExecutionReportTopic,
+		}),
+		retry:           config.Retry,
+		store:           config.Store,
+		outboxInterval:  config.OutboxInterval,
+		outboxRetention: config.OutboxRetention,
+		auth:            config.Authenticator,
+		inflight:        make(map[string]inflightMessage),
+		readingDone:     make(chan struct{}),
+		writingDone:     make(chan struct{}),
+		eventsDone:      make(chan struct{}),
+		reportsDone:     make(chan struct{}),
+		relayDone:       make(chan struct{}),
 	}
 
-	if err := orderKafka.createTopic(orderKafka.ctx, newOrderTopic, brokerAddress); err != nil {
-		logger.Log.Error("ошибка при создании топика: %v" + err.Error())
-		return nil, fmt.Errorf("ошибка при создании топика: %v", err)
-	}
-	if err := orderKafka.createTopic(orderKafka.ctx, readyOrderTopic, brokerAddress); err != nil {
-		logger.Log.Error("ошибка при создании топика: %v" + err.Error())
-		return nil, fmt.Errorf("ошибка при создании топика: %v", err)
+	topics := []string{config.NewOrderTopic, config.ReadyOrderTopic, config.DeadLetterTopic, config.OrderEventsTopic, config.ExecutionReportTopic}
+	for _, delay := range config.Retry.Delays {
+		tier := newRetryTier(config, delay)
+		orderKafka.retryTiers = append(orderKafka.retryTiers, tier)
+		topics = append(topics, tier.topic)
 	}
 
-	// sigchan := make(chan os.Signal, 1)
-	// signal.Notify(sigchan, os.Interrupt)
-	// <-sigchan
-	// fmt.Println("Завершение программы...")
+	for _, topic := range topics {
+		if err := orderKafka.createTopic(orderKafka.ctx, topic, config.BrokerAddress); err != nil {
+			logger.Log.Error("ошибка при создании топика: %v" + err.Error())
+			return nil, fmt.Errorf("ошибка при создании топика: %v", err)
+		}
+	}
 
 	return &orderKafka, nil
 }
 
-// Close закрывает все каналы и контексты
+// Close немедленно закрывает писателя и читателя, не дожидаясь публикации результатов.
+// Для плавной остановки используйте StopReading и Shutdown.
 func (k *OrderKafka) Close() {
-	close(k.new_orders)
+	k.cancel()
+	k.writeCancel()
 	k.writer.Close()
+	k.deadLetterWriter.Close()
+	k.eventsWriter.Close()
+	k.reportsWriter.Close()
 	k.reader.Close()
+	for _, tier := range k.retryTiers {
+		tier.writer.Close()
+		tier.reader.Close()
+	}
+}
+
+// StopReading прекращает чтение новых сообщений и ждет завершения StartReadingKafka.
+// После возврата канал новых ордеров закрыт.
+func (k *OrderKafka) StopReading() {
+	k.cancel()
+	<-k.readingDone
+}
+
+// Shutdown ждет, пока StartWritingKafka опубликует все результаты и зафиксирует смещения, StartRelay опубликует
+// очередь результатов, а StartWritingEvents и StartWritingReports опубликуют события и отчеты,
+// затем закрывает писателей и читателей. Каналы готовых
+// ордеров, событий и отчетов должны быть закрыты вызывающим. Если ctx истечет раньше,
+// неопубликованные сообщения останутся незафиксированными и будут прочитаны повторно после перезапуска.
+func (k *OrderKafka) Shutdown(ctx context.Context) error {
+	var err error
+	select {
+	case <-k.writingDone:
+	case <-ctx.Done():
+		err = fmt.Errorf("не все результаты опубликованы до остановки: %v", ctx.Err())
+		k.writeCancel()
+		<-k.writingDone
+	}
+	dones := []chan struct{}{k.eventsDone, k.reportsDone}
+	if k.store != nil {
+		dones = append(dones, k.relayDone)
+	}
+	for _, done := range dones {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			k.writeCancel()
+			<-done
+		}
+	}
+
+	k.mu.Lock()
+	if len(k.inflight) > 0 {
+		logger.Log.Warn(fmt.Sprintf("Остановка с %d незафиксированными сообщениями, они будут прочитаны повторно", len(k.inflight)))
+	}
+	k.mu.Unlock()
+
+	k.cancel()
+	k.writeCancel()
+	if closeErr := k.writer.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("ошибка при закрытии писателя: %v", closeErr)
+	}
+	if closeErr := k.deadLetterWriter.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("ошибка при закрытии писателя недоставленных сообщений: %v", closeErr)
+	}
+	if closeErr := k.eventsWriter.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("ошибка при закрытии писателя событий: %v", closeErr)
+	}
+	if closeErr := k.reportsWriter.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("ошибка при закрытии писателя отчетов об исполнении: %v", closeErr)
+	}
+	if closeErr := k.reader.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("ошибка при закрытии читателя: %v", closeErr)
+	}
+	for _, tier := range k.retryTiers {
+		if closeErr := tier.writer.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("ошибка при закрытии писателя %s: %v", tier.topic, closeErr)
+		}
+		if closeErr := tier.reader.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("ошибка при закрытии читателя %s: %v", tier.topic, closeErr)
+		}
+	}
+	return err
 }
 
 func (k *OrderKafka) createTopic(ctx context.Context, topic, brokerAddress string) error {
@@ -102,37 +296,259 @@ func (k *OrderKafka) sendReadyOrders(ctx context.Context, order model.Order) err
 	})
 	if err != nil {
 		log.Printf("Ошибка при отправке сообщения: %v", err)
-	} else {
-		log.Printf("Отправлено: %s", orderJSON)
+		return err
 	}
+	log.Printf("Отправлено: %s", orderJSON)
 	return nil
 }
 
-// StartReadingKafka читает сообщения из кафки и кладет их в канал
+// StartWritingKafka читает выполненные ордера из канала, отправляет их в топик готовых ордеров или, если задано
+// хранилище, в очередь публикации и фиксирует смещение исходного сообщения. Завершается после закрытия канала readyOrders.
+// Неудачная отправка повторяется, пока не удастся или не будет отменена публикация: смещения в группе потребителей
+// накопительные, и фиксация следующего сообщения раздела потеряла бы неопубликованное.
+func (k *OrderKafka) StartWritingKafka(readyOrders chan model.Order) {
+	defer close(k.writingDone)
+
+	for order := range readyOrders {
+		if order.Retryable {
+			var retried bool
+			err := publishUntilDone(k.writeCtx, "Ошибка при отправке ордера на повтор", func(ctx context.Context) error {
+				var err error
+				retried, err = k.scheduleRetry(ctx, order)
+				return err
+			})
+			if err != nil {
+				k.abandon(order, err)
+				continue
+			}
+			if retried {
+				// Результатом команды станет следующая попытка, поэтому сохраняется только состояние ордера
+				if err := k.saveResult(order, false); err != nil {
+					logger.Log.Error(err)
+				}
+				k.commit(k.writeCtx, order.MessageKey)
+				continue
+			}
+		}
+		if order.FailedStage != "" {
+			err := publishUntilDone(k.writeCtx, "Ошибка при отправке ордера в топик недоставленных сообщений", func(ctx context.Context) error {
+				return k.sendOrderDeadLetter(ctx, order)
+			})
+			if err != nil {
+				k.abandon(order, err)
+				continue
+			}
+		}
+		err := publishUntilDone(k.writeCtx, "Ошибка при отправке результата ордера", func(ctx context.Context) error {
+			return k.PublishResult(ctx, order)
+		})
+		if err != nil {
+			k.abandon(order, err)
+			continue
+		}
+		k.commit(k.writeCtx, order.MessageKey)
+	}
+}
+
+// abandon оставляет смещение сообщения ордера, результат которого не опубликован до остановки, незафиксированным:
+// после перезапуска сообщение будет прочитано повторно
+func (k *OrderKafka) abandon(order model.Order, err error) {
+	logger.Log.Error(fmt.Sprintf("Результат ордера %s (%s) не опубликован до остановки, сообщение будет прочитано повторно: %v",
+		order.ClientOrderID, order.Action, err))
+	k.untrack(order.MessageKey)
+}
+
+// Пауза между попытками публикации: от publishRetryMin с удвоением до publishRetryMax
+const (
+	publishRetryMin = 100 * time.Millisecond
+	publishRetryMax = 30 * time.Second
+)
+
+// publishUntilDone повторяет publish с растущей паузой, пока публикация не удастся или не будет отменен ctx.
+// Возвращает ошибку последней попытки, если ctx отменен раньше
+func publishUntilDone(ctx context.Context, what string, publish func(ctx context.Context) error) error {
+	pause := publishRetryMin
+	for {
+		err := publish(ctx)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		logger.Log.Error(fmt.Sprintf("%s: %v, повтор через %v", what, err, pause))
+
+		timer := time.NewTimer(pause)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		pause = min(pause*2, publishRetryMax)
+	}
+}
+
+// StartReadingKafka читает сообщения из топика новых ордеров и топиков повтора и кладет их в канал.
+// Завершается после StopReading или закрытия читателей и закрывает канал новых ордеров.
 func (k *OrderKafka) StartReadingKafka() {
+	defer close(k.readingDone)
+	defer close(k.new_orders)
 
+	var wg sync.WaitGroup
+	for _, tier := range k.retryTiers {
+		wg.Add(1)
+		go func(tier retryTier) {
+			defer wg.Done()
+			k.readTopic(tier.reader, tier.delay)
+		}(tier)
+	}
+
+	k.readTopic(k.reader, 0)
+	wg.Wait()
+}
+
+// readTopic читает сообщения читателем reader и кладет их в канал новых ордеров не раньше,
+// чем через delay после записи сообщения
+func (k *OrderKafka) readTopic(reader messageReader, delay time.Duration) {
 	for {
-		msg, err := k.reader.FetchMessage(k.ctx)
+		msg, err := reader.FetchMessage(k.ctx)
+		if k.ctx.Err() != nil || errors.Is(err, io.EOF) {
+			// Чтение остановлено или читатель закрыт
+			return
+		}
 		if err != nil {
 			logger.Log.Info("Ошибка при чтении сообщения: ", err)
 			continue
 		}
 
-		logger.Log.Info(fmt.Sprintf("Получено сообщение: %s (partition: %d, offset: %d)", string(msg.Value), msg.Partition, msg.Offset))
+		logger.Log.Info(fmt.Sprintf("Получено сообщение: %s (topic: %s, partition: %d, offset: %d)", string(msg.Value), msg.Topic, msg.Partition, msg.Offset))
+
+		if !k.waitUntil(msg.Time.Add(delay)) {
+			// Чтение остановлено до наступления времени повтора, сообщение будет прочитано повторно
+			return
+		}
 
 		var order model.Order
 		err = json.Unmarshal(msg.Value, &order)
 		if err != nil {
 			logger.Log.Error("Ошибка при анмаршалинге значения смещения: ", err.Error())
+			k.rejectMessage(reader, msg, model.StageDecode, err)
+			continue
+		}
+
+		// Команды из топиков повтора проверяются по токену исходной команды, который переносится в повтор
+		if k.auth != nil {
+			if err := k.auth.AuthorizeOrder(headerValue(msg.Headers, headerAuthToken), order); err != nil {
+				logger.Log.Error(fmt.Sprintf("Команда не прошла проверку токена: %v", err))
+				k.rejectMessage(reader, msg, model.StageAuthorize, err)
+				continue
+			}
 		}
 
-		k.new_orders <- order
+		if attempt, ok := attemptFromHeaders(msg.Headers); ok {
+			order.Attempt = attempt
+		}
+		if order.ClientOrderID == "" {
+			order.ClientOrderID = clientOrderID(msg)
+		}
+		order.MessageKey = k.track(reader, msg)
 
-		// Фиксация смещения только после успешной "отправки запроса"
-		if err := k.reader.CommitMessages(k.ctx, msg); err != nil {
-			logger.Log.Error("Ошибка при фиксации смещения: ", err.Error())
-		} else {
-			logger.Log.Error(fmt.Sprintf("Смещение зафиксировано для partition: %d, offset: %d", msg.Partition, msg.Offset))
+		select {
+		case k.new_orders <- order:
+		case <-k.ctx.Done():
+			// Ордер не принят в обработку: смещение не фиксируем, сообщение будет прочитано повторно
+			k.untrack(order.MessageKey)
+			return
 		}
 	}
 }
+
+// waitUntil ждет наступления момента t. Возвращает false, если чтение остановлено раньше
+func (k *OrderKafka) waitUntil(t time.Time) bool {
+	wait := time.Until(t)
+	if wait <= 0 {
+		return true
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-k.ctx.Done():
+		return false
+	}
+}
+
+// messageKey уникальный ключ сообщения в пределах группы потребителей
+func messageKey(msg kafka.Message) string {
+	return fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
+// clientOrderID формирует идентификатор ордера для Binance из координат сообщения. Повторно доставленное
+// сообщение получает тот же идентификатор. Длина не превышает 36 символов, допустимых для newClientOrderId.
+func clientOrderID(msg kafka.Message) string {
+	sum := sha256.Sum256([]byte(messageKey(msg)))
+	return "os-" + hex.EncodeToString(sum[:16])
+}
+
+// track запоминает сообщение до фиксации смещения и возвращает его ключ
+func (k *OrderKafka) track(reader messageReader, msg kafka.Message) string {
+	key := messageKey(msg)
+	k.mu.Lock()
+	k.inflight[key] = inflightMessage{msg: msg, reader: reader}
+	k.mu.Unlock()
+	return key
+}
+
+// inflightHeader возвращает заголовок header сообщения с ключом key, смещение которого еще не зафиксировано
+func (k *OrderKafka) inflightHeader(key, header string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	message, ok := k.inflight[key]
+	if !ok {
+		return ""
+	}
+	return headerValue(message.msg.Headers, header)
+}
+
+func (k *OrderKafka) untrack(key string) (inflightMessage, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	message, ok := k.inflight[key]
+	delete(k.inflight, key)
+	return message, ok
+}
+
+// commit фиксирует смещение сообщения с ключом key. Ордера не из кафки (пустой ключ) пропускаются.
+func (k *OrderKafka) commit(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+	message, ok := k.untrack(key)
+	if !ok {
+		logger.Log.Warn("Не найдено сообщение для фиксации смещения: ", key)
+		return
+	}
+
+	// Фиксация смещения только после публикации результата
+	msg := message.msg
+	if err := message.reader.CommitMessages(ctx, msg); err != nil {
+		logger.Log.Error("Ошибка при фиксации смещения: ", err.Error())
+	} else {
+		logger.Log.Info(fmt.Sprintf("Смещение зафиксировано для topic: %s, partition: %d, offset: %d", msg.Topic, msg.Partition, msg.Offset))
+	}
+}
+
+// rejectMessage отправляет сообщение, которое не будет передано в обработку, в топик недоставленных сообщений
+// и фиксирует его смещение. Отправка повторяется до успеха, а если чтение остановлено раньше,
+// смещение не фиксируется.
+func (k *OrderKafka) rejectMessage(reader messageReader, msg kafka.Message, stage string, cause error) {
+	err := publishUntilDone(k.ctx, "Ошибка при отправке сообщения в топик недоставленных сообщений", func(context.Context) error {
+		return k.sendDeadLetter(k.writeCtx, msg, stage, cause)
+	})
+	if err != nil {
+		logger.Log.Error(err)
+		return
+	}
+	if err := reader.CommitMessages(k.writeCtx, msg); err != nil {
+		logger.Log.Error("Ошибка при фиксации смещения: ", err.Error())
+	}
+}
@@ -0,0 +1,235 @@
+package kafka
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewConsoleLogger()
+	os.Exit(m.Run())
+}
+
+// journal общий журнал записей и фиксаций смещений, по которому проверяется их порядок
+type journal struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (j *journal) add(entry string) {
+	j.mu.Lock()
+	j.entries = append(j.entries, entry)
+	j.mu.Unlock()
+}
+
+func (j *journal) list() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]string(nil), j.entries...)
+}
+
+// fakeReader отдает сообщения из канала messages и записывает фиксации смещений в журнал.
+// После закрытия канала FetchMessage возвращает io.EOF, как закрытый читатель
+type fakeReader struct {
+	journal  *journal
+	messages chan kafka.Message
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case msg, ok := <-r.messages:
+		if !ok {
+			return kafka.Message{}, io.EOF
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, msgs ...kafka.Message) error {
+	for _, msg := range msgs {
+		r.journal.add("commit " + messageKey(msg))
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	return nil
+}
+
+// fakeWriter запоминает записанные сообщения. Первые failures записей завершаются ошибкой
+type fakeWriter struct {
+	topic   string
+	journal *journal
+
+	mu       sync.Mutex
+	failures int
+	messages []kafka.Message
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		w.journal.add("error " + w.topic)
+		return errors.New("broker unavailable")
+	}
+	w.messages = append(w.messages, msgs...)
+	w.journal.add("write " + w.topic)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	return nil
+}
+
+func (w *fakeWriter) written() []kafka.Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]kafka.Message(nil), w.messages...)
+}
+
+// newTestKafka создает менеджер над фейковыми читателями и писателями с топиками повтора delays
+func newTestKafka(t *testing.T, maxAttempts int, delays ...time.Duration) (*OrderKafka, *journal) {
+	t.Helper()
+	j := &journal{}
+	ctx, cancel := context.WithCancel(context.Background())
+	writeCtx, writeCancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	t.Cleanup(writeCancel)
+	k := &OrderKafka{
+		new_orders:       make(chan model.Order, 10),
+		ctx:              ctx,
+		cancel:           cancel,
+		writeCtx:         writeCtx,
+		writeCancel:      writeCancel,
+		reader:           &fakeReader{journal: j, messages: make(chan kafka.Message, 10)},
+		writer:           &fakeWriter{topic: "ready_orders", journal: j},
+		deadLetterWriter: &fakeWriter{topic: "dead_letters", journal: j},
+		eventsWriter:     &fakeWriter{topic: "order_events", journal: j},
+		reportsWriter:    &fakeWriter{topic: "execution_reports", journal: j},
+		retry:            RetryPolicy{Delays: delays, MaxAttempts: maxAttempts},
+		inflight:         make(map[string]inflightMessage),
+		readingDone:      make(chan struct{}),
+		writingDone:      make(chan struct{}),
+	}
+	for _, delay := range delays {
+		topic := retryTopicName("new_orders", delay)
+		k.retryTiers = append(k.retryTiers, retryTier{
+			delay:  delay,
+			topic:  topic,
+			reader: &fakeReader{journal: j, messages: make(chan kafka.Message, 10)},
+			writer: &fakeWriter{topic: topic, journal: j},
+		})
+	}
+	return k, j
+}
+
+func commandMessage(offset int64, order model.Order, headers ...kafka.Header) kafka.Message {
+	value, _ := json.Marshal(order)
+	return kafka.Message{Topic: "new_orders", Offset: offset, Value: value, Headers: headers, Time: time.Now()}
+}
+
+// writeResults передает ордера в StartWritingKafka и ждет, пока она их обработает
+func writeResults(k *OrderKafka, orders ...model.Order) {
+	readyOrders := make(chan model.Order, len(orders))
+	for _, order := range orders {
+		readyOrders <- order
+	}
+	close(readyOrders)
+	k.StartWritingKafka(readyOrders)
+}
+
+func assertJournal(t *testing.T, j *journal, want ...string) {
+	t.Helper()
+	if got := j.list(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("журнал:\n%s\nожидался:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// Смещение фиксируется только после публикации результата, а неудачная публикация повторяется
+func TestCommitAfterPublish(t *testing.T) {
+	k, j := newTestKafka(t, 0)
+	k.writer.(*fakeWriter).failures = 2
+	msg := commandMessage(5, model.Order{Action: "place_order"})
+	order := model.Order{Action: "place_order", OrderApiStatus: model.OrderApiStatusSuccess, MessageKey: k.track(k.reader, msg)}
+
+	writeResults(k, order)
+	assertJournal(t, j, "error ready_orders", "error ready_orders", "write ready_orders", "commit new_orders/0/5")
+	if len(k.inflight) != 0 {
+		t.Fatalf("незафиксированных сообщений %d", len(k.inflight))
+	}
+}
+
+// Результат, не опубликованный до остановки, оставляет смещение незафиксированным
+func TestUnpublishedResultKeepsOffset(t *testing.T) {
+	k, j := newTestKafka(t, 0)
+	k.writer.(*fakeWriter).failures = 1000
+	msg := commandMessage(5, model.Order{Action: "place_order"})
+	order := model.Order{Action: "place_order", MessageKey: k.track(k.reader, msg)}
+
+	time.AfterFunc(50*time.Millisecond, k.writeCancel)
+	writeResults(k, order)
+	for _, entry := range j.list() {
+		if strings.HasPrefix(entry, "commit") || strings.HasPrefix(entry, "write") {
+			t.Fatalf("после неудачной публикации в журнале %q", entry)
+		}
+	}
+	if len(k.inflight) != 0 {
+		t.Fatal("брошенное сообщение осталось в незафиксированных")
+	}
+}
+
+// Ордер не из кафки публикуется без фиксации смещения
+func TestPublishOrderWithoutMessage(t *testing.T) {
+	k, j := newTestKafka(t, 0)
+	writeResults(k, model.Order{Action: "place_order"})
+	assertJournal(t, j, "write ready_orders")
+}
+
+func TestReadTopicDeliversCommand(t *testing.T) {
+	k, _ := newTestKafka(t, 0)
+	reader := k.reader.(*fakeReader)
+	reader.messages <- commandMessage(1, model.Order{Action: "place_order", ClientOrderID: "own-id"})
+	reader.messages <- commandMessage(2, model.Order{Action: "place_order"})
+	reader.messages <- commandMessage(2, model.Order{Action: "place_order"})
+	close(reader.messages)
+	k.readTopic(k.reader, 0)
+	close(k.new_orders)
+
+	var orders []model.Order
+	for order := range k.new_orders {
+		orders = append(orders, order)
+	}
+	if len(orders) != 3 {
+		t.Fatalf("получено %d команд, ожидалось 3", len(orders))
+	}
+	if orders[0].ClientOrderID != "own-id" || orders[0].MessageKey != "new_orders/0/1" {
+		t.Fatalf("команда %q, сообщение %q", orders[0].ClientOrderID, orders[0].MessageKey)
+	}
+	// Повторно доставленное сообщение получает тот же идентификатор ордера
+	generated := orders[1].ClientOrderID
+	if !strings.HasPrefix(generated, "os-") || len(generated) > 36 || orders[2].ClientOrderID != generated {
+		t.Fatalf("идентификаторы %q и %q", generated, orders[2].ClientOrderID)
+	}
+	if generated == clientOrderID(kafka.Message{Topic: "new_orders", Offset: 3}) {
+		t.Fatal("разные сообщения получили один идентификатор")
+	}
+	if len(k.inflight) != 2 {
+		t.Fatalf("незафиксированных сообщений %d, ожидалось 2", len(k.inflight))
+	}
+}
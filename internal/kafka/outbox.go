@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const (
+	// Сколько результатов публикуется за один запрос к кафке
+	outboxBatchSize = 100
+	// Период удаления опубликованных результатов из очереди
+	outboxPurgeInterval = time.Hour
+	// Период опроса очереди, если он не задан
+	outboxDefaultInterval = time.Second
+)
+
+// PublishResult публикует результат выполнения команды. Если задано хранилище, результат сохраняется
+// вместе с состоянием ордера и ставится в очередь публикации, иначе сразу отправляется в топик готовых ордеров
+func (k *OrderKafka) PublishResult(ctx context.Context, order model.Order) error {
+	if k.store == nil {
+		return k.sendReadyOrders(ctx, order)
+	}
+	return k.saveResult(order, true)
+}
+
+// saveResult сохраняет результат команды в хранилище, если оно задано
+func (k *OrderKafka) saveResult(order model.Order, publish bool) error {
+	if k.store == nil {
+		return nil
+	}
+	return k.store.SaveResult(order, publish)
+}
+
+// StartRelay публикует результаты из очереди хранилища в топик готовых ордеров и отмечает их опубликованными.
+// Очередь хранится в базе, поэтому результаты, не опубликованные до остановки, публикуются после перезапуска.
+// Результат, опубликованный, но не отмеченный из-за сбоя, публикуется повторно. Завершается после
+// StartWritingKafka, опубликовав оставшиеся результаты. Запускается, только если задано хранилище.
+func (k *OrderKafka) StartRelay() {
+	defer close(k.relayDone)
+
+	interval := k.outboxInterval
+	if interval <= 0 {
+		interval = outboxDefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastPurge time.Time
+	for {
+		select {
+		case <-ticker.C:
+			k.relayOutbox()
+			if k.outboxRetention > 0 && time.Since(lastPurge) > outboxPurgeInterval {
+				if err := k.store.PurgeOutbox(time.Now().UTC().Add(-k.outboxRetention)); err != nil {
+					logger.Log.Error(err)
+				}
+				lastPurge = time.Now()
+			}
+		case <-k.writingDone:
+			k.relayOutbox()
+			return
+		}
+	}
+}
+
+// relayOutbox публикует все неопубликованные результаты порциями. При ошибке оставшиеся результаты
+// публикуются при следующем вызове
+func (k *OrderKafka) relayOutbox() {
+	for {
+		pending, err := k.store.PendingOutbox(outboxBatchSize)
+		if err != nil {
+			logger.Log.Error(err)
+			return
+		}
+		if len(pending) == 0 {
+			return
+		}
+
+		messages := make([]kafka.Message, 0, len(pending))
+		ids := make([]uint, 0, len(pending))
+		for _, message := range pending {
+			messages = append(messages, kafka.Message{Value: message.Payload})
+			ids = append(ids, message.ID)
+		}
+		if err := k.writer.WriteMessages(k.writeCtx, messages...); err != nil {
+			logger.Log.Error(fmt.Sprintf("Ошибка при публикации %d результатов из очереди: %v", len(messages), err))
+			return
+		}
+		if err := k.store.MarkOutboxSent(ids); err != nil {
+			logger.Log.Error(err)
+			return
+		}
+		logger.Log.Info(fmt.Sprintf("Опубликовано результатов из очереди: %d", len(messages)))
+
+		if len(pending) < outboxBatchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package kafka
+
+import (
+	"app/internal/logger"
+	"app/internal/model"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Заголовок с номером попытки выполнения команды
+const headerAttempt = "x-attempt"
+
+// RetryPolicy политика повтора команд, не выполненных из-за временных ошибок биржи.
+// Попытка n (начиная с 1) отправляется в топик повтора с задержкой Delays[n-1], а если задержек меньше,
+// чем попыток, то с последней задержкой. После MaxAttempts попыток команда уходит в топик недоставленных сообщений.
+type RetryPolicy struct {
+	Delays      []time.Duration
+	MaxAttempts int
+}
+
+// retryTier топик повтора с фиксированной задержкой
+type retryTier struct {
+	delay  time.Duration
+	topic  string
+	reader messageReader
+	writer messageWriter
+}
+
+func newRetryTier(config Config, delay time.Duration) retryTier {
+	topic := retryTopicName(config.NewOrderTopic, delay)
+	return retryTier{
+		delay: delay,
+		topic: topic,
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: []string{config.BrokerAddress},
+			Topic:   topic,
+			GroupID: config.GroupID,
+		}),
+		writer: kafka.NewWriter(kafka.WriterConfig{
+			Brokers: []string{config.BrokerAddress},
+			Topic:   topic,
+		}),
+	}
+}
+
+// retryTopicName имя топика повтора, например new_orders-retry-30s
+func retryTopicName(baseTopic string, delay time.Duration) string {
+	return fmt.Sprintf("%s-retry-%ds", baseTopic, int64(delay/time.Second))
+}
+
+// scheduleRetry отправляет ордер в топик повтора, если попытки не исчерпаны.
+// Возвращает false, если повтор невозможен и ордер нужно считать окончательно не выполненным.
+func (k *OrderKafka) scheduleRetry(ctx context.Context, order model.Order) (bool, error) {
+	attempt := order.Attempt + 1
+	if len(k.retryTiers) == 0 || attempt >= k.retry.MaxAttempts {
+		logger.Log.Warn(fmt.Sprintf("Попытки выполнения ордера %d (%s) исчерпаны: %d", order.BinanceID, order.Action, attempt))
+		return false, nil
+	}
+
+	tierIndex := attempt - 1
+	if tierIndex >= len(k.retryTiers) {
+		tierIndex = len(k.retryTiers) - 1
+	}
+	tier := k.retryTiers[tierIndex]
+
+	order.Attempt = attempt
+	order.OrderApiStatus = ""
+	order.Error = ""
+	order.FailedStage = ""
+	order.Retryable = false
+
+	value, err := json.Marshal(order)
+	if err != nil {
+		return false, err
+	}
+	headers := []kafka.Header{{Key: headerAttempt, Value: []byte(strconv.Itoa(attempt))}}
+	// Повтор проверяется по токену исходной команды так же, как первое чтение
+	if token := k.inflightHeader(order.MessageKey, headerAuthToken); token != "" {
+		headers = append(headers, kafka.Header{Key: headerAuthToken, Value: []byte(token)})
+	}
+	err = tier.writer.WriteMessages(ctx, kafka.Message{
+		Value:   value,
+		Headers: headers,
+	})
+	if err != nil {
+		return false, fmt.Errorf("ошибка при отправке в топик повтора %s: %v", tier.topic, err)
+	}
+
+	logger.Log.Info(fmt.Sprintf("Ордер %d (%s) отправлен на повтор через %s, попытка %d", order.BinanceID, order.Action, tier.delay, attempt))
+	return true, nil
+}
+
+// attemptFromHeaders возвращает номер попытки из заголовков сообщения
+func attemptFromHeaders(headers []kafka.Header) (int, bool) {
+	for _, header := range headers {
+		if header.Key != headerAttempt {
+			continue
+		}
+		attempt, err := strconv.Atoi(string(header.Value))
+		if err != nil {
+			return 0, false
+		}
+		return attempt, true
+	}
+	return 0, false
+}
@@ -0,0 +1,152 @@
+package kafka
+
+import (
+	"app/internal/model"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestRetryTopicName(t *testing.T) {
+	if name := retryTopicName("new_orders", 90*time.Second); name != "new_orders-retry-90s" {
+		t.Fatalf("имя топика %s", name)
+	}
+}
+
+func TestAttemptFromHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []kafka.Header
+		attempt int
+		ok      bool
+	}{
+		{"номер попытки", []kafka.Header{{Key: headerAuthToken, Value: []byte("token")}, {Key: headerAttempt, Value: []byte("3")}}, 3, true},
+		{"нет заголовка", nil, 0, false},
+		{"не число", []kafka.Header{{Key: headerAttempt, Value: []byte("x")}}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt, ok := attemptFromHeaders(tt.headers)
+			if attempt != tt.attempt || ok != tt.ok {
+				t.Fatalf("попытка %d %v, ожидалась %d %v", attempt, ok, tt.attempt, tt.ok)
+			}
+		})
+	}
+}
+
+// Попытка n уходит в топик с задержкой Delays[n-1], а после последней задержки — в последний топик
+func TestScheduleRetryTiers(t *testing.T) {
+	delays := []time.Duration{time.Second, 10 * time.Second, time.Minute}
+	tests := []struct {
+		attempt int
+		topic   string
+	}{
+		{0, "new_orders-retry-1s"},
+		{1, "new_orders-retry-10s"},
+		{2, "new_orders-retry-60s"},
+		{3, "new_orders-retry-60s"},
+		// Пятая попытка не планируется при MaxAttempts 5
+		{4, ""},
+	}
+	for _, tt := range tests {
+		k, j := newTestKafka(t, 5, delays...)
+		retried, err := k.scheduleRetry(context.Background(), model.Order{Action: "place_order", Attempt: tt.attempt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retried != (tt.topic != "") {
+			t.Fatalf("попытка %d: повтор %v", tt.attempt, retried)
+		}
+		if tt.topic == "" {
+			assertJournal(t, j)
+			continue
+		}
+		assertJournal(t, j, "write "+tt.topic)
+	}
+
+	// Без топиков повтора команда не повторяется
+	k, _ := newTestKafka(t, 5)
+	if retried, _ := k.scheduleRetry(context.Background(), model.Order{}); retried {
+		t.Fatal("повтор без топиков повтора")
+	}
+}
+
+// Команда с временной ошибкой уходит в топик повтора с номером попытки и токеном исходной команды,
+// смещение фиксируется после записи в топик повтора, а результат не публикуется
+func TestRetryableOrderIsRetried(t *testing.T) {
+	k, j := newTestKafka(t, 3, time.Second)
+	msg := commandMessage(4, model.Order{Action: "place_order"}, kafka.Header{Key: headerAuthToken, Value: []byte("token")})
+	order := model.Order{
+		Action:         "place_order",
+		ClientOrderID:  "os-1",
+		OrderApiStatus: model.OrderApiStatusError,
+		Error:          "timeout",
+		FailedStage:    model.StageExchange,
+		Retryable:      true,
+		MessageKey:     k.track(k.reader, msg),
+	}
+
+	writeResults(k, order)
+	assertJournal(t, j, "write new_orders-retry-1s", "commit new_orders/0/4")
+
+	retry := k.retryTiers[0].writer.(*fakeWriter).written()[0]
+	if headerValue(retry.Headers, headerAttempt) != "1" || headerValue(retry.Headers, headerAuthToken) != "token" {
+		t.Fatalf("заголовки повтора %v", retry.Headers)
+	}
+	var retried model.Order
+	if err := json.Unmarshal(retry.Value, &retried); err != nil {
+		t.Fatal(err)
+	}
+	if retried.Attempt != 1 || retried.ClientOrderID != "os-1" || retried.Retryable || retried.Error != "" || retried.FailedStage != "" || retried.OrderApiStatus != "" {
+		t.Fatalf("команда повтора %+v", retried)
+	}
+}
+
+// Команда, исчерпавшая попытки, уходит в топик недоставленных сообщений, а ее результат публикуется
+func TestExhaustedRetriesGoToDeadLetter(t *testing.T) {
+	k, j := newTestKafka(t, 2, time.Second)
+	msg := commandMessage(6, model.Order{Action: "place_order"})
+	order := model.Order{
+		Action:         "place_order",
+		Attempt:        1,
+		OrderApiStatus: model.OrderApiStatusError,
+		Error:          "timeout",
+		FailedStage:    model.StageExchange,
+		Retryable:      true,
+		MessageKey:     k.track(k.reader, msg),
+	}
+
+	writeResults(k, order)
+	assertJournal(t, j, "write dead_letters", "write ready_orders", "commit new_orders/0/6")
+}
+
+// Сообщение из топика повтора передается в обработку не раньше задержки топика и с номером попытки из заголовка
+func TestRetryTierWaitsForDelay(t *testing.T) {
+	delay := 100 * time.Millisecond
+	k, _ := newTestKafka(t, 3, delay)
+	tier := k.retryTiers[0]
+	reader := tier.reader.(*fakeReader)
+	msg := commandMessage(2, model.Order{Action: "place_order", ClientOrderID: "os-1"}, kafka.Header{Key: headerAttempt, Value: []byte("2")})
+	msg.Topic = tier.topic
+	reader.messages <- msg
+	close(reader.messages)
+
+	start := time.Now()
+	k.readTopic(tier.reader, delay)
+	if elapsed := time.Since(start); elapsed < delay-10*time.Millisecond {
+		t.Fatalf("команда передана через %v, раньше задержки %v", elapsed, delay)
+	}
+	order := <-k.new_orders
+	if order.Attempt != 2 || order.MessageKey != tier.topic+"/0/2" {
+		t.Fatalf("попытка %d, сообщение %s", order.Attempt, order.MessageKey)
+	}
+
+	// Смещение фиксируется читателем топика повтора
+	k.commit(context.Background(), order.MessageKey)
+	if _, ok := k.inflight[order.MessageKey]; ok {
+		t.Fatal("смещение не зафиксировано")
+	}
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ExecutionReport изменение ордера на бирже из потока пользовательских данных: размещение, исполнение,
+// отмена, истечение срока. Приходит и для ордеров, размещенных не через сервис.
+type ExecutionReport struct {
+	Symbol string `json:"symbol"`
+	// Идентификатор ордера на стороне клиента. Для отмены это идентификатор отменяемого ордера
+	ClientOrderID string          `json:"client_order_id"`
+	BinanceID     int64           `json:"binance_id"`
+	Side          string          `json:"side"`
+	Type          string          `json:"type"`
+	TimeInForce   string          `json:"time_in_force"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	Price         decimal.Decimal `json:"price"`
+	StopPrice     decimal.Decimal `json:"stop_price"`
+	// Тип изменения Binance: NEW, CANCELED, REPLACED, REJECTED, TRADE, EXPIRED, TRADE_PREVENTION
+	ExecutionType string `json:"execution_type"`
+	// Статус ордера в терминах жизненного цикла сервиса
+	Status       string `json:"status"`
+	RejectReason string `json:"reject_reason,omitempty"`
+	// Последнее исполнение, если ExecutionType равен TRADE
+	TradeID         int64           `json:"trade_id,omitempty"`
+	LastFilledQty   decimal.Decimal `json:"last_filled_qty"`
+	LastFilledPrice decimal.Decimal `json:"last_filled_price"`
+	IsMaker         bool            `json:"is_maker"`
+	Commission      decimal.Decimal `json:"commission"`
+	CommissionAsset string          `json:"commission_asset,omitempty"`
+	// Исполнено с начала жизни ордера
+	CumulativeFilledQty decimal.Decimal `json:"cumulative_filled_qty"`
+	CumulativeQuoteQty  decimal.Decimal `json:"cumulative_quote_qty"`
+	EventTime           time.Time       `json:"event_time"`
+	TransactionTime     time.Time       `json:"transaction_time"`
+}
+
+// Fill исполнение ордера: одна сделка из отчета об исполнении с типом TRADE
+type Fill struct {
+	Symbol          string          `json:"symbol"`
+	ClientOrderID   string          `json:"client_order_id"`
+	BinanceID       int64           `json:"binance_id"`
+	TradeID         int64           `json:"trade_id"`
+	Side            string          `json:"side"`
+	Quantity        decimal.Decimal `json:"quantity"`
+	Price           decimal.Decimal `json:"price"`
+	Commission      decimal.Decimal `json:"commission"`
+	CommissionAsset string          `json:"commission_asset,omitempty"`
+	IsMaker         bool            `json:"is_maker"`
+	Time            time.Time       `json:"time"`
+}
+
+// AccountPosition балансы активов, изменившиеся после операции на бирже
+type AccountPosition struct {
+	Balances   []Balance `json:"balances"`
+	EventTime  time.Time `json:"event_time"`
+	UpdateTime time.Time `json:"update_time"`
+}
+
+// Balance баланс одного актива
+type Balance struct {
+	Asset  string          `json:"asset"`
+	Free   decimal.Decimal `json:"free"`
+	Locked decimal.Decimal `json:"locked"`
+}
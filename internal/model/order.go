@@ -1,18 +1,109 @@
 package model
 
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Статусы отправки заявки на биржу (поле OrderApiStatus)
+const (
+	OrderApiStatusSuccess = "success"
+	OrderApiStatusError   = "error"
+	// Ордер прошел проверку биржи без размещения (dry_run)
+	OrderApiStatusDryRun = "dry_run"
+)
+
+// Этапы обработки, на которых ордер может быть отклонен (поле FailedStage)
+const (
+	StageDecode    = "decode"
+	StageAuthorize = "authorize"
+	StageValidate  = "validate"
+	StageRisk      = "risk"
+	StageExchange  = "exchange"
+)
+
+// Order команда над ордером и результат ее выполнения. Цены и количества хранятся как точные десятичные числа:
+// в JSON принимаются и строки ("0.00012345"), и числа, а отдаются строками
 type Order struct {
-	ID         uint    `json:"id"`
-	Symbol     string  `json:"symbol"`
-	Side       string  `json:"side"`
-	Quantity   float32 `json:"quantity"`
-	Price      float32 `json:"price"`
-	Status     string  `json:"status"`
-	TimeStamp  string  `json:"timestamp"`
-	BinanceID  int64   `json:"binance_id"`
-	StrategyID int64   `json:"strategy_id"`
+	ID       uint            `json:"id"`
+	Symbol   string          `json:"symbol"`
+	Side     string          `json:"side"`
+	Quantity decimal.Decimal `json:"quantity"`
+	Price    decimal.Decimal `json:"price"`
+	// Статус жизненного цикла ордера, одна из констант Status*. Задается сервисом
+	Status string `json:"status"`
+	// Время последней смены статуса
+	StatusUpdatedAt time.Time `json:"status_updated_at"`
+	// Тип ордера Binance: LIMIT, MARKET, STOP_LOSS, STOP_LOSS_LIMIT, TAKE_PROFIT, TAKE_PROFIT_LIMIT, LIMIT_MAKER.
+	// Если не задан, ордер считается LIMIT
+	Type string `json:"type"`
+	// Срок действия ордера: GTC, IOC, FOK. Для типов, где он обязателен, по умолчанию GTC
+	TimeInForce string `json:"time_in_force"`
+	// Цена срабатывания для STOP_LOSS*, TAKE_PROFIT*
+	StopPrice decimal.Decimal `json:"stop_price"`
+	// Сумма в котируемой валюте для MARKET вместо quantity
+	QuoteOrderQty decimal.Decimal `json:"quote_order_qty"`
+	// Видимая часть айсберг-ордера
+	IcebergQty decimal.Decimal `json:"iceberg_qty"`
+	TimeStamp  string          `json:"timestamp"`
+	BinanceID  int64           `json:"binance_id"`
+	StrategyID int64           `json:"strategy_id"`
+	// Идентификатор ордера на стороне клиента, отправляется в Binance как newClientOrderId.
+	// Если не задан, формируется из координат сообщения кафки, поэтому повторно доставленная команда
+	// получает тот же идентификатор
+	ClientOrderID string `json:"client_order_id"`
+
+	// Исполненное количество и сумма в котируемой валюте по ответу биржи на размещение или редактирование.
+	// Дальнейшее исполнение приходит в отчетах потока пользовательских данных
+	ExecutedQty        decimal.Decimal `json:"executed_qty"`
+	CumulativeQuoteQty decimal.Decimal `json:"cumulative_quote_qty"`
 
 	// Что делать с ордером: place,cancel,edit
 	Action string `json:"action"`
 	// Статус заявки, успешно отправлено или нет.
 	OrderApiStatus string `json:"order_api_status"`
+	// Текст ошибки биржи, если заявка не выполнена
+	Error string `json:"error,omitempty"`
+	// Этап, на котором заявка не выполнена: validate, risk или exchange
+	FailedStage string `json:"failed_stage,omitempty"`
+	// Ошибка временная, и команду можно выполнить повторно
+	Retryable bool `json:"retryable,omitempty"`
+	// Номер попытки выполнения команды, начиная с нуля
+	Attempt int `json:"attempt"`
+
+	// Режим edit_order: STOP_ON_FAILURE (по умолчанию) не размещает новый ордер, если отмена не удалась,
+	// ALLOW_FAILURE размещает новый ордер независимо от результата отмены
+	CancelReplaceMode string `json:"cancel_replace_mode,omitempty"`
+	// Результаты edit_order: SUCCESS, FAILURE или NOT_ATTEMPTED для отмены старого и размещения нового ордера
+	CancelResult   string `json:"cancel_result,omitempty"`
+	NewOrderResult string `json:"new_order_result,omitempty"`
+	CancelError    string `json:"cancel_error,omitempty"`
+	NewOrderError  string `json:"new_order_error,omitempty"`
+	// ID отмененного ордера при edit_order. BinanceID после редактирования содержит ID нового ордера
+	ReplacedBinanceID int64 `json:"replaced_binance_id,omitempty"`
+
+	// Только проверить place_order на бирже через /api/v3/order/test: биржа выполняет все проверки
+	// и подпись, но не создает ордер
+	DryRun bool `json:"dry_run,omitempty"`
+	// Вернуть при проверке ставки комиссии для ордера
+	ComputeCommissionRates bool `json:"compute_commission_rates,omitempty"`
+	// Ставки комиссии из проверки ордера, если они запрошены
+	CommissionRates *CommissionRates `json:"commission_rates,omitempty"`
+
+	// Ключ исходного сообщения кафки. Нужен, чтобы зафиксировать смещение после публикации результата.
+	// Пустой, если ордер пришел не из кафки.
+	MessageKey string `json:"-"`
+}
+
+// CommissionRates ставки комиссии для ордера в долях от сделки
+type CommissionRates struct {
+	Maker decimal.Decimal `json:"maker"`
+	Taker decimal.Decimal `json:"taker"`
+	// TaxMaker и TaxTaker налоговая часть комиссии
+	TaxMaker decimal.Decimal `json:"tax_maker"`
+	TaxTaker decimal.Decimal `json:"tax_taker"`
+	// Discount скидка при оплате комиссии активом DiscountAsset, если она включена
+	DiscountAsset string          `json:"discount_asset,omitempty"`
+	Discount      decimal.Decimal `json:"discount"`
 }
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+// Статусы жизненного цикла ордера (поле Status)
+const (
+	// Команда получена сервисом
+	StatusReceived = "RECEIVED"
+	// Команда прошла проверку
+	StatusValidated = "VALIDATED"
+	// Запрос к бирже поставлен в очередь ограничителя запросов
+	StatusQueued = "QUEUED"
+	// Запрос отправлен на биржу
+	StatusSent = "SENT"
+	// Статусы ордера на бирже
+	StatusNew             = "NEW"
+	StatusPartiallyFilled = "PARTIALLY_FILLED"
+	StatusFilled          = "FILLED"
+	StatusCanceled        = "CANCELED"
+	StatusRejected        = "REJECTED"
+	StatusExpired         = "EXPIRED"
+	// Команда не выполнена из-за временной ошибки и может быть повторена
+	StatusFailed = "FAILED"
+	// Ордер проверен биржей без размещения (dry_run)
+	StatusTested = "TESTED"
+)
+
+// statusTransitions допустимые переходы между статусами. Пустой статус у команды, которую сервис еще не видел
+var statusTransitions = map[string][]string{
+	"":                    {StatusReceived},
+	StatusReceived:        {StatusValidated, StatusRejected, StatusFailed},
+	StatusValidated:       {StatusQueued, StatusRejected, StatusFailed},
+	StatusQueued:          {StatusSent, StatusRejected, StatusFailed},
+	StatusSent:            {StatusNew, StatusPartiallyFilled, StatusFilled, StatusCanceled, StatusRejected, StatusExpired, StatusFailed, StatusTested},
+	StatusNew:             {StatusPartiallyFilled, StatusFilled, StatusCanceled, StatusExpired},
+	StatusPartiallyFilled: {StatusPartiallyFilled, StatusFilled, StatusCanceled, StatusExpired},
+	StatusFailed:          {StatusReceived},
+}
+
+// IsFinalStatus сообщает, что ордер в статусе status больше не может измениться
+func IsFinalStatus(status string) bool {
+	switch status {
+	case StatusFilled, StatusCanceled, StatusRejected, StatusExpired, StatusTested:
+		return true
+	}
+	return false
+}
+
+// IsExchangeStatus сообщает, что статус status получен от биржи
+func IsExchangeStatus(status string) bool {
+	switch status {
+	case StatusNew, StatusPartiallyFilled, StatusFilled, StatusCanceled, StatusExpired:
+		return true
+	}
+	return false
+}
+
+// CanTransition сообщает, допустим ли переход из статуса from в статус to
+func CanTransition(from, to string) bool {
+	for _, status := range statusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderEvent событие смены статуса ордера. По событиям с одним ClientOrderID можно проследить ордер
+// от получения команды до исполнения на бирже.
+type OrderEvent struct {
+	ClientOrderID string    `json:"client_order_id"`
+	BinanceID     int64     `json:"binance_id"`
+	StrategyID    int64     `json:"strategy_id"`
+	Symbol        string    `json:"symbol"`
+	Action        string    `json:"action"`
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	Reason        string    `json:"reason,omitempty"`
+	At            time.Time `json:"at"`
+}
+
+// Transition переводит ордер в статус to и возвращает событие перехода. Недопустимый переход не выполняется.
+func (o *Order) Transition(to, reason string) (OrderEvent, error) {
+	if !CanTransition(o.Status, to) {
+		return OrderEvent{}, fmt.Errorf("недопустимый переход статуса ордера %s -> %s", o.Status, to)
+	}
+	return o.setStatus(to, reason), nil
+}
+
+// Repair переводит ордер в статус биржи to, найденный при сверке с биржей. Кроме допустимых переходов разрешен
+// переход из статусов, которые сервис назначает до ответа биржи: ордер в SENT после сбоя или в FAILED после
+// временной ошибки мог быть размещен, и статус биржи главнее
+func (o *Order) Repair(to, reason string) (OrderEvent, error) {
+	if CanTransition(o.Status, to) || (IsExchangeStatus(to) && !IsExchangeStatus(o.Status) && !IsFinalStatus(o.Status)) {
+		return o.setStatus(to, reason), nil
+	}
+	return OrderEvent{}, fmt.Errorf("недопустимый переход статуса ордера %s -> %s", o.Status, to)
+}
+
+func (o *Order) setStatus(to, reason string) OrderEvent {
+	event := OrderEvent{
+		ClientOrderID: o.ClientOrderID,
+		BinanceID:     o.BinanceID,
+		StrategyID:    o.StrategyID,
+		Symbol:        o.Symbol,
+		Action:        o.Action,
+		From:          o.Status,
+		To:            to,
+		Reason:        reason,
+		At:            time.Now().UTC(),
+	}
+	o.Status = to
+	o.StatusUpdatedAt = event.At
+	return event
+}
@@ -0,0 +1,140 @@
+package request
+
+import (
+	"app/internal/logger"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limit ограничение расхода Max за интервал Interval
+type Limit struct {
+	Interval time.Duration
+	Max      int
+}
+
+// Cost расход запроса по видам ограничений, например вес запроса и число создаваемых ордеров
+type Cost map[string]int
+
+// limitWindow окно одного ограничения. Окна выровнены по границам интервала, как на бирже:
+// минутное окно начинается в начале минуты, дневное — в полночь UTC
+type limitWindow struct {
+	Limit
+	start time.Time
+	used  int
+}
+
+// roll начинает новое окно, если текущее закончилось к моменту now
+func (w *limitWindow) roll(now time.Time) {
+	start := now.Truncate(w.Interval)
+	if start.After(w.start) {
+		w.start = start
+		w.used = 0
+	}
+}
+
+// RateLimiter распределяет запросы так, чтобы расход по каждому ограничению не превышал Max за его интервал.
+// Пока запас есть, запросы не задерживаются. Расход учитывается при резервировании и уточняется по фактическому
+// расходу, который сообщает сервер, поэтому учитываются и запросы в обход ограничителя.
+type RateLimiter struct {
+	mu      sync.Mutex
+	windows map[string][]*limitWindow
+}
+
+// NewRateLimiter создает ограничитель без ограничений. Ограничения задаются через SetLimits
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{windows: make(map[string][]*limitWindow)}
+}
+
+// SetLimits заменяет ограничения вида kind. Расход в окнах с тем же интервалом сохраняется
+func (l *RateLimiter) SetLimits(kind string, limits []Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	windows := make([]*limitWindow, 0, len(limits))
+	for _, limit := range limits {
+		if limit.Interval <= 0 || limit.Max <= 0 {
+			continue
+		}
+		window := &limitWindow{Limit: limit}
+		if old := l.window(kind, limit.Interval); old != nil {
+			window.start = old.start
+			window.used = old.used
+		}
+		windows = append(windows, window)
+	}
+	l.windows[kind] = windows
+}
+
+// Observe учитывает расход used по ограничению kind с интервалом interval, который сообщил сервер.
+// Меньший расход, чем уже учтенный, не уменьшает его: ответы на более ранние запросы могут прийти позже
+func (l *RateLimiter) Observe(kind string, interval time.Duration, used int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	window := l.window(kind, interval)
+	if window == nil {
+		return
+	}
+	window.roll(time.Now())
+	if used > window.used {
+		window.used = used
+	}
+}
+
+// Wait ждет, пока во всех окнах хватит запаса на расход cost, и резервирует его.
+// Возвращает ошибку ctx, если он истек раньше
+func (l *RateLimiter) Wait(ctx context.Context, cost Cost) error {
+	for {
+		l.mu.Lock()
+		wait := l.reserve(cost, time.Now())
+		l.mu.Unlock()
+		if wait <= 0 {
+			return nil
+		}
+
+		logger.Log.Warn(fmt.Sprintf("Лимит запросов исчерпан, запрос отложен на %v", wait))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// reserve резервирует расход cost, если на него хватает запаса, и возвращает 0.
+// Иначе ничего не резервирует и возвращает время до начала окна, в котором запас появится
+func (l *RateLimiter) reserve(cost Cost, now time.Time) time.Duration {
+	var wait time.Duration
+	for kind, amount := range cost {
+		for _, window := range l.windows[kind] {
+			window.roll(now)
+			// Запрос дороже всего ограничения выполняется в пустом окне, иначе он не выполнится никогда
+			if window.used > 0 && window.used+amount > window.Max {
+				wait = max(wait, window.start.Add(window.Interval).Sub(now))
+			}
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	for kind, amount := range cost {
+		for _, window := range l.windows[kind] {
+			window.used += amount
+		}
+	}
+	return 0
+}
+
+func (l *RateLimiter) window(kind string, interval time.Duration) *limitWindow {
+	for _, window := range l.windows[kind] {
+		if window.Interval == interval {
+			return window
+		}
+	}
+	return nil
+}
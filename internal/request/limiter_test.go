@@ -0,0 +1,160 @@
+package request
+
+import (
+	"app/internal/logger"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = logger.NewConsoleLogger()
+	os.Exit(m.Run())
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	minute := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		// used расход, уже учтенный в текущем окне
+		used int
+		cost Cost
+		now  time.Time
+		wait time.Duration
+	}{
+		{"запас есть", 5, Cost{"weight": 5}, minute.Add(10 * time.Second), 0},
+		{"запас исчерпан", 8, Cost{"weight": 5}, minute.Add(10 * time.Second), 50 * time.Second},
+		{"новое окно", 10, Cost{"weight": 5}, minute.Add(time.Minute), 0},
+		{"запрос дороже ограничения в пустом окне", 0, Cost{"weight": 20}, minute, 0},
+		{"ограничение другого вида", 10, Cost{"orders": 1}, minute.Add(10 * time.Second), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter()
+			l.SetLimits("weight", []Limit{{Interval: time.Minute, Max: 10}})
+			window := l.window("weight", time.Minute)
+			window.start = minute
+			window.used = tt.used
+
+			if wait := l.reserve(tt.cost, tt.now); wait != tt.wait {
+				t.Fatalf("ожидание %v, ожидалось %v", wait, tt.wait)
+			}
+			// Отложенный запрос ничего не резервирует
+			want := tt.used
+			if tt.wait == 0 && tt.now.Sub(minute) >= time.Minute {
+				want = 0
+			}
+			if tt.wait == 0 {
+				want += tt.cost["weight"]
+			}
+			if window.used != want {
+				t.Fatalf("расход %d, ожидался %d", window.used, want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterReservesAllWindows(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC)
+	l := NewRateLimiter()
+	l.SetLimits("orders", []Limit{
+		{Interval: 10 * time.Second, Max: 5},
+		{Interval: 24 * time.Hour, Max: 6},
+	})
+	for i := 0; i < 5; i++ {
+		if wait := l.reserve(Cost{"orders": 1}, now); wait != 0 {
+			t.Fatalf("ордер %d отложен на %v", i, wait)
+		}
+	}
+	// Секундное окно исчерпано, дневное нет
+	if wait := l.reserve(Cost{"orders": 1}, now); wait != 10*time.Second {
+		t.Fatalf("ожидание %v, ожидалось окончание 10-секундного окна", wait)
+	}
+	if wait := l.reserve(Cost{"orders": 1}, now.Add(10*time.Second)); wait != 0 {
+		t.Fatalf("ордер в новом окне отложен на %v", wait)
+	}
+	// Теперь исчерпано дневное окно, запас появится в полночь UTC
+	if wait := l.reserve(Cost{"orders": 1}, now.Add(20*time.Second)); wait != 14*time.Hour-50*time.Second {
+		t.Fatalf("ожидание %v, ожидалось до полуночи", wait)
+	}
+}
+
+func TestRateLimiterObserve(t *testing.T) {
+	l := NewRateLimiter()
+	l.SetLimits("weight", []Limit{{Interval: time.Minute, Max: 100}})
+	window := l.window("weight", time.Minute)
+
+	l.Observe("weight", time.Minute, 40)
+	// Ответ на более ранний запрос не уменьшает расход
+	l.Observe("weight", time.Minute, 30)
+	// Ограничение, которое не задано, не учитывается
+	l.Observe("weight", time.Hour, 90)
+	if window.used != 40 {
+		t.Fatalf("расход %d, ожидался 40", window.used)
+	}
+
+	// Новые ограничения с тем же интервалом сохраняют расход
+	l.SetLimits("weight", []Limit{{Interval: time.Minute, Max: 50}, {Interval: 0, Max: 10}})
+	if got := l.window("weight", time.Minute); got.used != 40 || got.Max != 50 {
+		t.Fatalf("окно после замены ограничений: %+v", got.Limit)
+	}
+	if len(l.windows["weight"]) != 1 {
+		t.Fatalf("окон %d, ограничение без интервала должно быть пропущено", len(l.windows["weight"]))
+	}
+}
+
+func TestRateLimiterWaitStopsWithContext(t *testing.T) {
+	l := NewRateLimiter()
+	l.SetLimits("weight", []Limit{{Interval: time.Hour, Max: 1}})
+	if err := l.Wait(context.Background(), Cost{"weight": 1}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, Cost{"weight": 1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ошибка %v, ожидалось истечение контекста", err)
+	}
+}
+
+// Запрос, ожидающий запаса, отклоняется при остановке обработчика, а не выполняется в обход ограничения
+func TestHandlerRejectsRequestWaitingForLimit(t *testing.T) {
+	handler, err := NewRequestHandler(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := NewRateLimiter()
+	limiter.SetLimits("weight", []Limit{{Interval: time.Hour, Max: 1}})
+	handler.SetRateLimiter(limiter)
+	if err := handler.StartProcessing(0); err != nil {
+		t.Fatal(err)
+	}
+
+	executed := 0
+	if err := handler.SyncHandleWeightedRequest(Cost{"weight": 1}, func() error {
+		executed++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- handler.SyncHandleWeightedRequest(Cost{"weight": 1}, func() error {
+			executed++
+			return nil
+		})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	time.Sleep(20 * time.Millisecond)
+	handler.Shutdown(ctx)
+	if err := <-result; !errors.Is(err, ErrShuttingDown) {
+		t.Fatalf("ошибка %v, ожидалась ErrShuttingDown", err)
+	}
+	if executed != 1 {
+		t.Fatalf("выполнено %d запросов, ожидался 1", executed)
+	}
+}
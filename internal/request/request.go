@@ -10,48 +10,110 @@ import (
 
 type Request func() error
 
+// Gate разрешает выполнение запросов. Wait блокирует, пока запросы выполнять нельзя, и возвращает ошибку ctx,
+// если он истек раньше
+type Gate interface {
+	Wait(ctx context.Context) error
+}
+
+var (
+	// ErrNotProcessing возвращается при добавлении запроса в незапущенный или останавливаемый обработчик
+	ErrNotProcessing = errors.New("не удаться добавить запрос в обработчик-откладыватель. Обработка не запущена")
+	// ErrShuttingDown получают запросы, которые не успели выполниться до истечения времени на остановку
+	ErrShuttingDown = errors.New("запрос отклонен: обработчик остановлен до выполнения запроса")
+)
+
+// queuedRequest запрос в очереди. reject вызывается вместо выполнения, если запрос отклонен при остановке.
+// cost расход запроса, на который ограничитель должен выделить запас перед выполнением
+type queuedRequest struct {
+	run    Request
+	reject func(err error)
+	cost   Cost
+}
+
 type RequestHandler struct {
-	requests            chan Request
-	lowPriorityRequests chan Request
+	requests            chan queuedRequest
+	lowPriorityRequests chan queuedRequest
 	ctx                 context.Context
 	cancel              context.CancelFunc
 	mu                  sync.Mutex
 	isProcessing        bool
+	// limiter ограничитель расхода запросов. nil, пока не вызван SetRateLimiter
+	limiter *RateLimiter
+	// gate приостанавливает очередь, пока не разрешит выполнение. nil, пока не вызван SetGate
+	gate Gate
+
+	// senders считает горутины, которые прошли проверку isProcessing и кладут запрос в очередь
+	senders sync.WaitGroup
+	// drain закрывается при плавной остановке: цикл обработки выполняет оставшиеся запросы и завершается
+	drain    chan struct{}
+	drainCtx context.Context
+	// done закрывается после завершения цикла обработки
+	done chan struct{}
 }
 
 func NewRequestHandler(bufferSize int64) (*RequestHandler, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	requestApp := RequestHandler{
-		requests:            make(chan Request, bufferSize),
-		lowPriorityRequests: make(chan Request, bufferSize),
+		requests:            make(chan queuedRequest, bufferSize),
+		lowPriorityRequests: make(chan queuedRequest, bufferSize),
 		ctx:                 ctx,
 		cancel:              cancel,
+		drain:               make(chan struct{}),
+		done:                make(chan struct{}),
 	}
 
 	return &requestApp, nil
 }
 
+// SetRateLimiter включает ограничитель: перед выполнением запроса с расходом ограничитель ждет запаса на него.
+// Вызывается до запуска обработки. Пауза между запросами при этом может быть нулевой
+func (app *RequestHandler) SetRateLimiter(limiter *RateLimiter) {
+	app.limiter = limiter
+}
+
+// SetGate включает приостановку очереди: перед выполнением каждого запроса обработчик ждет разрешения gate.
+// Вызывается до запуска обработки
+func (app *RequestHandler) SetGate(gate Gate) {
+	app.gate = gate
+}
+
 // HandleRequest добавляет запрос в очередь
 func (app *RequestHandler) HandleRequest(req Request) error {
+	return app.enqueue(app.requests, queuedRequest{run: req})
+}
+
+// HandlePriorityRequest добавляет низко-приоритетный запрос в очередь
+func (app *RequestHandler) HandleLowPriorityRequest(req Request) error {
+	return app.enqueue(app.lowPriorityRequests, queuedRequest{run: req})
+}
+
+func (app *RequestHandler) enqueue(queue chan queuedRequest, req queuedRequest) error {
 	app.mu.Lock()
 	if !app.isProcessing {
-		return errors.New("не удаться добавить запрос в обработчик-откладыватель. Обработка не запущена")
+		app.mu.Unlock()
+		return ErrNotProcessing
 	}
+	app.senders.Add(1)
 	app.mu.Unlock()
+	defer app.senders.Done()
 
-	app.requests <- req
+	queue <- req
 	return nil
 }
 
-// HandlePriorityRequest добавляет низко-приоритетный запрос в очередь
-func (app *RequestHandler) HandleLowPriorityRequest(req Request) error {
+// StartProcessing запускает ProcessRequests в отдельной горутине. В отличие от go ProcessRequests(...),
+// обработчик помечается запущенным до возврата из функции, поэтому запросы можно добавлять сразу.
+func (app *RequestHandler) StartProcessing(pause time.Duration) error {
 	app.mu.Lock()
-	if !app.isProcessing {
-		return errors.New("не удаться добавить запрос в обработчик-откладыватель. Обработка не запущена")
+	if app.isProcessing {
+		app.mu.Unlock()
+		return errors.New("невозможно запустить обработку запросов. Обработка уже запущена")
 	}
+	app.isProcessing = true
 	app.mu.Unlock()
 
-	app.lowPriorityRequests <- req
+	go app.processRequests(pause)
 	return nil
 }
 
@@ -66,21 +128,24 @@ func (app *RequestHandler) ProcessRequests(pause time.Duration) {
 	}
 	app.isProcessing = true
 	app.mu.Unlock()
+
+	app.processRequests(pause)
+}
+
+func (app *RequestHandler) processRequests(pause time.Duration) {
+	defer close(app.done)
 	for {
 		select {
 		case <-app.ctx.Done():
-			app.isProcessing = false
+			app.stopQueue()
+			return
+		case <-app.drain:
+			app.drainQueued(pause)
 			return
 		case req := <-app.requests:
-			err := req()
-			if err != nil {
-				logger.Log.Error("Ошибка при выполнении запроса: ", err)
-			}
+			app.execute(app.ctx, req, "Ошибка при выполнении запроса: ")
 		case req := <-app.lowPriorityRequests:
-			err := req()
-			if err != nil {
-				logger.Log.Error("Ошибка при выполнении приоритетного запроса: ", err)
-			}
+			app.execute(app.ctx, req, "Ошибка при выполнении приоритетного запроса: ")
 		}
 		time.Sleep(pause)
 	}
@@ -98,6 +163,7 @@ func (app *RequestHandler) ProcessRequestsWithDynamicPause(defaultPause time.Dur
 	}
 	app.isProcessing = true
 	app.mu.Unlock()
+	defer close(app.done)
 
 	currentPause := defaultPause
 	consecutiveRequests := 0
@@ -105,20 +171,17 @@ func (app *RequestHandler) ProcessRequestsWithDynamicPause(defaultPause time.Dur
 	for {
 		select {
 		case <-app.ctx.Done():
-			app.isProcessing = false
+			app.stopQueue()
+			return
+		case <-app.drain:
+			app.drainQueued(defaultPause)
 			return
 		case req := <-app.requests:
 			consecutiveRequests++
-			err := req()
-			if err != nil {
-				logger.Log.Error("Ошибка при выполнении запроса: ", err)
-			}
+			app.execute(app.ctx, req, "Ошибка при выполнении запроса: ")
 		case req := <-app.lowPriorityRequests:
 			consecutiveRequests++
-			err := req()
-			if err != nil {
-				logger.Log.Error("Ошибка при выполнении приоритетного запроса: ", err)
-			}
+			app.execute(app.ctx, req, "Ошибка при выполнении приоритетного запроса: ")
 		default:
 			// Если нет запросов, сбрасываем счетчик и паузу
 			consecutiveRequests = 0
@@ -138,12 +201,125 @@ func (app *RequestHandler) ProcessRequestsWithDynamicPause(defaultPause time.Dur
 	}
 }
 
-// StopProcessing останавливает обработку запросов
+// execute выполняет запрос, дождавшись разрешения gate и запаса на его расход. Если ctx истечет раньше,
+// запрос отклоняется
+func (app *RequestHandler) execute(ctx context.Context, req queuedRequest, errMessage string) {
+	if app.gate != nil {
+		if err := app.gate.Wait(ctx); err != nil {
+			app.reject(req, ErrShuttingDown)
+			return
+		}
+	}
+	if app.limiter != nil && len(req.cost) > 0 {
+		if err := app.limiter.Wait(ctx, req.cost); err != nil {
+			app.reject(req, ErrShuttingDown)
+			return
+		}
+	}
+	if err := req.run(); err != nil {
+		logger.Log.Error(errMessage, err)
+	}
+}
+
+// drainQueued выполняет запросы, оставшиеся в очереди, пока не истечет контекст остановки.
+// Запросы, которые не успели выполниться, отклоняются.
+func (app *RequestHandler) drainQueued(pause time.Duration) {
+	// Ожидание лимита прерывается и по истечении времени на остановку, и при немедленной остановке
+	ctx, cancel := context.WithCancel(app.drainCtx)
+	defer cancel()
+	stop := context.AfterFunc(app.ctx, cancel)
+	defer stop()
+
+	for {
+		if app.drainCtx.Err() != nil || app.ctx.Err() != nil {
+			app.rejectQueued(ErrShuttingDown)
+			return
+		}
+		select {
+		case req := <-app.requests:
+			app.execute(ctx, req, "Ошибка при выполнении запроса: ")
+		case req := <-app.lowPriorityRequests:
+			app.execute(ctx, req, "Ошибка при выполнении приоритетного запроса: ")
+		default:
+			return
+		}
+		time.Sleep(pause)
+	}
+}
+
+// stopQueue отклоняет запросы из очереди, пока не завершатся все начатые добавления в очередь
+func (app *RequestHandler) stopQueue() {
+	sendersDone := make(chan struct{})
+	go func() {
+		app.senders.Wait()
+		close(sendersDone)
+	}()
+
+	for {
+		select {
+		case req := <-app.requests:
+			app.reject(req, ErrShuttingDown)
+		case req := <-app.lowPriorityRequests:
+			app.reject(req, ErrShuttingDown)
+		case <-sendersDone:
+			app.rejectQueued(ErrShuttingDown)
+			return
+		}
+	}
+}
+
+// rejectQueued отклоняет все запросы, оставшиеся в очереди
+func (app *RequestHandler) rejectQueued(err error) {
+	for {
+		select {
+		case req := <-app.requests:
+			app.reject(req, err)
+		case req := <-app.lowPriorityRequests:
+			app.reject(req, err)
+		default:
+			return
+		}
+	}
+}
+
+func (app *RequestHandler) reject(req queuedRequest, err error) {
+	if req.reject != nil {
+		req.reject(err)
+		return
+	}
+	logger.Log.Warn("Запрос отклонен при остановке обработчика: ", err)
+}
+
+// StopProcessing немедленно останавливает обработку запросов. Запросы из очереди отклоняются с ErrShuttingDown
 func (app *RequestHandler) StopProcessing() {
+	app.mu.Lock()
+	app.isProcessing = false
+	app.mu.Unlock()
 	app.cancel() // Отменяем контекст
+}
+
+// Shutdown плавно останавливает обработку: новые запросы больше не принимаются, уже поставленные в очередь
+// выполняются до истечения ctx, а оставшиеся отклоняются с ErrShuttingDown. Возвращает управление после
+// завершения цикла обработки.
+func (app *RequestHandler) Shutdown(ctx context.Context) {
 	app.mu.Lock()
+	if !app.isProcessing {
+		app.mu.Unlock()
+		return
+	}
 	app.isProcessing = false
+	app.drainCtx = ctx
 	app.mu.Unlock()
+
+	// Дожидаемся, пока все начатые добавления попадут в очередь
+	app.senders.Wait()
+	close(app.drain)
+
+	// Если ctx истечет во время выполнения запроса, оставшиеся будут отклонены сразу после него.
+	// Запрос, который еще ждет разрешения gate или запаса ограничителя, отклоняется сразу
+	stop := context.AfterFunc(ctx, app.cancel)
+	defer stop()
+	<-app.done
 }
 
 // incrementPause - пример factor 1.5 увеличение времени на 50% после каждой "взрывной итерации"
@@ -162,19 +338,35 @@ func IncrementPause(factor float64, maxPause time.Duration) func(currentPause ti
 }
 
 // HandleRequest добавляет запрос в очередь и ждет выполнения функции.
+// Если запрос отклонен при остановке обработчика, возвращается ErrShuttingDown.
 func (app *RequestHandler) SyncHandleRequest(req Request) error {
+	return app.SyncHandleWeightedRequest(nil, req)
+}
+
+// SyncHandleWeightedRequest как SyncHandleRequest, но перед выполнением ждет, пока ограничитель
+// выделит запас на расход cost
+func (app *RequestHandler) SyncHandleWeightedRequest(cost Cost, req Request) error {
 
 	var err error
 	var wg sync.WaitGroup
 	wg.Add(1)
 
 	// Добавляем запрос в очередь
-	app.HandleRequest(func() error {
-		// После выполнения запроса освобождаем группу
-		defer wg.Done()
-		err = req()
-		return err
-	})
+	if handleErr := app.enqueue(app.requests, queuedRequest{
+		run: func() error {
+			// После выполнения запроса освобождаем группу
+			defer wg.Done()
+			err = req()
+			return err
+		},
+		reject: func(rejectErr error) {
+			err = rejectErr
+			wg.Done()
+		},
+		cost: cost,
+	}); handleErr != nil {
+		return handleErr
+	}
 
 	wg.Wait()
 	return err
@@ -0,0 +1,416 @@
+package store
+
+import (
+	"app/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Тип отчета об исполнении, в котором приходит сделка
+const executionTypeTrade = "TRADE"
+
+// ErrCommandConflict команда использует ClientOrderID, уже занятый командой другой стратегии или по другому символу
+var ErrCommandConflict = errors.New("client_order_id уже использован командой другой стратегии или символа")
+
+// orderRecord команда над ордером и результат ее выполнения. Команда однозначно задается парой
+// ClientOrderID и Action: повторная доставка и повтор после временной ошибки обновляют ту же запись.
+type orderRecord struct {
+	ID              uint      `gorm:"primaryKey"`
+	ClientOrderID   string    `gorm:"size:64;not null;uniqueIndex:idx_orders_command"`
+	Action          string    `gorm:"size:32;not null;uniqueIndex:idx_orders_command"`
+	BinanceID       int64     `gorm:"index"`
+	StrategyID      int64     `gorm:"index"`
+	Symbol          string    `gorm:"size:32;index"`
+	Status          string    `gorm:"size:32"`
+	StatusUpdatedAt time.Time `gorm:"not null"`
+	// Исполнено по данным потока пользовательских данных
+	FilledQty      decimal.Decimal `gorm:"type:numeric"`
+	FilledQuoteQty decimal.Decimal `gorm:"type:numeric"`
+	Attempt        int
+	OrderApiStatus string `gorm:"size:16"`
+	Error          string `gorm:"type:text"`
+	// Command команда в том виде, в котором ее получил сервис, Result ордер после выполнения с ответом биржи. JSON
+	Command   string `gorm:"type:text"`
+	Result    string `gorm:"type:text"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (orderRecord) TableName() string { return "orders" }
+
+// eventRecord переход статуса ордера
+type eventRecord struct {
+	ID            uint   `gorm:"primaryKey"`
+	ClientOrderID string `gorm:"size:64;index"`
+	BinanceID     int64  `gorm:"index"`
+	StrategyID    int64
+	Symbol        string `gorm:"size:32"`
+	Action        string `gorm:"size:32"`
+	From          string `gorm:"column:from_status;size:32"`
+	To            string `gorm:"column:to_status;size:32"`
+	Reason        string `gorm:"type:text"`
+	At            time.Time
+}
+
+func (eventRecord) TableName() string { return "order_events" }
+
+// fillRecord сделка по ордеру. Сделка однозначно задается символом и TradeID, поэтому отчеты,
+// повторно полученные после переподключения потока, не дублируются
+type fillRecord struct {
+	ID              uint            `gorm:"primaryKey"`
+	Symbol          string          `gorm:"size:32;not null;uniqueIndex:idx_order_fills_trade"`
+	TradeID         int64           `gorm:"not null;uniqueIndex:idx_order_fills_trade"`
+	ClientOrderID   string          `gorm:"size:64;index"`
+	BinanceID       int64           `gorm:"index"`
+	Side            string          `gorm:"size:8"`
+	Quantity        decimal.Decimal `gorm:"type:numeric"`
+	Price           decimal.Decimal `gorm:"type:numeric"`
+	Commission      decimal.Decimal `gorm:"type:numeric"`
+	CommissionAsset string          `gorm:"size:16"`
+	IsMaker         bool
+	Time            time.Time
+}
+
+func (fillRecord) TableName() string { return "order_fills" }
+
+// OrderFilter условия поиска ордеров. Пустые поля не учитываются
+type OrderFilter struct {
+	StrategyID    int64
+	Symbol        string
+	ClientOrderID string
+	BinanceID     int64
+	// Ордер относится к одной из стратегий или одному из символов
+	StrategyIDs []int64
+	Symbols     []string
+	// Ордер находится в одном из статусов
+	Statuses []string
+	// Команда с одним из действий
+	Actions []string
+	// Максимальное число записей, 0 без ограничения
+	Limit int
+}
+
+// SaveCommand сохраняет полученную команду. Команды без ClientOrderID не сохраняются.
+// Возвращает true, если команда с тем же ClientOrderID и действием уже сохранялась, то есть доставлена повторно.
+// Если сохраненная команда относится к другой стратегии или символу, запись не меняется и возвращается
+// ErrCommandConflict
+func (s *Store) SaveCommand(order model.Order) (bool, error) {
+	if order.ClientOrderID == "" {
+		return false, nil
+	}
+	command, err := json.Marshal(order)
+	if err != nil {
+		return false, err
+	}
+
+	var seen bool
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		var record orderRecord
+		err := tx.Where("client_order_id = ? AND action = ?", order.ClientOrderID, order.Action).Take(&record).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			record = newOrderRecord(order)
+			record.Command = string(command)
+			return tx.Create(&record).Error
+		}
+		if err != nil {
+			return err
+		}
+		if !record.sameCommand(order) {
+			return ErrCommandConflict
+		}
+		seen = true
+		return tx.Model(&record).Updates(map[string]interface{}{
+			"status":            order.Status,
+			"status_updated_at": order.StatusUpdatedAt,
+			"attempt":           order.Attempt,
+		}).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения команды %s: %w", order.ClientOrderID, err)
+	}
+	return seen, nil
+}
+
+// sameCommand сообщает, что запись относится к команде order, а не к чужой команде с тем же ClientOrderID
+func (r orderRecord) sameCommand(order model.Order) bool {
+	return r.StrategyID == order.StrategyID && r.Symbol == order.Symbol
+}
+
+// SaveResult сохраняет результат выполнения команды. Если publish, в той же транзакции результат ставится
+// в очередь на публикацию в топик готовых ордеров, поэтому состояние в базе и опубликованные результаты
+// не расходятся. Если поток пользовательских данных уже сообщил о более позднем статусе ордера,
+// статус в хранилище не откатывается
+func (s *Store) SaveResult(order model.Order, publish bool) error {
+	result, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if order.ClientOrderID != "" {
+			if err := saveResult(tx, order, string(result)); err != nil {
+				return err
+			}
+		}
+		if !publish {
+			return nil
+		}
+		return tx.Create(&outboxRecord{ClientOrderID: order.ClientOrderID, Payload: string(result)}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения результата команды %s: %v", order.ClientOrderID, err)
+	}
+	return nil
+}
+
+// saveResult обновляет запись команды результатом ее выполнения или создает запись, если команда не сохранялась
+func saveResult(tx *gorm.DB, order model.Order, result string) error {
+	var record orderRecord
+	err := tx.Where("client_order_id = ? AND action = ?", order.ClientOrderID, order.Action).Take(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		record = newOrderRecord(order)
+		record.Command = result
+		record.Result = result
+		return tx.Create(&record).Error
+	}
+	if err != nil {
+		return err
+	}
+	if !record.sameCommand(order) {
+		// Результат отклоненной команды с чужим ClientOrderID не перезаписывает запись исходной команды
+		return nil
+	}
+
+	updates := map[string]interface{}{
+		"binance_id":       order.BinanceID,
+		"strategy_id":      order.StrategyID,
+		"symbol":           order.Symbol,
+		"attempt":          order.Attempt,
+		"order_api_status": order.OrderApiStatus,
+		"error":            order.Error,
+		"result":           result,
+	}
+	if !isLaterExchangeStatus(record.Status, order.Status) {
+		updates["status"] = order.Status
+		updates["status_updated_at"] = order.StatusUpdatedAt
+	}
+	return tx.Model(&record).Updates(updates).Error
+}
+
+// SaveEvent сохраняет переход статуса ордера
+func (s *Store) SaveEvent(event model.OrderEvent) error {
+	record := eventRecord{
+		ClientOrderID: event.ClientOrderID,
+		BinanceID:     event.BinanceID,
+		StrategyID:    event.StrategyID,
+		Symbol:        event.Symbol,
+		Action:        event.Action,
+		From:          event.From,
+		To:            event.To,
+		Reason:        event.Reason,
+		At:            event.At,
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		return fmt.Errorf("ошибка сохранения события ордера %s: %v", event.ClientOrderID, err)
+	}
+	return nil
+}
+
+// SaveExecutionReport обновляет статус и исполненное количество ордера по отчету биржи и сохраняет сделку,
+// если отчет ее содержит. Отчет относится к команде размещения или редактирования с тем же ClientOrderID.
+func (s *Store) SaveExecutionReport(report model.ExecutionReport) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var records []orderRecord
+		// BinanceID еще не сохранен, если отчет пришел раньше ответа на запрос
+		err := tx.Where("client_order_id = ? AND binance_id IN ?", report.ClientOrderID, []int64{report.BinanceID, 0}).
+			Find(&records).Error
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if model.IsExchangeStatus(record.Status) && record.Status != report.Status && !model.CanTransition(record.Status, report.Status) {
+				continue
+			}
+			err := tx.Model(&record).Updates(map[string]interface{}{
+				"binance_id":        report.BinanceID,
+				"status":            report.Status,
+				"status_updated_at": report.TransactionTime,
+				"filled_qty":        report.CumulativeFilledQty,
+				"filled_quote_qty":  report.CumulativeQuoteQty,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if report.ExecutionType != executionTypeTrade {
+			return nil
+		}
+		fill := fillRecord{
+			Symbol:          report.Symbol,
+			TradeID:         report.TradeID,
+			ClientOrderID:   report.ClientOrderID,
+			BinanceID:       report.BinanceID,
+			Side:            report.Side,
+			Quantity:        report.LastFilledQty,
+			Price:           report.LastFilledPrice,
+			Commission:      report.Commission,
+			CommissionAsset: report.CommissionAsset,
+			IsMaker:         report.IsMaker,
+			Time:            report.TransactionTime,
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&fill).Error
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения отчета об исполнении ордера %d: %v", report.BinanceID, err)
+	}
+	return nil
+}
+
+// Orders возвращает ордера, подходящие под filter, начиная с последних
+func (s *Store) Orders(filter OrderFilter) ([]model.Order, error) {
+	query := s.db.Order("id DESC")
+	if filter.StrategyID != 0 {
+		query = query.Where("strategy_id = ?", filter.StrategyID)
+	}
+	if filter.Symbol != "" {
+		query = query.Where("symbol = ?", filter.Symbol)
+	}
+	if filter.ClientOrderID != "" {
+		query = query.Where("client_order_id = ?", filter.ClientOrderID)
+	}
+	if filter.BinanceID != 0 {
+		query = query.Where("binance_id = ?", filter.BinanceID)
+	}
+	if len(filter.StrategyIDs) > 0 {
+		query = query.Where("strategy_id IN ?", filter.StrategyIDs)
+	}
+	if len(filter.Symbols) > 0 {
+		query = query.Where("symbol IN ?", filter.Symbols)
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if len(filter.Actions) > 0 {
+		query = query.Where("action IN ?", filter.Actions)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var records []orderRecord
+	if err := query.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("ошибка поиска ордеров: %v", err)
+	}
+	orders := make([]model.Order, 0, len(records))
+	for _, record := range records {
+		order, err := record.order()
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+// Symbols возвращает символы, по которым сервис выполнял команды
+func (s *Store) Symbols() ([]string, error) {
+	var symbols []string
+	if err := s.db.Model(&orderRecord{}).Where("symbol <> ''").Distinct().Order("symbol").Pluck("symbol", &symbols).Error; err != nil {
+		return nil, fmt.Errorf("ошибка поиска символов: %v", err)
+	}
+	return symbols, nil
+}
+
+// Events возвращает переходы статусов ордера clientOrderID в порядке их выполнения
+func (s *Store) Events(clientOrderID string) ([]model.OrderEvent, error) {
+	var records []eventRecord
+	if err := s.db.Where("client_order_id = ?", clientOrderID).Order("at, id").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("ошибка поиска событий ордера %s: %v", clientOrderID, err)
+	}
+	events := make([]model.OrderEvent, 0, len(records))
+	for _, record := range records {
+		events = append(events, model.OrderEvent{
+			ClientOrderID: record.ClientOrderID,
+			BinanceID:     record.BinanceID,
+			StrategyID:    record.StrategyID,
+			Symbol:        record.Symbol,
+			Action:        record.Action,
+			From:          record.From,
+			To:            record.To,
+			Reason:        record.Reason,
+			At:            record.At,
+		})
+	}
+	return events, nil
+}
+
+// Fills возвращает сделки по ордеру binanceID в порядке их исполнения
+func (s *Store) Fills(binanceID int64) ([]model.Fill, error) {
+	var records []fillRecord
+	if err := s.db.Where("binance_id = ?", binanceID).Order("time, trade_id").Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("ошибка поиска сделок ордера %d: %v", binanceID, err)
+	}
+	fills := make([]model.Fill, 0, len(records))
+	for _, record := range records {
+		fills = append(fills, model.Fill{
+			Symbol:          record.Symbol,
+			ClientOrderID:   record.ClientOrderID,
+			BinanceID:       record.BinanceID,
+			TradeID:         record.TradeID,
+			Side:            record.Side,
+			Quantity:        record.Quantity,
+			Price:           record.Price,
+			Commission:      record.Commission,
+			CommissionAsset: record.CommissionAsset,
+			IsMaker:         record.IsMaker,
+			Time:            record.Time,
+		})
+	}
+	return fills, nil
+}
+
+func newOrderRecord(order model.Order) orderRecord {
+	return orderRecord{
+		ClientOrderID:   order.ClientOrderID,
+		Action:          order.Action,
+		BinanceID:       order.BinanceID,
+		StrategyID:      order.StrategyID,
+		Symbol:          order.Symbol,
+		Status:          order.Status,
+		StatusUpdatedAt: order.StatusUpdatedAt,
+		Attempt:         order.Attempt,
+		OrderApiStatus:  order.OrderApiStatus,
+		Error:           order.Error,
+	}
+}
+
+// order восстанавливает ордер из результата выполнения, а если его еще нет, из команды.
+// Статус и BinanceID берутся из записи, потому что их обновляет поток пользовательских данных
+func (r orderRecord) order() (model.Order, error) {
+	data := r.Result
+	if data == "" {
+		data = r.Command
+	}
+	var order model.Order
+	if err := json.Unmarshal([]byte(data), &order); err != nil {
+		return model.Order{}, fmt.Errorf("ошибка разбора ордера %s: %v", r.ClientOrderID, err)
+	}
+	order.ID = r.ID
+	order.BinanceID = r.BinanceID
+	order.Status = r.Status
+	order.StatusUpdatedAt = r.StatusUpdatedAt
+	return order, nil
+}
+
+// isLaterExchangeStatus сообщает, что сохраненный статус биржи stored наступает после статуса status,
+// например исполнение из потока пользовательских данных сохранено раньше ответа на запрос размещения
+func isLaterExchangeStatus(stored, status string) bool {
+	return model.IsExchangeStatus(stored) && stored != status && model.CanTransition(status, stored)
+}
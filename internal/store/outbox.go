@@ -0,0 +1,63 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// outboxRecord результат команды, ожидающий публикации в топик готовых ордеров. Записывается в одной транзакции
+// с состоянием ордера. SentAt заполняется после публикации
+type outboxRecord struct {
+	ID            uint   `gorm:"primaryKey"`
+	ClientOrderID string `gorm:"size:64"`
+	// Ордер с результатом выполнения в JSON, в том виде, в котором он публикуется
+	Payload   string `gorm:"type:text;not null"`
+	CreatedAt time.Time
+	SentAt    *time.Time `gorm:"index"`
+}
+
+func (outboxRecord) TableName() string { return "order_outbox" }
+
+// OutboxMessage результат, ожидающий публикации
+type OutboxMessage struct {
+	ID            uint
+	ClientOrderID string
+	Payload       []byte
+}
+
+// PendingOutbox возвращает до limit неопубликованных результатов в порядке их сохранения
+func (s *Store) PendingOutbox(limit int) ([]OutboxMessage, error) {
+	var records []outboxRecord
+	if err := s.db.Where("sent_at IS NULL").Order("id").Limit(limit).Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("ошибка чтения очереди публикации: %v", err)
+	}
+	messages := make([]OutboxMessage, 0, len(records))
+	for _, record := range records {
+		messages = append(messages, OutboxMessage{
+			ID:            record.ID,
+			ClientOrderID: record.ClientOrderID,
+			Payload:       []byte(record.Payload),
+		})
+	}
+	return messages, nil
+}
+
+// MarkOutboxSent отмечает результаты ids опубликованными
+func (s *Store) MarkOutboxSent(ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	err := s.db.Model(&outboxRecord{}).Where("id IN ?", ids).Update("sent_at", time.Now().UTC()).Error
+	if err != nil {
+		return fmt.Errorf("ошибка отметки опубликованных результатов: %v", err)
+	}
+	return nil
+}
+
+// PurgeOutbox удаляет результаты, опубликованные раньше before
+func (s *Store) PurgeOutbox(before time.Time) error {
+	if err := s.db.Where("sent_at < ?", before).Delete(&outboxRecord{}).Error; err != nil {
+		return fmt.Errorf("ошибка очистки очереди публикации: %v", err)
+	}
+	return nil
+}
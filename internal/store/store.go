@@ -0,0 +1,52 @@
+package store
+
+import (
+	"fmt"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// Store хранилище ордеров в базе данных: команды и результаты их выполнения, переходы статусов, исполнения
+// на бирже, очередь результатов на публикацию и отозванные токены API. Переживает перезапуск сервиса,
+// поэтому по нему можно найти BinanceID ордера, размещенного стратегией раньше.
+type Store struct {
+	db *gorm.DB
+}
+
+// Open подключается к PostgreSQL по строке подключения dsn и создает хранилище
+func Open(dsn string) (*Store, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Warn)})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения к базе данных: %v", err)
+	}
+	return New(db)
+}
+
+// New создает хранилище поверх готового подключения и применяет миграции. Подключение может быть к любой базе,
+// которую поддерживает GORM, например SQLite в тестах.
+func New(db *gorm.DB) (*Store, error) {
+	store := Store{db: db}
+	if err := store.Migrate(); err != nil {
+		return nil, err
+	}
+	return &store, nil
+}
+
+// Migrate создает таблицы хранилища и добавляет недостающие столбцы и индексы
+func (s *Store) Migrate() error {
+	if err := s.db.AutoMigrate(&orderRecord{}, &eventRecord{}, &fillRecord{}, &outboxRecord{}, &revokedTokenRecord{}); err != nil {
+		return fmt.Errorf("ошибка миграции базы данных: %v", err)
+	}
+	return nil
+}
+
+// Close закрывает подключение к базе данных
+func (s *Store) Close() error {
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
@@ -0,0 +1,354 @@
+package store_test
+
+import (
+	"app/internal/model"
+	"app/internal/store"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// newStore создает хранилище в файле SQLite во временном каталоге теста
+func newStore(t *testing.T) *store.Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "orders.db")
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: gormlogger.Default.LogMode(gormlogger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newOrder(clientOrderID string) model.Order {
+	return model.Order{
+		ClientOrderID:   clientOrderID,
+		Action:          "place_order",
+		StrategyID:      7,
+		Symbol:          "BTCUSDT",
+		Side:            "BUY",
+		Type:            "LIMIT",
+		Quantity:        decimal.NewFromInt(2),
+		Price:           decimal.NewFromInt(100),
+		Status:          model.StatusReceived,
+		StatusUpdatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func tradeReport(clientOrderID string, binanceID, tradeID int64, status string, filled, cumulative string, at time.Time) model.ExecutionReport {
+	return model.ExecutionReport{
+		Symbol:              "BTCUSDT",
+		ClientOrderID:       clientOrderID,
+		BinanceID:           binanceID,
+		Side:                "BUY",
+		ExecutionType:       "TRADE",
+		Status:              status,
+		TradeID:             tradeID,
+		LastFilledQty:       decimal.RequireFromString(filled),
+		LastFilledPrice:     decimal.NewFromInt(100),
+		Commission:          decimal.RequireFromString("0.001"),
+		CommissionAsset:     "BTC",
+		CumulativeFilledQty: decimal.RequireFromString(cumulative),
+		CumulativeQuoteQty:  decimal.RequireFromString(cumulative).Mul(decimal.NewFromInt(100)),
+		TransactionTime:     at,
+	}
+}
+
+// findOrder возвращает единственную запись команды clientOrderID
+func findOrder(t *testing.T, s *store.Store, clientOrderID string) model.Order {
+	t.Helper()
+	orders, err := s.Orders(store.OrderFilter{ClientOrderID: clientOrderID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("записей команды %s: %d, ожидалась 1", clientOrderID, len(orders))
+	}
+	return orders[0]
+}
+
+func TestOrderStatusTransitions(t *testing.T) {
+	s := newStore(t)
+	order := newOrder("order-1")
+
+	if _, err := s.SaveCommand(order); err != nil {
+		t.Fatal(err)
+	}
+	// Повторная доставка команды обновляет ту же запись
+	if _, err := s.SaveCommand(order); err != nil {
+		t.Fatal(err)
+	}
+	if got := findOrder(t, s, "order-1"); got.Status != model.StatusReceived || got.BinanceID != 0 {
+		t.Fatalf("статус %s, BinanceID %d, ожидалась полученная команда", got.Status, got.BinanceID)
+	}
+
+	order.Status = model.StatusNew
+	order.BinanceID = 42
+	order.OrderApiStatus = model.OrderApiStatusSuccess
+	if err := s.SaveResult(order, false); err != nil {
+		t.Fatal(err)
+	}
+	got := findOrder(t, s, "order-1")
+	if got.Status != model.StatusNew || got.BinanceID != 42 || got.OrderApiStatus != model.OrderApiStatusSuccess {
+		t.Fatalf("результат сохранен неверно: статус %s, BinanceID %d, %s", got.Status, got.BinanceID, got.OrderApiStatus)
+	}
+
+	at := time.Date(2024, 1, 2, 3, 5, 0, 0, time.UTC)
+	if err := s.SaveExecutionReport(tradeReport("order-1", 42, 1, model.StatusFilled, "2", "2", at)); err != nil {
+		t.Fatal(err)
+	}
+	if got := findOrder(t, s, "order-1"); got.Status != model.StatusFilled || !got.StatusUpdatedAt.Equal(at) {
+		t.Fatalf("статус %s от %v, ожидался FILLED от %v", got.Status, got.StatusUpdatedAt, at)
+	}
+
+	// Запоздавший результат размещения не откатывает статус из потока пользовательских данных
+	order.Status = model.StatusNew
+	if err := s.SaveResult(order, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := findOrder(t, s, "order-1"); got.Status != model.StatusFilled {
+		t.Fatalf("статус откатился до %s", got.Status)
+	}
+
+	// Отчет о переходе, невозможном из FILLED, не меняет запись
+	canceled := tradeReport("order-1", 42, 0, model.StatusCanceled, "0", "2", at.Add(time.Second))
+	canceled.ExecutionType = "CANCELED"
+	if err := s.SaveExecutionReport(canceled); err != nil {
+		t.Fatal(err)
+	}
+	if got := findOrder(t, s, "order-1"); got.Status != model.StatusFilled {
+		t.Fatalf("статус %s после невозможного перехода", got.Status)
+	}
+}
+
+func TestSaveCommandRefusesAnotherStrategy(t *testing.T) {
+	s := newStore(t)
+	order := newOrder("order-1")
+	if _, err := s.SaveCommand(order); err != nil {
+		t.Fatal(err)
+	}
+	order.Status = model.StatusNew
+	order.BinanceID = 42
+	if err := s.SaveResult(order, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(order *model.Order)
+	}{
+		{"другая стратегия", func(order *model.Order) { order.StrategyID = 8 }},
+		{"другой символ", func(order *model.Order) { order.Symbol = "ETHUSDT" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := newOrder("order-1")
+			tt.change(&other)
+			if _, err := s.SaveCommand(other); !errors.Is(err, store.ErrCommandConflict) {
+				t.Fatalf("ошибка %v, ожидался конфликт команд", err)
+			}
+			// Результат отклоненной команды не перезаписывает исходную
+			other.Status = model.StatusRejected
+			if err := s.SaveResult(other, false); err != nil {
+				t.Fatal(err)
+			}
+			got := findOrder(t, s, "order-1")
+			if got.StrategyID != 7 || got.Symbol != "BTCUSDT" || got.Status != model.StatusNew || got.BinanceID != 42 {
+				t.Fatalf("запись исходной команды изменена: %+v", got)
+			}
+		})
+	}
+
+	// Повторная доставка той же команды распознается
+	seen, err := s.SaveCommand(newOrder("order-1"))
+	if err != nil || !seen {
+		t.Fatalf("повтор распознан %v, ошибка %v", seen, err)
+	}
+}
+
+func TestExecutionReportBeforeResult(t *testing.T) {
+	s := newStore(t)
+	order := newOrder("order-1")
+	if _, err := s.SaveCommand(order); err != nil {
+		t.Fatal(err)
+	}
+
+	// Отчет пришел раньше ответа на запрос размещения: запись находится по ClientOrderID
+	at := time.Date(2024, 1, 2, 3, 5, 0, 0, time.UTC)
+	report := tradeReport("order-1", 42, 1, model.StatusPartiallyFilled, "0.5", "0.5", at)
+	if err := s.SaveExecutionReport(report); err != nil {
+		t.Fatal(err)
+	}
+	got := findOrder(t, s, "order-1")
+	if got.Status != model.StatusPartiallyFilled || got.BinanceID != 42 {
+		t.Fatalf("статус %s, BinanceID %d, ожидались PARTIALLY_FILLED и 42", got.Status, got.BinanceID)
+	}
+
+	order.Status = model.StatusNew
+	order.BinanceID = 42
+	if err := s.SaveResult(order, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := findOrder(t, s, "order-1"); got.Status != model.StatusPartiallyFilled {
+		t.Fatalf("статус откатился до %s", got.Status)
+	}
+}
+
+func TestOrdersFilter(t *testing.T) {
+	s := newStore(t)
+	first := newOrder("order-1")
+	second := newOrder("order-2")
+	second.StrategyID = 8
+	second.Symbol = "ETHUSDT"
+	cancel := newOrder("order-1")
+	cancel.Action = "cancel_order"
+	for _, order := range []model.Order{first, second, cancel} {
+		if _, err := s.SaveCommand(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter store.OrderFilter
+		want   int
+	}{
+		{"все", store.OrderFilter{}, 3},
+		{"по стратегии", store.OrderFilter{StrategyID: 8}, 1},
+		{"по символам", store.OrderFilter{Symbols: []string{"BTCUSDT"}}, 2},
+		{"по действию", store.OrderFilter{Actions: []string{"place_order"}}, 2},
+		{"по ClientOrderID", store.OrderFilter{ClientOrderID: "order-1"}, 2},
+		{"с ограничением", store.OrderFilter{Limit: 1}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := s.Orders(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(orders) != tt.want {
+				t.Fatalf("найдено %d ордеров, ожидалось %d", len(orders), tt.want)
+			}
+		})
+	}
+
+	// Последние ордера первыми
+	orders, err := s.Orders(store.OrderFilter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders[0].Action != "cancel_order" {
+		t.Fatalf("первым найден %s %s, ожидалась последняя команда", orders[0].ClientOrderID, orders[0].Action)
+	}
+}
+
+func TestOutboxRelay(t *testing.T) {
+	s := newStore(t)
+	for _, id := range []string{"order-1", "order-2", "order-3"} {
+		order := newOrder(id)
+		order.Status = model.StatusNew
+		if err := s.SaveResult(order, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Результат без публикации не попадает в очередь
+	if err := s.SaveResult(newOrder("order-4"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := s.PendingOutbox(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 || pending[0].ClientOrderID != "order-1" || pending[1].ClientOrderID != "order-2" {
+		t.Fatalf("очередь %+v, ожидались order-1 и order-2 по порядку", pending)
+	}
+	var published model.Order
+	if err := json.Unmarshal(pending[0].Payload, &published); err != nil {
+		t.Fatal(err)
+	}
+	if published.ClientOrderID != "order-1" || published.Status != model.StatusNew {
+		t.Fatalf("в очереди неверный результат: %+v", published)
+	}
+
+	if err := s.MarkOutboxSent([]uint{pending[0].ID, pending[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = s.PendingOutbox(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ClientOrderID != "order-3" {
+		t.Fatalf("после публикации в очереди %+v, ожидался order-3", pending)
+	}
+
+	// Очистка удаляет только опубликованные результаты
+	if err := s.PurgeOutbox(time.Now().UTC().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = s.PendingOutbox(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ClientOrderID != "order-3" {
+		t.Fatalf("после очистки в очереди %+v, ожидался order-3", pending)
+	}
+}
+
+func TestFills(t *testing.T) {
+	s := newStore(t)
+	order := newOrder("order-1")
+	if _, err := s.SaveCommand(order); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2024, 1, 2, 3, 5, 0, 0, time.UTC)
+	reports := []model.ExecutionReport{
+		tradeReport("order-1", 42, 2, model.StatusFilled, "1.5", "2", at.Add(time.Second)),
+		tradeReport("order-1", 42, 1, model.StatusPartiallyFilled, "0.5", "0.5", at),
+		// Повтор после переподключения потока
+		tradeReport("order-1", 42, 1, model.StatusPartiallyFilled, "0.5", "0.5", at),
+	}
+	newReport := tradeReport("order-1", 42, 0, model.StatusNew, "0", "0", at.Add(-time.Second))
+	newReport.ExecutionType = "NEW"
+	reports = append(reports, newReport)
+	for _, report := range reports {
+		if err := s.SaveExecutionReport(report); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fills, err := s.Fills(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fills) != 2 {
+		t.Fatalf("сделок %d, ожидалось 2", len(fills))
+	}
+	if fills[0].TradeID != 1 || fills[1].TradeID != 2 {
+		t.Fatalf("сделки %d и %d не по порядку исполнения", fills[0].TradeID, fills[1].TradeID)
+	}
+	fill := fills[1]
+	if fill.ClientOrderID != "order-1" || fill.Side != "BUY" || !fill.Quantity.Equal(decimal.RequireFromString("1.5")) ||
+		!fill.Price.Equal(decimal.NewFromInt(100)) || fill.CommissionAsset != "BTC" || !fill.Time.Equal(at.Add(time.Second)) {
+		t.Fatalf("сделка сохранена неверно: %+v", fill)
+	}
+	if got := findOrder(t, s, "order-1"); got.Status != model.StatusFilled {
+		t.Fatalf("статус %s, ожидался FILLED", got.Status)
+	}
+
+	if fills, err := s.Fills(43); err != nil || len(fills) != 0 {
+		t.Fatalf("сделки чужого ордера: %v, %v", fills, err)
+	}
+}
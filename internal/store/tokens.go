@@ -0,0 +1,43 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// revokedTokenRecord отозванный токен. Хранится до окончания срока действия токена
+type revokedTokenRecord struct {
+	ID        string    `gorm:"primaryKey;size:64"`
+	ExpiresAt time.Time `gorm:"index"`
+	RevokedAt time.Time
+}
+
+func (revokedTokenRecord) TableName() string { return "revoked_tokens" }
+
+// RevokeToken сохраняет отзыв токена id, действующего до expiresAt
+func (s *Store) RevokeToken(id string, expiresAt time.Time) error {
+	record := revokedTokenRecord{ID: id, ExpiresAt: expiresAt.UTC(), RevokedAt: time.Now().UTC()}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&record).Error; err != nil {
+		return fmt.Errorf("ошибка сохранения отзыва токена: %v", err)
+	}
+	return nil
+}
+
+// RevokedTokens возвращает отозванные токены, которые еще действуют в момент now, и время окончания их действия.
+// Истекшие записи удаляются
+func (s *Store) RevokedTokens(now time.Time) (map[string]time.Time, error) {
+	if err := s.db.Where("expires_at < ?", now.UTC()).Delete(&revokedTokenRecord{}).Error; err != nil {
+		return nil, fmt.Errorf("ошибка удаления истекших отзывов токенов: %v", err)
+	}
+	var records []revokedTokenRecord
+	if err := s.db.Find(&records).Error; err != nil {
+		return nil, fmt.Errorf("ошибка чтения отозванных токенов: %v", err)
+	}
+	revoked := make(map[string]time.Time, len(records))
+	for _, record := range records {
+		revoked[record.ID] = record.ExpiresAt
+	}
+	return revoked, nil
+}